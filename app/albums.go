@@ -0,0 +1,149 @@
+package main
+
+import (
+	"errors"
+	"slices"
+
+	tb "gopkg.in/telebot.v3"
+
+	"github.com/graynk/distortioner/distorters"
+	"github.com/graynk/distortioner/locale"
+	"github.com/graynk/distortioner/tools"
+)
+
+// ApplyAlbumMiddleware holds album items until the whole album arrives, so that it gets distorted as one
+func (d DistorterBot) ApplyAlbumMiddleware(h tb.HandlerFunc) tb.HandlerFunc {
+	return func(c tb.Context) error {
+		m := c.Message()
+		// auto-distortion rolls the dice for each item separately, so there's no whole album to wait for
+		if m.AlbumID == "" || isAuto(c) {
+			return h(c)
+		}
+		b := c.Bot()
+		// the shutdown waits for the album from the moment its first item arrives, not once the window is over
+		d.graceWg.Add(1)
+		started := d.albums.Collect(m, func(items []*tb.Message) {
+			defer d.graceWg.Done()
+			d.handleAlbum(b, items, Arguments{})
+		})
+		if !started {
+			d.graceWg.Done()
+		}
+		return nil
+	}
+}
+
+func isAlbumItem(m *tb.Message) bool {
+	return m.Photo != nil || m.Video != nil
+}
+
+func (d DistorterBot) handleAlbum(b *tb.Bot, items []*tb.Message, arguments Arguments) {
+	items = slices.DeleteFunc(items, func(m *tb.Message) bool {
+		return !isAlbumItem(m)
+	})
+	if len(items) == 0 {
+		return
+	}
+	c := withArguments(b.NewContext(tb.Update{Message: items[0]}), arguments)
+	lang := d.language(c)
+	if d.rateLimited(c, lang) {
+		return
+	}
+	scratch := d.newScratch(c)
+	if scratch == nil {
+		return
+	}
+	hasVideos := slices.ContainsFunc(items, func(m *tb.Message) bool {
+		return m.Video != nil
+	})
+	if !hasVideos {
+		defer scratch.Close()
+		d.distortAlbum(c, scratch, items)
+		return
+	}
+	// the whole album is a single job, otherwise the per-user queue limit would cut it in half
+	cost := 0.0
+	for _, item := range items {
+		cost += messageCost(item, d.options(c))
+	}
+	err := d.videoWorker.SubmitTier(c.Chat().ID, cost, d.tier(c).Queue, func() {
+		defer scratch.Close()
+		d.distortAlbum(c, scratch, items)
+	})
+	if err != nil {
+		scratch.Close()
+		d.notify(c, queueErrorMessage(lang, err))
+		return
+	}
+	if d.videoWorker.IsBusy() {
+		d.notify(c, locale.Get(lang, locale.Queued))
+	}
+}
+
+func (d DistorterBot) distortAlbum(c tb.Context, scratch *tools.Scratch, items []*tb.Message) {
+	b := c.Bot()
+	lang := d.language(c)
+	progressMessage, _ := d.startProgress(c, locale.Downloading)
+	album := make(tb.Album, 0, len(items))
+	failed := 0
+	for _, item := range items {
+		media, err := d.distortAlbumItem(d.withSettingsOf(withArguments(b.NewContext(tb.Update{Message: item}), arguments(c)), c), scratch)
+		if err != nil {
+			failed++
+			d.logger.Error(err)
+			continue
+		}
+		album = append(album, media)
+	}
+
+	var err error
+	switch len(album) {
+	case 0:
+		d.DoneMessageWithRepeater(c, progressMessage, true)
+		if progressMessage == nil {
+			d.notify(c, locale.Get(lang, locale.Failed))
+		}
+		return
+	case 1:
+		err = d.SendMessageWithRepeater(c, album[0])
+	default:
+		err = d.SendAlbumWithRepeater(c, album)
+	}
+	d.DoneMessageWithRepeater(c, progressMessage, err != nil)
+	if err != nil {
+		d.logger.Error(err)
+		return
+	}
+	if failed > 0 {
+		d.notify(c, locale.Get(lang, locale.AlbumPartFailed, failed, len(items)))
+	}
+}
+
+// distortAlbumItem distorts a single photo or video from the album into the album's scratch directory.
+// Returns the media to put into the resulting album
+func (d DistorterBot) distortAlbumItem(c tb.Context, scratch *tools.Scratch) (tb.Inputtable, error) {
+	m := c.Message()
+	if m.Video != nil && m.Video.FileSize > d.maxFileSize(c) {
+		return nil, errors.New("album video is too big")
+	}
+	filename, err := tools.JustGetTheFile(c.Bot(), d.botAPI, m, d.language(c), scratch)
+	if err != nil {
+		return nil, err
+	}
+	if m.Photo != nil {
+		err = distorters.DistortImagePasses(d.ctx, filename, d.options(c))
+		if err != nil {
+			d.keepFailed(c, filename, err)
+			return nil, err
+		}
+		caption, _ := d.caption(c) // Telegram only takes the formatting for the album as a whole
+		return &tb.Photo{File: tb.FromDisk(filename), Caption: caption}, nil
+	}
+	output, _, err := d.DistortVideoFile(c, nil, filename)
+	if err != nil {
+		d.keepFailed(c, filename, err)
+		return nil, err
+	}
+	caption, _ := d.caption(c)
+	return &tb.Video{File: tb.FromDisk(output), Caption: caption}, nil
+}
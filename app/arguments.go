@@ -0,0 +1,137 @@
+package main
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+
+	tb "gopkg.in/telebot.v3"
+
+	"github.com/graynk/distortioner/distorters"
+)
+
+const argumentsKey = "arguments"
+
+// Arguments override the chat settings for a single /distort request, e.g. /distort voice=robot text=zalgo 0:30-0:50 x3
+type Arguments struct {
+	SoundPreset string
+	TextMode    distorters.TextMode
+	Start       float64 // the range of the video to distort, in seconds
+	End         float64 // 0 for "until the limit"
+	Passes      int     // how many times to distort it, capped at distorters.MaxPasses
+}
+
+// ArgumentError is returned for the arguments we don't understand
+type ArgumentError struct {
+	Argument string
+}
+
+func (e ArgumentError) Error() string {
+	return "unknown argument: " + e.Argument
+}
+
+func ParseArguments(args []string) (Arguments, error) {
+	var arguments Arguments
+	for _, arg := range args {
+		if arg == "" {
+			continue
+		}
+		key, value, found := strings.Cut(strings.ToLower(arg), "=")
+		if !found {
+			if passes, ok := parsePasses(key); ok {
+				arguments.Passes = passes
+				continue
+			}
+			start, end, err := parseRange(arg)
+			if err != nil {
+				return arguments, ArgumentError{Argument: arg}
+			}
+			arguments.Start, arguments.End = start, end
+			continue
+		}
+		switch {
+		case key == "voice" && distorters.IsSoundPreset(value):
+			arguments.SoundPreset = value
+		case key == "text" && distorters.IsTextMode(value):
+			arguments.TextMode = distorters.TextMode(value)
+		default:
+			return arguments, ArgumentError{Argument: arg}
+		}
+	}
+	return arguments, nil
+}
+
+// parsePasses parses repeats like x3, anything above distorters.MaxPasses is capped
+func parsePasses(arg string) (int, bool) {
+	count, found := strings.CutPrefix(arg, "x")
+	if !found {
+		return 0, false
+	}
+	passes, err := strconv.Atoi(count)
+	if err != nil || passes < 1 {
+		return 0, false
+	}
+	return min(passes, distorters.MaxPasses), true
+}
+
+// parseRange parses ranges like 0:30-0:50. Either side can be omitted: 0:30- is everything after 0:30
+func parseRange(arg string) (float64, float64, error) {
+	from, to, found := strings.Cut(arg, "-")
+	if !found || (from == "" && to == "") {
+		return 0, 0, errors.New("not a range")
+	}
+	var start, end float64
+	var err error
+	if from != "" {
+		start, err = distorters.ParseTimestamp(from)
+		if err != nil {
+			return 0, 0, err
+		}
+	}
+	if to != "" {
+		end, err = distorters.ParseTimestamp(to)
+		if err != nil {
+			return 0, 0, err
+		}
+		if end <= start {
+			return 0, 0, errors.New("range ends before it starts")
+		}
+	}
+	return start, end, nil
+}
+
+// apply puts the overrides on top of the options from the chat settings
+func (a Arguments) apply(options distorters.Options) distorters.Options {
+	if a.SoundPreset != "" {
+		options.SoundPreset = a.SoundPreset
+	}
+	if a.TextMode != "" {
+		options.TextMode = a.TextMode
+	}
+	if a.Passes > 0 {
+		options.Passes = a.Passes
+	}
+	options.Start = a.Start
+	if a.End > 0 {
+		options.Length = a.End - a.Start
+	}
+	return options
+}
+
+func textModeNames() []string {
+	names := make([]string, len(distorters.TextModes))
+	for i, mode := range distorters.TextModes {
+		names[i] = string(mode)
+	}
+	return names
+}
+
+func withArguments(c tb.Context, arguments Arguments) tb.Context {
+	c.Set(argumentsKey, arguments)
+	return c
+}
+
+func arguments(c tb.Context) Arguments {
+	arguments, _ := c.Get(argumentsKey).(Arguments)
+	return arguments
+}
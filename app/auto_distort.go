@@ -0,0 +1,40 @@
+package main
+
+import (
+	"math/rand"
+
+	tb "gopkg.in/telebot.v3"
+
+	"github.com/graynk/distortioner/stats"
+)
+
+const autoDistortKey = "auto"
+
+// shouldAutoDistort decides whether a group message that wasn't addressed to the bot gets distorted anyway
+func (d DistorterBot) shouldAutoDistort(c tb.Context) bool {
+	mediaType := stats.MediaTypeOf(c.Message())
+	if mediaType == 0 {
+		return false
+	}
+	settings := d.settings(c)
+	if !settings.AutoDistort || settings.AutoChance == 0 || settings.AutoMedia&mediaType == 0 {
+		return false
+	}
+	return rand.Intn(100) < settings.AutoChance
+}
+
+// ApplyAutoDistortMiddleware marks the media that reached the handlers on its own in groups,
+// which only happens in auto-distort mode. Such requests stay quiet about everything except the result
+func (d DistorterBot) ApplyAutoDistortMiddleware(h tb.HandlerFunc) tb.HandlerFunc {
+	return func(c tb.Context) error {
+		if c.Message().FromGroup() {
+			c.Set(autoDistortKey, true)
+		}
+		return h(c)
+	}
+}
+
+func isAuto(c tb.Context) bool {
+	auto, _ := c.Get(autoDistortKey).(bool)
+	return auto
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"go.uber.org/zap"
+	tb "gopkg.in/telebot.v3"
+
+	"github.com/graynk/distortioner/stats"
+)
+
+// the kinds of media whose results get cached. Documents and music depend on the file name and tags too much
+var cachedKinds = map[string]bool{
+	"animation": true,
+	"sticker":   true,
+	"photo":     true,
+	"video":     true,
+	"videonote": true,
+	"voice":     true,
+}
+
+// resultKey identifies the result of distorting the media with the current options.
+// Has to be taken before the handling starts, since clipping changes the options. Empty if it can't be cached
+func (d DistorterBot) resultKey(c tb.Context) string {
+	m := c.Message()
+	kind := stats.MessageType(m)
+	if !cachedKinds[kind] || isRetry(c) {
+		return ""
+	}
+	file := m.Media().MediaFile()
+	if file.UniqueID == "" {
+		return ""
+	}
+	options := d.options(c)
+	options.TextMode = "" // captions get distorted anew every time anyway
+	hasSound := kind == "video" || kind == "videonote" || kind == "voice"
+	if !hasSound {
+		options.SoundPreset = ""
+	}
+	// whether the soundtrack gets distorted is not in the options, but changes the result all the same
+	distortAudio := hasSound && d.settings(c).DistortAudio
+	return fmt.Sprintf("%s:%s:%+v:%t", kind, file.UniqueID, options, distortAudio)
+}
+
+// sendCached answers with the result of distorting the same media earlier, if there is one. Returns true if it did
+func (d DistorterBot) sendCached(c tb.Context, key string) bool {
+	if key == "" {
+		return false
+	}
+	result, ok, err := d.db.GetCached(key)
+	if err != nil {
+		d.logger.Error(err)
+		return false
+	} else if !ok {
+		return false
+	}
+	if result.Trimmed {
+		c.Set(clippedKey, clipped{Start: result.Start, End: result.End, Trimmed: true})
+	}
+	file := tb.File{FileID: result.FileID}
+	caption, entities := d.caption(c)
+	var cached interface{}
+	switch result.Kind {
+	case "animation":
+		cached = &tb.Animation{File: file, Caption: caption}
+	case "video":
+		cached = &tb.Video{File: file, Caption: caption}
+	case "photo":
+		cached = &tb.Photo{File: file, Caption: caption}
+	case "sticker":
+		cached = &tb.Sticker{File: file}
+	case "videonote":
+		cached = &tb.VideoNote{File: file}
+	case "voice":
+		cached = &tb.Voice{File: file}
+	default:
+		return false
+	}
+	err = d.SendMessageWithRepeater(c, cached, d.resultButtons(c, entities)...)
+	if err != nil {
+		// the file might be gone from Telegram, so it's better to distort it all over again
+		d.logger.Warnw("failed to send the cached result", "error", err)
+		if err = d.db.DeleteCached(key); err != nil {
+			d.logger.Error(err)
+		}
+		return false
+	}
+	if note := d.trimNote(c); result.Kind == "videonote" && note != "" {
+		d.notify(c, note)
+	}
+	return true
+}
+
+// sendAndCache sends the distorted media, remembering its file ID for the next time somebody asks for the same thing
+func (d DistorterBot) sendAndCache(c tb.Context, key string, toSend interface{}, opts ...interface{}) error {
+	sent, err := d.SendMessage(c, toSend, d.method(d.settings(c)), d.resultButtons(c, opts...)...)
+	if err != nil || sent == nil || key == "" {
+		return err
+	}
+	media := sent.Media()
+	if media == nil {
+		return nil
+	}
+	result := stats.CachedResult{
+		Kind:   stats.MessageType(sent),
+		FileID: media.MediaFile().FileID,
+	}
+	if clip, _ := clipOf(c); clip.Trimmed {
+		result.Trimmed, result.Start, result.End = true, clip.Start, clip.End
+	}
+	err = d.db.SaveCached(key, result)
+	if err != nil {
+		d.logger.Error(err)
+	}
+	return nil
+}
+
+// startPruning keeps the result cache within the bounds from the environment in the background
+// (30 days and 100000 results by default), forgetting the old job descriptors along the way
+func startPruning(db *stats.DistortionerDB, logger *zap.SugaredLogger) error {
+	ttl := 30 * 24 * time.Hour
+	size := 100000
+	var err error
+	if value := os.Getenv("DISTORTIONER_CACHE_TTL"); value != "" {
+		ttl, err = time.ParseDuration(value)
+		if err != nil {
+			return fmt.Errorf("DISTORTIONER_CACHE_TTL: %w", err)
+		}
+	}
+	if value := os.Getenv("DISTORTIONER_CACHE_SIZE"); value != "" {
+		size, err = strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("DISTORTIONER_CACHE_SIZE: %w", err)
+		}
+	}
+	go func() {
+		for {
+			removed, err := db.PruneCache(ttl, size)
+			if err != nil {
+				logger.Error(err)
+			} else if removed > 0 {
+				logger.Infow("pruned the result cache", "removed", removed)
+			}
+			removed, err = db.PruneJobs(jobTTL)
+			if err != nil {
+				logger.Error(err)
+			} else if removed > 0 {
+				logger.Infow("forgot old jobs", "removed", removed)
+			}
+			time.Sleep(time.Hour)
+		}
+	}()
+	return nil
+}
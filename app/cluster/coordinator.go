@@ -0,0 +1,411 @@
+package cluster
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const maxProgressReport = 4096 // bytes, the reports are a line of text
+
+type task struct {
+	id       string
+	spec     json.RawMessage
+	input    string
+	output   string
+	reports  chan string // never closed, the reports that don't fit are dropped
+	done     chan error  // gets exactly one value
+	attempts int
+	worker   string
+	deadline time.Time
+}
+
+// workerState is what the coordinator knows about a worker
+type workerState struct {
+	seen  time.Time // when it was last heard from
+	slots int       // how many tasks it takes at once
+}
+
+// Coordinator keeps the tasks for the workers and serves the protocol to them, see the package docs
+type Coordinator struct {
+	token        string
+	leaseTimeout time.Duration
+	pollTimeout  time.Duration // how long a lease request waits for a task, well within leaseTimeout
+	maxAttempts  int
+
+	mu      *sync.Mutex
+	pending []*task
+	leased  map[string]*task
+	workers map[string]workerState
+	queued  chan struct{} // closed and replaced whenever there's a new task to lease
+	nextID  uint64
+
+	notifying *sync.Mutex // one onSlots call at a time, taken before mu and held while onSlots runs
+	onSlots   func(int)
+	reported  int // the total onSlots was last called with
+}
+
+// NewCoordinator creates a coordinator that only talks to the workers with the token. An empty token lets anybody in,
+// which is only good for sockets nobody else can reach
+func NewCoordinator(token string, leaseTimeout time.Duration) *Coordinator {
+	return &Coordinator{
+		token:        token,
+		leaseTimeout: leaseTimeout,
+		pollTimeout:  leaseTimeout / 2,
+		maxAttempts:  DefaultMaxAttempts,
+		mu:           &sync.Mutex{},
+		leased:       make(map[string]*task),
+		workers:      make(map[string]workerState),
+		queued:       make(chan struct{}),
+		notifying:    &sync.Mutex{},
+	}
+}
+
+// OnSlots sets what gets called with the total of the workers' slots whenever it changes. A worker counts from its first
+// request until it's been silent for the lease timeout. The calls come one at a time, each with the latest total,
+// and the coordinator isn't locked while they run
+func (c *Coordinator) OnSlots(onSlots func(int)) {
+	c.notifying.Lock()
+	defer c.notifying.Unlock()
+
+	c.onSlots = onSlots
+}
+
+// Workers returns the number of workers heard from within the lease timeout
+func (c *Coordinator) Workers() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return len(c.workers)
+}
+
+// Slots returns how many tasks the workers heard from within the lease timeout take at once, all together
+func (c *Coordinator) Slots() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.slots()
+}
+
+func (c *Coordinator) slots() int {
+	slots := 0
+	for _, worker := range c.workers {
+		slots += worker.slots
+	}
+	return slots
+}
+
+// Process queues the task and waits until a worker uploads the output, reporting the progress until the channel
+// is closed. The input has to stay where it is until then. Cancelling the context takes the task away from
+// whoever has it. spec is marshalled to JSON for the worker
+func (c *Coordinator) Process(ctx context.Context, spec any, input, output string, progressChan chan string) error {
+	defer close(progressChan)
+	encoded, err := json.Marshal(spec)
+	if err != nil {
+		return err
+	}
+	t := &task{
+		spec:    encoded,
+		input:   input,
+		output:  output,
+		reports: make(chan string, 3),
+		done:    make(chan error, 1),
+	}
+	c.mu.Lock()
+	c.nextID++
+	t.id = strconv.FormatUint(c.nextID, 10)
+	c.enqueue(t, false)
+	c.mu.Unlock()
+
+	for {
+		select {
+		case report := <-t.reports:
+			progressChan <- report
+		case err = <-t.done:
+			return err
+		case <-ctx.Done():
+			c.mu.Lock()
+			c.remove(t)
+			c.mu.Unlock()
+			return ctx.Err()
+		}
+	}
+}
+
+// Expire takes the tasks away from the workers that haven't extended their leases in time and forgets the workers
+// that have been silent for as long. The lease requests do that as well, this is for when nobody asks
+func (c *Coordinator) Expire(now time.Time) {
+	defer c.notifySlots()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.expire(now)
+}
+
+// StartExpiring calls Expire every now and then until the context is cancelled
+func (c *Coordinator) StartExpiring(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(max(c.leaseTimeout/4, time.Nanosecond))
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case now := <-ticker.C:
+				c.Expire(now)
+			}
+		}
+	}()
+}
+
+func (c *Coordinator) expire(now time.Time) {
+	for id, t := range c.leased {
+		if now.Before(t.deadline) {
+			continue
+		}
+		delete(c.leased, id)
+		if t.attempts >= c.maxAttempts {
+			t.done <- ErrTooManyAttempts
+			continue
+		}
+		c.enqueue(t, true)
+	}
+	for name, worker := range c.workers {
+		if now.Sub(worker.seen) >= c.leaseTimeout {
+			delete(c.workers, name)
+		}
+	}
+}
+
+// notifySlots lets onSlots know if the total is not what it was. It's called with the coordinator unlocked,
+// after whatever might have changed the workers
+func (c *Coordinator) notifySlots() {
+	c.notifying.Lock()
+	defer c.notifying.Unlock()
+
+	c.mu.Lock()
+	slots := c.slots()
+	c.mu.Unlock()
+	if slots == c.reported {
+		return
+	}
+	c.reported = slots
+	if c.onSlots != nil {
+		c.onSlots(slots)
+	}
+}
+
+// enqueue puts the task up for leasing. The expired ones go first, they've waited long enough
+func (c *Coordinator) enqueue(t *task, first bool) {
+	t.worker = ""
+	if first {
+		c.pending = append([]*task{t}, c.pending...)
+	} else {
+		c.pending = append(c.pending, t)
+	}
+	close(c.queued)
+	c.queued = make(chan struct{})
+}
+
+func (c *Coordinator) remove(t *task) {
+	delete(c.leased, t.id)
+	for i, pending := range c.pending {
+		if pending == t {
+			c.pending = append(c.pending[:i], c.pending[i+1:]...)
+			break
+		}
+	}
+}
+
+// seen notes that the worker is alive. slots is 0 for the requests that don't say, which keep what the worker said before
+func (c *Coordinator) seen(worker string, slots int, now time.Time) {
+	state, known := c.workers[worker]
+	if slots > 0 {
+		state.slots = slots
+	} else if !known {
+		state.slots = 1
+	}
+	state.seen = now
+	c.workers[worker] = state
+}
+
+// held returns the task if the worker still holds it, extending the lease
+func (c *Coordinator) held(id, worker string) *task {
+	defer c.notifySlots()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	c.seen(worker, 0, now)
+	t, ok := c.leased[id]
+	if !ok || t.worker != worker {
+		return nil
+	}
+	t.deadline = now.Add(c.leaseTimeout)
+	return t
+}
+
+// complete finishes the task if the worker still holds it
+func (c *Coordinator) complete(t *task, worker string, err error) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.leased[t.id] != t || t.worker != worker {
+		return false
+	}
+	delete(c.leased, t.id)
+	t.done <- err
+	return true
+}
+
+func (c *Coordinator) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if c.token != "" && subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), []byte("Bearer "+c.token)) != 1 {
+		http.Error(w, "wrong token", http.StatusUnauthorized)
+		return
+	}
+	worker := r.Header.Get(headerWorker)
+	if worker == "" {
+		http.Error(w, headerWorker+" is not set", http.StatusBadRequest)
+		return
+	}
+	if r.URL.Path == "/lease" && r.Method == http.MethodPost {
+		slots := 1
+		if value := r.Header.Get(headerSlots); value != "" {
+			var err error
+			slots, err = strconv.Atoi(value)
+			if err != nil || slots < 1 {
+				http.Error(w, headerSlots+" is not a positive number", http.StatusBadRequest)
+				return
+			}
+		}
+		c.lease(w, r, worker, slots)
+		return
+	}
+	id, action, ok := strings.Cut(strings.TrimPrefix(r.URL.Path, "/tasks/"), "/")
+	if !ok || !strings.HasPrefix(r.URL.Path, "/tasks/") {
+		http.NotFound(w, r)
+		return
+	}
+	if (action == "input") != (r.Method == http.MethodGet) || (r.Method != http.MethodGet && r.Method != http.MethodPost) {
+		http.Error(w, r.Method+" is not allowed here", http.StatusMethodNotAllowed)
+		return
+	}
+	t := c.held(id, worker)
+	if t == nil {
+		http.Error(w, errLeaseLost.Error(), http.StatusGone)
+		return
+	}
+	switch action {
+	case "input":
+		http.ServeFile(w, r, t.input)
+	case "heartbeat":
+		w.WriteHeader(http.StatusNoContent)
+	case "progress":
+		report, err := io.ReadAll(io.LimitReader(r.Body, maxProgressReport))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		select {
+		case t.reports <- string(report):
+		default: // the bot is behind, the next report will do
+		}
+		w.WriteHeader(http.StatusNoContent)
+	case "result":
+		c.result(w, r, t, worker)
+	case "fail":
+		var failure Failure
+		if err := json.NewDecoder(r.Body).Decode(&failure); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if !c.complete(t, worker, (*TaskError)(&failure)) {
+			http.Error(w, errLeaseLost.Error(), http.StatusGone)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (c *Coordinator) lease(w http.ResponseWriter, r *http.Request, worker string, slots int) {
+	ctx, cancel := context.WithTimeout(r.Context(), c.pollTimeout)
+	defer cancel()
+	for {
+		c.mu.Lock()
+		now := time.Now()
+		c.expire(now)
+		c.seen(worker, slots, now)
+		if len(c.pending) > 0 {
+			t := c.pending[0]
+			c.pending = c.pending[1:]
+			t.attempts++
+			t.worker = worker
+			t.deadline = now.Add(c.leaseTimeout)
+			c.leased[t.id] = t
+			lease := Lease{
+				ID:      t.id,
+				Spec:    t.spec,
+				Input:   filepath.Base(t.input),
+				Output:  filepath.Base(t.output),
+				Timeout: c.leaseTimeout,
+			}
+			c.mu.Unlock()
+			c.notifySlots()
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(lease)
+			return
+		}
+		queued := c.queued
+		c.mu.Unlock()
+		c.notifySlots()
+
+		select {
+		case <-queued:
+		case <-ctx.Done():
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+	}
+}
+
+// result stores the upload next to where the output goes and only moves it there if the lease held till the end,
+// so that a worker that lost the task can't overwrite what the next one uploaded
+func (c *Coordinator) result(w http.ResponseWriter, r *http.Request, t *task, worker string) {
+	upload, err := os.CreateTemp(filepath.Dir(t.output), filepath.Base(t.output)+".*.part")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer os.Remove(upload.Name())
+	_, err = io.Copy(upload, r.Body)
+	if closeErr := upload.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.leased[t.id] != t || t.worker != worker {
+		http.Error(w, errLeaseLost.Error(), http.StatusGone)
+		return
+	}
+	if err = os.Rename(upload.Name(), t.output); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	delete(c.leased, t.id)
+	t.done <- nil
+	w.WriteHeader(http.StatusNoContent)
+}
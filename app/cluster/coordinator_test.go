@@ -0,0 +1,338 @@
+package cluster
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"github.com/graynk/distortioner/tools"
+)
+
+const testToken = "secret"
+
+// shout is the work the tests hand out: the output is the input in upper case
+func shout(ctx context.Context, spec json.RawMessage, input, output string, progressChan chan string) error {
+	defer close(progressChan)
+	data, err := os.ReadFile(input)
+	if err != nil {
+		return err
+	}
+	progressChan <- "shouting"
+	return os.WriteFile(output, []byte(strings.ToUpper(string(data))), 0644)
+}
+
+func startCoordinator(t *testing.T, leaseTimeout time.Duration) (*Coordinator, string) {
+	coordinator := NewCoordinator(testToken, leaseTimeout)
+	server := httptest.NewServer(coordinator)
+	t.Cleanup(server.Close)
+	return coordinator, server.URL
+}
+
+// startWorker runs the worker with that many slots until the end of the test
+func startWorker(t *testing.T, url, name string, slots int, handler Handler) {
+	workspace, err := tools.NewWorkspace(filepath.Join(t.TempDir(), name), 0)
+	require.NoError(t, err)
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		NewWorker(url, testToken, name, slots, workspace, handler, zap.NewNop().Sugar()).Run(ctx)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-stopped
+	})
+}
+
+type result struct {
+	output  string
+	reports []string
+	err     error
+}
+
+// process runs the task with the text for the input in the background
+func process(t *testing.T, ctx context.Context, coordinator *Coordinator, text string) chan result {
+	dir := t.TempDir()
+	input := filepath.Join(dir, "input.txt")
+	require.NoError(t, os.WriteFile(input, []byte(text), 0644))
+	results := make(chan result, 1)
+	go func() {
+		output := filepath.Join(dir, "output.txt")
+		progressChan := make(chan string)
+		errChan := make(chan error, 1)
+		go func() {
+			errChan <- coordinator.Process(ctx, map[string]string{"text": text}, input, output, progressChan)
+		}()
+		var r result
+		for report := range progressChan {
+			r.reports = append(r.reports, report)
+		}
+		r.err = <-errChan
+		data, _ := os.ReadFile(output)
+		r.output = string(data)
+		results <- r
+	}()
+	return results
+}
+
+func wait(t *testing.T, results chan result) result {
+	select {
+	case r := <-results:
+		return r
+	case <-time.After(10 * time.Second):
+		t.Fatal("the task is taking too long")
+		return result{}
+	}
+}
+
+// call makes a request the way a worker would, returning the status
+func call(t *testing.T, url, worker, path string, body string) (int, string) {
+	request, err := http.NewRequest(http.MethodPost, url+path, strings.NewReader(body))
+	require.NoError(t, err)
+	request.Header.Set(headerWorker, worker)
+	request.Header.Set("Authorization", "Bearer "+testToken)
+	response, err := http.DefaultClient.Do(request)
+	require.NoError(t, err)
+	defer response.Body.Close()
+	reply, err := io.ReadAll(response.Body)
+	require.NoError(t, err)
+	return response.StatusCode, string(reply)
+}
+
+// leaseAs leases a task and never does anything about it, like a worker that died right away
+func leaseAs(t *testing.T, url, worker string) Lease {
+	status, reply := call(t, url, worker, "/lease", "")
+	require.Equal(t, http.StatusOK, status, reply)
+	var lease Lease
+	require.NoError(t, json.Unmarshal([]byte(reply), &lease))
+	return lease
+}
+
+func TestCoordinatorProcess(t *testing.T) {
+	coordinator, url := startCoordinator(t, time.Second)
+	for i := 0; i < 3; i++ {
+		startWorker(t, url, fmt.Sprintf("worker-%d", i), 1, shout)
+	}
+	results := make([]chan result, 10)
+	for i := range results {
+		results[i] = process(t, context.Background(), coordinator, fmt.Sprintf("task %d", i))
+	}
+	for i, results := range results {
+		r := wait(t, results)
+		require.NoError(t, r.err)
+		assert.Equal(t, fmt.Sprintf("TASK %d", i), r.output)
+		assert.Equal(t, []string{"shouting"}, r.reports)
+	}
+	assert.Equal(t, 3, coordinator.Workers())
+}
+
+func TestCoordinatorLease(t *testing.T) {
+	coordinator, url := startCoordinator(t, time.Second)
+	process(t, context.Background(), coordinator, "hello")
+	lease := leaseAs(t, url, "worker")
+	assert.Equal(t, "input.txt", lease.Input)
+	assert.Equal(t, "output.txt", lease.Output)
+	assert.Equal(t, time.Second, lease.Timeout)
+	assert.JSONEq(t, `{"text": "hello"}`, string(lease.Spec))
+
+	status, _ := call(t, url, "worker", "/lease", "")
+	assert.Equal(t, http.StatusNoContent, status, "there's nothing else to lease")
+	status, _ = call(t, url, "somebody else", "/tasks/"+lease.ID+"/heartbeat", "")
+	assert.Equal(t, http.StatusGone, status, "the task is not theirs")
+	status, _ = call(t, url, "worker", "/tasks/"+lease.ID+"/heartbeat", "")
+	assert.Equal(t, http.StatusNoContent, status)
+}
+
+func TestCoordinatorFailure(t *testing.T) {
+	coordinator, url := startCoordinator(t, time.Second)
+	startWorker(t, url, "worker", 1, func(ctx context.Context, spec json.RawMessage, input, output string, progressChan chan string) error {
+		close(progressChan)
+		if strings.Contains(string(spec), "coded") {
+			return fmt.Errorf("wrapped: %w", &TaskError{Code: "encode", Message: "no encoder"})
+		}
+		return errors.New("no luck")
+	})
+
+	r := wait(t, process(t, context.Background(), coordinator, "coded"))
+	var taskError *TaskError
+	require.ErrorAs(t, r.err, &taskError)
+	assert.Equal(t, TaskError{Code: "encode", Message: "no encoder"}, *taskError)
+
+	r = wait(t, process(t, context.Background(), coordinator, "plain"))
+	require.ErrorAs(t, r.err, &taskError)
+	assert.Equal(t, TaskError{Message: "no luck"}, *taskError)
+	assert.Empty(t, r.output)
+}
+
+func TestCoordinatorRequeuesExpiredLeases(t *testing.T) {
+	coordinator, url := startCoordinator(t, time.Second)
+	results := process(t, context.Background(), coordinator, "hello")
+	lease := leaseAs(t, url, "dead")
+
+	coordinator.Expire(time.Now().Add(time.Second))
+	startWorker(t, url, "alive", 1, shout)
+	r := wait(t, results)
+	require.NoError(t, r.err)
+	assert.Equal(t, "HELLO", r.output)
+
+	status, _ := call(t, url, "dead", "/tasks/"+lease.ID+"/result", "too late")
+	assert.Equal(t, http.StatusGone, status)
+	assert.Equal(t, "HELLO", r.output)
+}
+
+func TestCoordinatorGivesUp(t *testing.T) {
+	coordinator, url := startCoordinator(t, time.Second)
+	results := process(t, context.Background(), coordinator, "poison")
+	for i := 0; i < DefaultMaxAttempts; i++ {
+		leaseAs(t, url, fmt.Sprintf("victim-%d", i))
+		coordinator.Expire(time.Now().Add(time.Second))
+	}
+	r := wait(t, results)
+	assert.ErrorIs(t, r.err, ErrTooManyAttempts)
+	status, _ := call(t, url, "next", "/lease", "")
+	assert.Equal(t, http.StatusNoContent, status, "the task is gone for good")
+}
+
+func TestCoordinatorCancel(t *testing.T) {
+	coordinator, url := startCoordinator(t, time.Second)
+	ctx, cancel := context.WithCancel(context.Background())
+	results := process(t, ctx, coordinator, "never mind")
+	lease := leaseAs(t, url, "worker")
+	cancel()
+	r := wait(t, results)
+	assert.ErrorIs(t, r.err, context.Canceled)
+	status, _ := call(t, url, "worker", "/tasks/"+lease.ID+"/progress", "halfway")
+	assert.Equal(t, http.StatusGone, status)
+}
+
+// a worker that loses the lease stops working on the task and doesn't get to upload anything
+func TestWorkerDropsLostLeases(t *testing.T) {
+	coordinator, url := startCoordinator(t, 300*time.Millisecond)
+	started := make(chan struct{})
+	abandoned := make(chan error, 1)
+	startWorker(t, url, "worker", 1, func(ctx context.Context, spec json.RawMessage, input, output string, progressChan chan string) error {
+		close(progressChan)
+		close(started)
+		<-ctx.Done()
+		abandoned <- ctx.Err()
+		return os.WriteFile(output, []byte("too late"), 0644)
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	results := process(t, ctx, coordinator, "hello")
+	<-started
+	cancel()
+	select {
+	case err := <-abandoned:
+		assert.ErrorIs(t, err, context.Canceled)
+	case <-time.After(5 * time.Second):
+		t.Fatal("the worker is still on it")
+	}
+	assert.Empty(t, wait(t, results).output)
+}
+
+func TestCoordinatorWorkers(t *testing.T) {
+	coordinator, url := startCoordinator(t, time.Second)
+	var mu sync.Mutex
+	var counts []int
+	coordinator.OnSlots(func(slots int) {
+		mu.Lock()
+		defer mu.Unlock()
+		counts = append(counts, slots)
+		assert.Equal(t, slots, coordinator.Slots(), "the callback may call the coordinator back")
+	})
+	process(t, context.Background(), coordinator, "one")
+	process(t, context.Background(), coordinator, "two")
+	first := leaseAs(t, url, "first")
+	leaseAs(t, url, "second")
+	heard := time.Now()
+	status, _ := call(t, url, "first", "/tasks/"+first.ID+"/heartbeat", "")
+	assert.Equal(t, http.StatusNoContent, status)
+	assert.Equal(t, 2, coordinator.Workers())
+
+	coordinator.Expire(heard.Add(time.Second))
+	assert.Equal(t, 1, coordinator.Workers(), "second has been silent for too long")
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Equal(t, []int{1, 2, 1}, counts)
+}
+
+func TestCoordinatorMultiSlotWorker(t *testing.T) {
+	coordinator, url := startCoordinator(t, time.Second)
+	var mu sync.Mutex
+	var counts []int
+	coordinator.OnSlots(func(slots int) {
+		mu.Lock()
+		defer mu.Unlock()
+		counts = append(counts, slots)
+	})
+	release := make(chan struct{})
+	startWorker(t, url, "big", 3, func(ctx context.Context, spec json.RawMessage, input, output string, progressChan chan string) error {
+		<-release
+		return shout(ctx, spec, input, output, progressChan)
+	})
+	results := make([]chan result, 3)
+	for i := range results {
+		results[i] = process(t, context.Background(), coordinator, fmt.Sprintf("task %d", i))
+	}
+	require.Eventually(t, func() bool {
+		return len(coordinator.leasedBy()) == 3
+	}, 5*time.Second, 10*time.Millisecond, "the worker should take as many tasks as it has slots")
+	assert.Equal(t, 1, coordinator.Workers())
+	assert.Equal(t, 3, coordinator.Slots())
+	close(release)
+	for i, results := range results {
+		r := wait(t, results)
+		require.NoError(t, r.err)
+		assert.Equal(t, fmt.Sprintf("TASK %d", i), r.output)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Equal(t, []int{3}, counts)
+}
+
+func TestCoordinatorBadSlots(t *testing.T) {
+	_, url := startCoordinator(t, time.Second)
+	request, err := http.NewRequest(http.MethodPost, url+"/lease", nil)
+	require.NoError(t, err)
+	request.Header.Set(headerWorker, "worker")
+	request.Header.Set(headerSlots, "none")
+	request.Header.Set("Authorization", "Bearer "+testToken)
+	response, err := http.DefaultClient.Do(request)
+	require.NoError(t, err)
+	response.Body.Close()
+	assert.Equal(t, http.StatusBadRequest, response.StatusCode)
+}
+
+func TestCoordinatorTinyLeaseTimeout(t *testing.T) {
+	coordinator := NewCoordinator(testToken, time.Nanosecond)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	coordinator.StartExpiring(ctx) // a quarter of a nanosecond is no interval for a ticker
+	<-ctx.Done()
+}
+
+func TestCoordinatorToken(t *testing.T) {
+	_, url := startCoordinator(t, time.Second)
+	request, err := http.NewRequest(http.MethodPost, url+"/lease", nil)
+	require.NoError(t, err)
+	request.Header.Set(headerWorker, "intruder")
+	request.Header.Set("Authorization", "Bearer guess")
+	response, err := http.DefaultClient.Do(request)
+	require.NoError(t, err)
+	response.Body.Close()
+	assert.Equal(t, http.StatusUnauthorized, response.StatusCode)
+}
@@ -0,0 +1,138 @@
+package cluster
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"github.com/graynk/distortioner/tools"
+)
+
+// the test binary becomes a worker process when started with these, see startProcess
+const (
+	workerURLEnv   = "CLUSTER_TEST_WORKER_URL"
+	workerNameEnv  = "CLUSTER_TEST_WORKER_NAME"
+	workerStallEnv = "CLUSTER_TEST_WORKER_STALL" // takes the tasks and never finishes them, waiting to be killed
+)
+
+func TestMain(m *testing.M) {
+	url := os.Getenv(workerURLEnv)
+	if url == "" {
+		os.Exit(m.Run())
+	}
+	name := os.Getenv(workerNameEnv)
+	stall := os.Getenv(workerStallEnv) != ""
+	workspace, err := tools.NewWorkspace(filepath.Join(os.TempDir(), "cluster-test-"+name), 0)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	defer os.RemoveAll(workspace.Root())
+	handler := func(ctx context.Context, spec json.RawMessage, input, output string, progressChan chan string) error {
+		if stall {
+			<-ctx.Done()
+		}
+		if err := shout(ctx, spec, input, output, progressChan); err != nil {
+			return err
+		}
+		signed, err := os.OpenFile(output, os.O_APPEND|os.O_WRONLY, 0)
+		if err != nil {
+			return err
+		}
+		defer signed.Close()
+		_, err = signed.WriteString(" by " + name)
+		return err
+	}
+	NewWorker(url, testToken, name, 1, workspace, handler, zap.NewNop().Sugar()).Run(context.Background())
+}
+
+// startProcess runs a worker process until it's killed or the test ends
+func startProcess(t *testing.T, url, name string, stall bool) *exec.Cmd {
+	cmd := exec.Command(os.Args[0])
+	cmd.Env = append(os.Environ(), workerURLEnv+"="+url, workerNameEnv+"="+name)
+	if stall {
+		cmd.Env = append(cmd.Env, workerStallEnv+"=1")
+	}
+	cmd.Stderr = os.Stderr
+	require.NoError(t, cmd.Start())
+	t.Cleanup(func() {
+		cmd.Process.Kill()
+		cmd.Wait()
+	})
+	return cmd
+}
+
+// leasedBy returns who holds the leases at the moment
+func (c *Coordinator) leasedBy() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var workers []string
+	for _, t := range c.leased {
+		workers = append(workers, t.worker)
+	}
+	return workers
+}
+
+func TestWorkerProcesses(t *testing.T) {
+	coordinator := NewCoordinator(testToken, 500*time.Millisecond)
+	server := httptest.NewServer(coordinator)
+	defer server.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	coordinator.StartExpiring(ctx)
+
+	// the first task goes to the only worker there is, which dies with it
+	doomed := startProcess(t, server.URL, "doomed", true)
+	first := process(t, context.Background(), coordinator, "first")
+	require.Eventually(t, func() bool {
+		return slices.Equal(coordinator.leasedBy(), []string{"doomed"})
+	}, 10*time.Second, 10*time.Millisecond)
+	require.NoError(t, doomed.Process.Kill())
+
+	names := []string{"one", "two", "three"}
+	for _, name := range names {
+		startProcess(t, server.URL, name, false)
+	}
+	require.Eventually(t, func() bool {
+		coordinator.mu.Lock()
+		defer coordinator.mu.Unlock()
+		for _, name := range names {
+			if _, ok := coordinator.workers[name]; !ok {
+				return false
+			}
+		}
+		return true
+	}, 10*time.Second, 10*time.Millisecond, "the processes should be up and asking for work")
+	results := make([]chan result, 20)
+	for i := range results {
+		results[i] = process(t, context.Background(), coordinator, fmt.Sprintf("task %d", i))
+	}
+
+	r := wait(t, first)
+	require.NoError(t, r.err)
+	assert.Regexp(t, `^FIRST by (one|two|three)$`, r.output)
+	workers := make(map[string]int)
+	for i, results := range results {
+		r = wait(t, results)
+		require.NoError(t, r.err)
+		text, worker, ok := strings.Cut(r.output, " by ")
+		require.True(t, ok, r.output)
+		assert.Equal(t, fmt.Sprintf("TASK %d", i), text)
+		workers[worker]++
+	}
+	assert.NotContains(t, workers, "doomed")
+	assert.Greater(t, len(workers), 1, "the tasks should have been spread over the processes: %v", workers)
+}
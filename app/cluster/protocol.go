@@ -0,0 +1,55 @@
+// Package cluster hands the work over to worker processes. The bot queues tasks on a Coordinator and waits for them,
+// the workers lease the tasks over HTTP, fetch the inputs, report the progress and upload the results:
+//
+//	POST /lease                  waits a bit for a task, 200 with a Lease or 204 if there's nothing to do.
+//	                             Says how many tasks the worker takes at once in X-Distortioner-Slots
+//	GET  /tasks/{id}/input       the file to work on
+//	POST /tasks/{id}/heartbeat   still on it, extends the lease
+//	POST /tasks/{id}/progress    a progress report in the body, extends the lease as well
+//	POST /tasks/{id}/result      the result in the body, completes the task
+//	POST /tasks/{id}/fail        a Failure in the body, completes the task
+//
+// A lease that isn't extended in time expires, and the task goes to whoever asks next. Anything about a task
+// the worker no longer holds gets 410 Gone, the worker should drop the task then
+package cluster
+
+import (
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+const (
+	DefaultLeaseTimeout = 30 * time.Second
+	DefaultMaxAttempts  = 3 // leases per task, so that a task that kills the workers doesn't kill all of them
+
+	headerWorker = "X-Distortioner-Worker" // who's asking, so that the leases can't be mixed up
+	headerSlots  = "X-Distortioner-Slots"  // how many tasks the worker asking for a lease takes at once, 1 if not set
+)
+
+var (
+	ErrTooManyAttempts = errors.New("the task was leased too many times without anybody finishing it")
+	errLeaseLost       = errors.New("the lease is lost")
+)
+
+// Lease is a task handed over to a worker until it stops heartbeating
+type Lease struct {
+	ID      string
+	Spec    json.RawMessage // whatever the bot wants done, the handler knows what to make of it
+	Input   string          // the base names the files have on the bot side, the extensions might matter
+	Output  string
+	Timeout time.Duration // how long the lease lasts without a heartbeat
+}
+
+// Failure is what the worker reports when the task can't be done
+type Failure struct {
+	Code    string
+	Message string
+}
+
+// TaskError is a Failure as the bot sees it
+type TaskError Failure
+
+func (e *TaskError) Error() string {
+	return "worker: " + e.Message
+}
@@ -0,0 +1,246 @@
+package cluster
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/graynk/distortioner/tools"
+)
+
+const retryAfter = 5 * time.Second // when the coordinator is nowhere to be found
+
+// Handler does the work: reads the input, writes the output and reports the progress until it closes the channel.
+// A *TaskError goes to the bot as it is, any other error gets there with no code
+type Handler func(ctx context.Context, spec json.RawMessage, input, output string, progressChan chan string) error
+
+// Worker leases the tasks from a Coordinator, as many at a time as it has slots, and runs them through the handler
+type Worker struct {
+	url       string
+	token     string
+	name      string
+	slots     int
+	workspace *tools.Workspace
+	handler   Handler
+	client    *http.Client
+	logger    *zap.SugaredLogger
+}
+
+// NewWorker creates a worker for the coordinator at url that takes that many tasks at once. The name has to be unique
+// among the workers, the inputs and outputs get a scratch directory of their own in the workspace
+func NewWorker(url, token, name string, slots int, workspace *tools.Workspace, handler Handler, logger *zap.SugaredLogger) *Worker {
+	return &Worker{
+		url:       strings.TrimSuffix(url, "/"),
+		token:     token,
+		name:      name,
+		slots:     slots,
+		workspace: workspace,
+		handler:   handler,
+		client:    &http.Client{},
+		logger:    logger,
+	}
+}
+
+// Run works until the context is cancelled. Whatever is running at that moment is abandoned,
+// the coordinator hands it to somebody else once the lease expires
+func (w *Worker) Run(ctx context.Context) error {
+	wg := &sync.WaitGroup{}
+	for i := 0; i < w.slots; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			w.work(ctx)
+		}()
+	}
+	wg.Wait()
+	return ctx.Err()
+}
+
+// work keeps one slot busy: leases a task, runs it and asks for the next one
+func (w *Worker) work(ctx context.Context) {
+	for ctx.Err() == nil {
+		lease, err := w.lease(ctx)
+		if err != nil {
+			if ctx.Err() == nil {
+				w.logger.Warnw("can't lease a task", "worker", w.name, "error", err)
+				sleep(ctx, retryAfter)
+			}
+			continue
+		}
+		if lease != nil {
+			w.process(ctx, *lease)
+		}
+	}
+}
+
+func (w *Worker) process(ctx context.Context, lease Lease) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go w.keepAlive(ctx, cancel, lease)
+
+	err := w.run(ctx, lease)
+	if ctx.Err() != nil || errors.Is(err, errLeaseLost) {
+		// either the lease is lost or we're shutting down, nobody is waiting for the outcome from us anymore
+		return
+	}
+	if err != nil {
+		w.logger.Errorw("task failed", "worker", w.name, "task", lease.ID, "error", err)
+		failure := Failure{Message: err.Error()}
+		var taskError *TaskError
+		if errors.As(err, &taskError) {
+			failure = Failure(*taskError)
+		}
+		encoded, _ := json.Marshal(failure)
+		err = w.post(ctx, lease.ID, "fail", bytes.NewReader(encoded))
+	}
+	if err != nil && ctx.Err() == nil && !errors.Is(err, errLeaseLost) {
+		w.logger.Errorw("can't report the outcome", "worker", w.name, "task", lease.ID, "error", err)
+	}
+}
+
+// run does the task, uploading the result if everything went fine
+func (w *Worker) run(ctx context.Context, lease Lease) error {
+	scratch, err := w.workspace.NewJob()
+	if err != nil {
+		return err
+	}
+	defer scratch.Close()
+	input := scratch.Path(filepath.Base(lease.Input))
+	output := scratch.Path(filepath.Base(lease.Output))
+	if err = w.fetch(ctx, lease.ID, input); err != nil {
+		return err
+	}
+
+	progressChan := make(chan string, 3)
+	reported := make(chan struct{})
+	go func() {
+		defer close(reported)
+		for report := range progressChan {
+			if err := w.post(ctx, lease.ID, "progress", strings.NewReader(report)); err != nil && ctx.Err() == nil {
+				w.logger.Warnw("can't report the progress", "worker", w.name, "task", lease.ID, "error", err)
+			}
+		}
+	}()
+	err = w.handler(ctx, lease.Spec, input, output, progressChan)
+	<-reported
+	if err != nil {
+		return err
+	}
+
+	result, err := os.Open(output)
+	if err != nil {
+		return err
+	}
+	defer result.Close()
+	return w.post(ctx, lease.ID, "result", result)
+}
+
+// keepAlive heartbeats until the context is cancelled, cancelling it itself if the lease is lost
+func (w *Worker) keepAlive(ctx context.Context, cancel context.CancelFunc, lease Lease) {
+	ticker := time.NewTicker(lease.Timeout / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		err := w.post(ctx, lease.ID, "heartbeat", nil)
+		if errors.Is(err, errLeaseLost) {
+			w.logger.Warnw("lost the lease", "worker", w.name, "task", lease.ID)
+			cancel()
+			return
+		} else if err != nil && ctx.Err() == nil {
+			// might be a hiccup, the lease will last for a couple more heartbeats
+			w.logger.Warnw("can't heartbeat", "worker", w.name, "task", lease.ID, "error", err)
+		}
+	}
+}
+
+// lease asks the coordinator for a task, returning nil if there's nothing to do
+func (w *Worker) lease(ctx context.Context) (*Lease, error) {
+	response, err := w.do(ctx, http.MethodPost, "/lease", nil, http.Header{headerSlots: {strconv.Itoa(w.slots)}})
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+	if response.StatusCode == http.StatusNoContent {
+		return nil, nil
+	}
+	var lease Lease
+	return &lease, json.NewDecoder(response.Body).Decode(&lease)
+}
+
+func (w *Worker) fetch(ctx context.Context, id, filename string) error {
+	response, err := w.do(ctx, http.MethodGet, "/tasks/"+id+"/input", nil, nil)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	file, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(file, response.Body)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+func (w *Worker) post(ctx context.Context, id, action string, body io.Reader) error {
+	response, err := w.do(ctx, http.MethodPost, "/tasks/"+id+"/"+action, body, nil)
+	if err != nil {
+		return err
+	}
+	return response.Body.Close()
+}
+
+// do sends the request with the extra headers, turning anything but a success into an error
+func (w *Worker) do(ctx context.Context, method, path string, body io.Reader, header http.Header) (*http.Response, error) {
+	request, err := http.NewRequestWithContext(ctx, method, w.url+path, body)
+	if err != nil {
+		return nil, err
+	}
+	for key, values := range header {
+		request.Header[key] = values
+	}
+	request.Header.Set(headerWorker, w.name)
+	if w.token != "" {
+		request.Header.Set("Authorization", "Bearer "+w.token)
+	}
+	response, err := w.client.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	if response.StatusCode < 300 {
+		return response, nil
+	}
+	defer response.Body.Close()
+	if response.StatusCode == http.StatusGone {
+		return nil, errLeaseLost
+	}
+	message, _ := io.ReadAll(io.LimitReader(response.Body, maxProgressReport))
+	return nil, fmt.Errorf("%s %s: %s: %s", method, path, response.Status, strings.TrimSpace(string(message)))
+}
+
+func sleep(ctx context.Context, duration time.Duration) {
+	timer := time.NewTimer(duration)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+	case <-timer.C:
+	}
+}
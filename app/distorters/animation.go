@@ -1,133 +1,112 @@
 package distorters
 
 import (
+	"context"
 	"fmt"
-	"log"
 	"os"
-	"os/exec"
+	"path/filepath"
 	"runtime"
-	"strconv"
-	"strings"
-	"syscall"
 	"time"
 
 	"github.com/pkg/errors"
 
+	"github.com/graynk/distortioner/locale"
 	"github.com/graynk/distortioner/tools"
 )
 
-const (
-	Failed  = "Failed"
-	TooLong = "Senpai, it's too long.."
-	TooBig  = "Senpai, it's too big.."
-	Queued  = "Your message has been queued"
-)
-
-func DistortVideo(filename, codec, output string, progressChan chan string) {
-	progressChan <- "Extracting frames..."
+// DistortVideo distorts the video frame by frame, reporting the progress until the channel is closed.
+// Failures are only returned, telling the user about them is up to whoever owns the progress message
+func DistortVideo(ctx context.Context, filename string, info MediaInfo, encoders *Encoders, output, lang string, options Options, progressChan chan string) error {
+	progressChan <- locale.Get(lang, locale.Extracting)
 	defer close(progressChan)
 	framesDir := filename + "Frames"
 	err := os.Mkdir(framesDir, 0755)
 	if err != nil {
-		err = errors.WithStack(err)
-		log.Println(err)
-		return
+		return atStage(StageExtract, errors.WithStack(err))
 	}
 	defer os.RemoveAll(framesDir)
-	frameRateFraction, duration, err := GetFrameRateFractionAndDuration(filename)
+	video, ok := info.Video()
+	if !ok {
+		return atStage(StageProbe, errors.New("no video stream"))
+	}
+	options, _, err = options.Clip(info.Duration, options.maxDuration())
 	if err != nil {
-		progressChan <- Failed
-		return
-	} else if duration > 60 {
-		progressChan <- TooLong
-		return
+		return atStage(StageExtract, err)
 	}
-	numberedFileName := fmt.Sprintf("%s/%s%%04d.png", framesDir, filename)
-	err = extractFramesFromVideo(frameRateFraction, filename, numberedFileName)
+	plan := planProcessing(video, options.Length, options)
+	numberedFileName := fmt.Sprintf("%s/%s%%04d.png", framesDir, filepath.Base(filename))
+	err = extractFramesFromVideo(ctx, plan, filename, numberedFileName, options)
 	if err != nil {
-		progressChan <- Failed
-		return
+		return atStage(StageExtract, errors.Wrap(err, "extracting the frames"))
 	}
 
 	distortedFrames := 0
 	doneChan := make(chan int, 8)
-	go poolDistortImages(framesDir, doneChan)
+	errChan := make(chan error, 1)
+	go poolDistortImages(ctx, framesDir, options, doneChan, errChan)
 
 	lastUpdate := time.Now()
 	for totalFrames := <-doneChan; distortedFrames != totalFrames; {
 		framesDistorted := <-doneChan
 		if framesDistorted == -1 {
-			progressChan <- Failed
-			return
+			return atStage(StageDistort, <-errChan)
 		}
 		distortedFrames += framesDistorted
 		now := time.Now()
 		if now.Sub(lastUpdate).Seconds() > 2 {
 			lastUpdate = now
-			progressChan <- tools.GenerateProgressMessage(distortedFrames, totalFrames)
+			progressChan <- tools.GenerateProgressMessage(lang, distortedFrames, totalFrames)
 		}
 	}
-	progressChan <- "Collecting frames..."
-	err = collectFramesToVideo(numberedFileName, frameRateFraction, codec, output)
-	if err != nil {
-		progressChan <- Failed
-	}
-	return
-}
-
-func GetFrameRateFractionAndDuration(filename string) (string, float64, error) {
-	cmd := exec.Command(
-		"ffprobe",
-		"-v", "error",
-		"-select_streams", "v",
-		"-of", "default=noprint_wrappers=1:nokey=1",
-		"-show_entries", "stream=avg_frame_rate",
-		"-show_entries", "format=duration",
-		filename)
-	cmd.SysProcAttr = &syscall.SysProcAttr{
-		Setpgid: true,
-	}
-	output, err := cmd.Output()
-	if err != nil {
-		err = errors.WithStack(err)
-		log.Println(err)
-		return "", 0, err
-	}
-	split := strings.Split(string(output), "\n")
-	duration, err := strconv.ParseFloat(split[1], 32)
+	progressChan <- locale.Get(lang, locale.Collecting)
+	err = encoders.Encode(ctx, func(codec string) error {
+		return collectFramesToVideo(ctx, numberedFileName, plan, codec, output)
+	})
 	if err != nil {
-		err = errors.WithStack(err)
-		log.Println(err)
+		return atStage(StageEncode, errors.Wrap(err, "collecting the frames"))
 	}
-	return split[0], duration, err
+	return nil
 }
 
-func extractFramesFromVideo(frameRateFraction, filename, numberedFileName string) error {
-	return runFfmpeg("-i", filename,
-		"-r", frameRateFraction,
+func extractFramesFromVideo(ctx context.Context, plan ProcessingPlan, filename, numberedFileName string, options Options) error {
+	args := append(options.seekArgs(), "-i", filename,
+		"-r", plan.FrameRate,
+		"-vf", fmt.Sprintf("scale=%d:%d", plan.Width, plan.Height),
 		numberedFileName)
+	return runFfmpeg(ctx, args...)
 }
 
-func extractFramesFromVideoSticker(frameRateFraction, filename, numberedFileName string) error {
-	return runFfmpeg("-vcodec", "libvpx-vp9",
+func extractFramesFromVideoSticker(ctx context.Context, frameRateFraction string, video StreamInfo, filename, numberedFileName string, options Options) error {
+	args := options.seekArgs()
+	// ffmpeg's own VP8/VP9 decoders drop the alpha channel, libvpx keeps it
+	if video.HasAlpha() {
+		switch video.CodecName {
+		case "vp8":
+			args = append(args, "-vcodec", "libvpx")
+		case "vp9":
+			args = append(args, "-vcodec", "libvpx-vp9")
+		}
+	}
+	args = append(args,
 		"-i", filename,
 		"-r", frameRateFraction,
 		"-pix_fmt", "rgba",
 		numberedFileName)
+	return runFfmpeg(ctx, args...)
 }
 
-func collectFramesToVideo(numberedFileName, frameRateFraction, codec, filename string) error {
-	return runFfmpeg("-r", frameRateFraction,
+func collectFramesToVideo(ctx context.Context, numberedFileName string, plan ProcessingPlan, codec, filename string) error {
+	args := append(append([]string{}, encoderSetupFor(codec).globalArgs...),
+		"-r", plan.FrameRate,
 		"-i", numberedFileName,
 		"-f", "mp4",
-		"-c:v", codec,
-		"-an",
-		"-pix_fmt", "yuv420p",
-		filename)
+		"-an")
+	args = append(args, encodeArgs(codec, fmt.Sprintf("scale=%d:%d", plan.OutputWidth, plan.OutputHeight))...)
+	return runFfmpeg(ctx, append(args, "-y", filename)...)
 }
 
-func collectFramesToVideoSticker(numberedFileName, frameRateFraction, filename string) error {
-	return runFfmpeg("-r", frameRateFraction,
+func collectFramesToVideoSticker(ctx context.Context, numberedFileName, frameRateFraction, filename string) error {
+	return runFfmpeg(ctx, "-r", frameRateFraction,
 		"-i", numberedFileName,
 		"-f", "webm",
 		"-c:v", "libvpx-vp9",
@@ -137,11 +116,14 @@ func collectFramesToVideoSticker(numberedFileName, frameRateFraction, filename s
 		filename)
 }
 
-func poolDistortImages(frameDir string, doneChan chan int) {
+// poolDistortImages distorts all the frames in the directory, each as many times as the options ask for,
+// sending the total first and then 1 for each frame done. -1 means something failed, the first error is sent to errChan before that
+func poolDistortImages(ctx context.Context, frameDir string, options Options, doneChan chan int, errChan chan error) {
 	cpuCount := runtime.NumCPU()
 	sem := make(chan bool, cpuCount)
 	frames, err := os.ReadDir(frameDir)
 	if err != nil {
+		errChan <- errors.WithStack(err)
 		doneChan <- -1
 		doneChan <- -1
 		return
@@ -154,8 +136,13 @@ func poolDistortImages(frameDir string, doneChan chan int) {
 				<-sem
 				doneChan <- 1
 			}()
-			err := DistortImage(fmt.Sprintf("%s/%s", frameDir, frame))
+			err := DistortImagePasses(ctx, fmt.Sprintf("%s/%s", frameDir, frame), options)
 			if err != nil {
+				err = errors.Wrapf(err, "distorting %s", frame)
+				select {
+				case errChan <- err:
+				default: // somebody else got there first
+				}
 				doneChan <- -1
 			}
 		}(i, frame.Name())
@@ -0,0 +1,49 @@
+package distorters
+
+import (
+	"context"
+	"os"
+)
+
+// DistortAudio distorts a music file into an mp3, keeping its metadata. The title is distorted as text,
+// the embedded cover art (if any) is distorted as an image
+func DistortAudio(ctx context.Context, filename string, info MediaInfo, output, title string, options Options) error {
+	args := []string{"-i", filename}
+	cover := filename + "Cover.jpg"
+	_, hasCover := info.Cover()
+	if hasCover {
+		err := extractCover(ctx, filename, cover)
+		if err == nil {
+			defer os.Remove(cover)
+			err = DistortImagePasses(ctx, cover, options)
+		}
+		hasCover = err == nil
+	}
+	if hasCover {
+		args = append(args, "-i", cover,
+			"-map", "0:a", "-map", "1:v",
+			"-c:v", "mjpeg",
+			"-disposition:v", "attached_pic",
+			"-metadata:s:v", "title=Album cover",
+			"-metadata:s:v", "comment=Cover (front)")
+	} else {
+		args = append(args, "-map", "0:a")
+	}
+	args = append(args,
+		"-map_metadata", "0",
+		"-af", options.soundFilter(),
+		"-c:a", "libmp3lame",
+		"-q:a", "2",
+		"-id3v2_version", "3")
+	if title != "" {
+		args = append(args, "-metadata", "title="+DistortText(title))
+	}
+	return atStage(StageSound, runFfmpeg(ctx, append(args, output)...))
+}
+
+func extractCover(ctx context.Context, filename, cover string) error {
+	return runFfmpeg(ctx, "-i", filename,
+		"-an",
+		"-frames:v", "1",
+		cover)
+}
@@ -0,0 +1,128 @@
+package distorters
+
+import (
+	"math"
+	"strconv"
+	"strings"
+)
+
+const (
+	DefaultFrameBudget  = 900 // in frames of DefaultMaxSide×DefaultMaxSide, that's 30 seconds at 30 fps
+	PriorityFrameBudget = 1800
+	UserCostBudget      = MaxPasses * DefaultFrameBudget // how much a user may have queued at once, see JobCost
+
+	MinFrameRate      = 10  // below that it stops looking like a video
+	minProcessingSide = 128 // below that liquid rescale has nothing to work with
+	fallbackFrameRate = 25
+)
+
+// ProcessingPlan is the resolution and frame rate the video gets distorted at
+type ProcessingPlan struct {
+	Width        int    // frames are extracted at this size
+	Height       int    //
+	OutputWidth  int    // and scaled back to this one when collected
+	OutputHeight int    //
+	FrameRate    string // either the original rate, or a decimated one
+}
+
+// Cost estimates how much work distorting the video takes, in frames of DefaultMaxSide×DefaultMaxSide
+func Cost(width, height int, frameRate, duration float64) float64 {
+	return float64(width) * float64(height) / (DefaultMaxSide * DefaultMaxSide) * frameRate * duration
+}
+
+// JobCost estimates how much work distorting the video with the options is, for the queue to schedule by.
+// It's the Cost of what actually gets distorted: the clipped range, fit into the frame budget, once per pass.
+// Unknown dimensions count as the worst case, unknown frame rate as fallbackFrameRate
+func JobCost(video StreamInfo, duration float64, options Options) float64 {
+	if video.Width <= 0 || video.Height <= 0 {
+		maxSide := options.MaxSide
+		if maxSide == 0 {
+			maxSide = DefaultMaxSide
+		}
+		video.Width, video.Height = maxSide, maxSide
+	}
+	options, _, err := options.Clip(duration, options.maxDuration())
+	if err != nil {
+		return 1 // nothing to distort, it's going to fail right away
+	}
+	plan := planProcessing(video, options.Length, options)
+	cost := Cost(plan.Width, plan.Height, parseFrameRate(plan.FrameRate), options.Length)
+	return max(cost, 1) * float64(options.PassCount())
+}
+
+// planProcessing picks the frame rate and the resolution that fit the video into the frame budget.
+// The frame rate is cut first, choppy looks better than blurry. The resolution is only reduced below MaxSide
+// when even MinFrameRate doesn't fit
+func planProcessing(video StreamInfo, duration float64, options Options) ProcessingPlan {
+	maxSide := options.MaxSide
+	if maxSide == 0 {
+		maxSide = DefaultMaxSide
+	}
+	budget := float64(options.FrameBudget)
+	if budget == 0 {
+		budget = DefaultFrameBudget
+	}
+	displayWidth, displayHeight := video.DisplaySize()
+	width, height := fit(displayWidth, displayHeight, maxSide)
+	plan := ProcessingPlan{
+		Width:        width,
+		Height:       height,
+		OutputWidth:  width,
+		OutputHeight: height,
+		FrameRate:    video.FrameRate,
+	}
+	frameRate := parseFrameRate(video.FrameRate)
+	if frameRate <= 0 {
+		frameRate = fallbackFrameRate
+		plan.FrameRate = strconv.Itoa(fallbackFrameRate)
+	}
+	cost := Cost(width, height, frameRate, duration)
+	if cost <= budget {
+		return plan
+	}
+	if decimated := frameRate * budget / cost; decimated >= MinFrameRate {
+		plan.FrameRate = formatFrameRate(decimated)
+		return plan
+	}
+	if frameRate > MinFrameRate {
+		frameRate = MinFrameRate
+		plan.FrameRate = formatFrameRate(frameRate)
+	}
+	scale := math.Sqrt(budget / Cost(width, height, frameRate, duration))
+	if smaller := float64(min(width, height)); smaller*scale < minProcessingSide {
+		scale = math.Min(1, minProcessingSide/smaller)
+	}
+	plan.Width, plan.Height = even(float64(width)*scale), even(float64(height)*scale)
+	return plan
+}
+
+// fit shrinks the dimensions to fit into maxSide, keeping the aspect ratio. Never upscales
+func fit(width, height, maxSide int) (int, int) {
+	scale := math.Min(1, float64(maxSide)/float64(max(width, height)))
+	return even(float64(width) * scale), even(float64(height) * scale)
+}
+
+// even rounds down to an even number, yuv420p doesn't do odd dimensions
+func even(side float64) int {
+	return max(2, int(side)&^1)
+}
+
+func parseFrameRate(fraction string) float64 {
+	numerator, denominator, found := strings.Cut(fraction, "/")
+	rate, err := strconv.ParseFloat(numerator, 64)
+	if err != nil {
+		return 0
+	}
+	if !found {
+		return rate
+	}
+	divisor, err := strconv.ParseFloat(denominator, 64)
+	if err != nil || divisor == 0 {
+		return 0
+	}
+	return rate / divisor
+}
+
+func formatFrameRate(rate float64) string {
+	return strconv.FormatFloat(rate, 'f', 3, 64)
+}
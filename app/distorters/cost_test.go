@@ -0,0 +1,104 @@
+package distorters
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPlanProcessingCheapVideo(t *testing.T) {
+	plan := planProcessing(StreamInfo{Width: 480, Height: 360, FrameRate: "30000/1001"}, 10, DefaultOptions())
+	assert.Equal(t, ProcessingPlan{Width: 480, Height: 360, OutputWidth: 480, OutputHeight: 360, FrameRate: "30000/1001"}, plan)
+}
+
+func TestPlanProcessingDownscalesToMaxSide(t *testing.T) {
+	plan := planProcessing(StreamInfo{Width: 1920, Height: 1080, FrameRate: "25/1"}, 5, DefaultOptions())
+	assert.Equal(t, ProcessingPlan{Width: 512, Height: 288, OutputWidth: 512, OutputHeight: 288, FrameRate: "25/1"}, plan)
+}
+
+func TestPlanProcessingDecimatesFrameRate(t *testing.T) {
+	options := DefaultOptions()
+	options.FrameBudget = DefaultFrameBudget
+	plan := planProcessing(StreamInfo{Width: 3840, Height: 3840, FrameRate: "60/1"}, 60, options)
+	assert.Equal(t, 512, plan.Width)
+	assert.Equal(t, 512, plan.Height)
+	assert.Equal(t, "15.000", plan.FrameRate)
+	assert.InDelta(t, DefaultFrameBudget, Cost(plan.Width, plan.Height, parseFrameRate(plan.FrameRate), 60), 1)
+
+	options.FrameBudget = PriorityFrameBudget
+	plan = planProcessing(StreamInfo{Width: 3840, Height: 3840, FrameRate: "60/1"}, 60, options)
+	assert.Equal(t, "30.000", plan.FrameRate)
+}
+
+func TestPlanProcessingDownscalesWhenDecimationIsNotEnough(t *testing.T) {
+	options := DefaultOptions()
+	options.FrameBudget = 300
+	plan := planProcessing(StreamInfo{Width: 1024, Height: 1024, FrameRate: "60/1"}, 60, options)
+	assert.Equal(t, formatFrameRate(MinFrameRate), plan.FrameRate)
+	assert.Equal(t, 512, plan.OutputWidth)
+	assert.Equal(t, 512, plan.OutputHeight)
+	assert.Less(t, plan.Width, 512)
+	assert.LessOrEqual(t, Cost(plan.Width, plan.Height, MinFrameRate, 60), 300.0)
+
+	// but not below the point where there's nothing left to distort
+	options.FrameBudget = 1
+	plan = planProcessing(StreamInfo{Width: 1024, Height: 512, FrameRate: "60/1"}, 60, options)
+	assert.Equal(t, 256, plan.Width)
+	assert.Equal(t, 128, plan.Height)
+}
+
+func TestPlanProcessingRotated(t *testing.T) {
+	plan := planProcessing(StreamInfo{Width: 1920, Height: 1080, FrameRate: "30/1", Rotation: 90}, 5, DefaultOptions())
+	assert.Equal(t, 288, plan.Width)
+	assert.Equal(t, 512, plan.Height)
+}
+
+func TestPlanProcessingBrokenFrameRate(t *testing.T) {
+	plan := planProcessing(StreamInfo{Width: 320, Height: 240, FrameRate: "0/0"}, 1, DefaultOptions())
+	assert.Equal(t, "25", plan.FrameRate)
+}
+
+func TestParseFrameRate(t *testing.T) {
+	assert.InDelta(t, 29.97, parseFrameRate("30000/1001"), 0.01)
+	assert.Equal(t, 25.0, parseFrameRate("25"))
+	assert.Equal(t, 0.0, parseFrameRate("0/0"))
+	assert.Equal(t, 0.0, parseFrameRate("N/A"))
+}
+
+func TestJobCost(t *testing.T) {
+	options := DefaultOptions()
+	gif := JobCost(StreamInfo{Width: 320, Height: 240, FrameRate: "10/1"}, 3, options)
+	assert.InDelta(t, 320.0*240/(512*512)*10*3, gif, 0.01)
+
+	// a minute of 1080p is way over the budget, so it only costs as much as the budget allows
+	video := JobCost(StreamInfo{Width: 1920, Height: 1080, FrameRate: "60/1"}, 60, options)
+	assert.InDelta(t, DefaultFrameBudget, video, 1)
+	assert.Greater(t, video, 100*gif)
+
+	// only the requested range is distorted, and five seconds fit into the budget at the original frame rate
+	options.Start, options.Length = 10, 5
+	assert.InDelta(t, Cost(512, 288, 60, 5), JobCost(StreamInfo{Width: 1920, Height: 1080, FrameRate: "60/1"}, 60, options), 0.01)
+
+	options = DefaultOptions()
+	options.Passes = 3
+	assert.InDelta(t, 3*gif, JobCost(StreamInfo{Width: 320, Height: 240, FrameRate: "10/1"}, 3, options), 0.01)
+}
+
+func TestJobCostUnknowns(t *testing.T) {
+	options := DefaultOptions()
+	// Telegram doesn't tell the frame rate, and sometimes not even the size
+	assert.InDelta(t, 25*10, JobCost(StreamInfo{}, 10, options), 0.01)
+	// nor the duration, which means as long as we'd take
+	assert.InDelta(t, DefaultFrameBudget, JobCost(StreamInfo{Width: 512, Height: 512}, 0, options), 1)
+	// and there's nothing to distort past the end
+	options.Start = 20
+	assert.Equal(t, 1.0, JobCost(StreamInfo{Width: 512, Height: 512}, 10, options))
+}
+
+func TestJobCostMaxDuration(t *testing.T) {
+	options := DefaultOptions()
+	small := StreamInfo{Width: 128, Height: 128, FrameRate: "25/1"}
+	assert.InDelta(t, Cost(128, 128, 25, MaxVideoDuration), JobCost(small, 600, options), 0.01)
+	options.MaxDuration = 2 * MaxVideoDuration
+	assert.InDelta(t, Cost(128, 128, 25, 2*MaxVideoDuration), JobCost(small, 600, options), 0.01)
+}
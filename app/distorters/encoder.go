@@ -0,0 +1,231 @@
+package distorters
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// DefaultEncoders are tried in that order after the configured one, hardware first
+var DefaultEncoders = []string{"h264_nvenc", "h264_vaapi", "libx264"}
+
+// encoderSetup is whatever an encoder needs besides -c:v to eat PNG frames
+type encoderSetup struct {
+	globalArgs []string // go before the inputs
+	filters    string   // go after the scaling
+	pixFmt     string
+}
+
+var defaultEncoderSetup = encoderSetup{pixFmt: "yuv420p"}
+
+var encoderSetups = map[string]encoderSetup{
+	"h264_vaapi": {
+		globalArgs: []string{"-vaapi_device", "/dev/dri/renderD128"},
+		filters:    "format=nv12,hwupload",
+	},
+}
+
+// EncoderStats is how many encodes went through an encoder and how many of them failed
+type EncoderStats struct {
+	Name      string
+	Succeeded int
+	Failed    int
+}
+
+// Encoders keeps the list of working video encoders in the order of preference
+type Encoders struct {
+	mu     *sync.Mutex
+	names  []string
+	stats  map[string]*EncoderStats
+	broken map[string]error
+}
+
+// ProbeEncoders checks which encoders this ffmpeg actually has and can use, with the preferred one going first.
+// The ones that are compiled in, but fail the test encode are left for the caller to look at, see Broken
+func ProbeEncoders(ctx context.Context, preferred string) (*Encoders, error) {
+	output, err := runner.Run(ctx, "ffmpeg", "-hide_banner", "-encoders")
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	compiled := parseEncoders(string(output))
+	var working []string
+	var missing []string
+	broken := make(map[string]error)
+	for _, name := range encoderCandidates(preferred) {
+		if !compiled[name] {
+			missing = append(missing, name)
+			continue
+		}
+		// being compiled in doesn't mean there's a GPU for it
+		if err := testEncoder(ctx, name); err != nil {
+			broken[name] = err
+			continue
+		}
+		working = append(working, name)
+	}
+	if len(working) == 0 {
+		reasons := make([]string, 0, len(broken)+1)
+		if len(missing) > 0 {
+			reasons = append(reasons, "not compiled in: "+strings.Join(missing, ", "))
+		}
+		for _, name := range encoderCandidates(preferred) {
+			if err, ok := broken[name]; ok {
+				reasons = append(reasons, fmt.Sprintf("%s: %v", name, err))
+			}
+		}
+		return nil, errors.Errorf("none of the encoders work (%s)", strings.Join(reasons, "; "))
+	}
+	encoders := NewEncoders(working...)
+	encoders.broken = broken
+	return encoders, nil
+}
+
+func NewEncoders(names ...string) *Encoders {
+	stats := make(map[string]*EncoderStats, len(names))
+	for _, name := range names {
+		stats[name] = &EncoderStats{Name: name}
+	}
+	return &Encoders{
+		mu:     &sync.Mutex{},
+		names:  names,
+		stats:  stats,
+		broken: make(map[string]error),
+	}
+}
+
+// encoderCandidates is the preferred encoder followed by the defaults, without duplicates
+func encoderCandidates(preferred string) []string {
+	candidates := make([]string, 0, len(DefaultEncoders)+1)
+	if preferred != "" {
+		candidates = append(candidates, preferred)
+	}
+	for _, name := range DefaultEncoders {
+		if name != preferred {
+			candidates = append(candidates, name)
+		}
+	}
+	return candidates
+}
+
+// parseEncoders picks the video encoder names out of `ffmpeg -encoders`, the lines look like
+// " V....D libx264              libx264 H.264 / AVC / MPEG-4 AVC / MPEG-4 part 10 (codec h264)"
+func parseEncoders(output string) map[string]bool {
+	encoders := make(map[string]bool)
+	listStarted := false
+	for _, line := range strings.Split(output, "\n") {
+		fields := strings.Fields(line)
+		if !listStarted {
+			listStarted = len(fields) == 1 && strings.HasPrefix(fields[0], "---")
+			continue
+		}
+		if len(fields) < 2 || len(fields[0]) != 6 || fields[0][0] != 'V' {
+			continue
+		}
+		encoders[fields[1]] = true
+	}
+	return encoders
+}
+
+// testEncoder encodes a few frames of nothing, to see if the encoder can be used at all
+func testEncoder(ctx context.Context, name string) error {
+	args := append(append([]string{}, encoderSetupFor(name).globalArgs...),
+		"-f", "lavfi",
+		"-i", "color=size=64x64:duration=0.2",
+		"-f", "null")
+	args = append(args, encodeArgs(name, "")...)
+	return runFfmpeg(ctx, append(args, "-")...)
+}
+
+func encoderSetupFor(name string) encoderSetup {
+	setup, ok := encoderSetups[name]
+	if !ok {
+		return defaultEncoderSetup
+	}
+	return setup
+}
+
+// encodeArgs are the output options for encoding with that encoder, with the filters applied before its own
+func encodeArgs(name, filters string) []string {
+	setup := encoderSetupFor(name)
+	if setup.filters != "" {
+		if filters != "" {
+			filters += ","
+		}
+		filters += setup.filters
+	}
+	args := []string{"-c:v", name}
+	if filters != "" {
+		args = append(args, "-vf", filters)
+	}
+	if setup.pixFmt != "" {
+		args = append(args, "-pix_fmt", setup.pixFmt)
+	}
+	return args
+}
+
+// Encode runs the encode with each encoder in turn, until one of them works. The failures on the way only show in the Stats,
+// if none of them works the error of the last one is returned along with what the others said.
+// Once the context is done nothing else is tried, and the encoder that got interrupted isn't blamed for it
+func (e *Encoders) Encode(ctx context.Context, encode func(name string) error) error {
+	var failures []string
+	var err error
+	for _, name := range e.names {
+		if ctx.Err() != nil {
+			return errors.WithStack(ctx.Err())
+		}
+		err = encode(name)
+		if err != nil && (ctx.Err() != nil || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded)) {
+			return err
+		}
+		e.record(name, err)
+		if err == nil {
+			return nil
+		}
+		failures = append(failures, fmt.Sprintf("%s: %v", name, err))
+	}
+	if len(failures) > 1 {
+		return errors.Wrapf(err, "after %s, %s", strings.Join(failures[:len(failures)-1], "; "), e.names[len(e.names)-1])
+	}
+	return err
+}
+
+func (e *Encoders) record(name string, err error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if err != nil {
+		e.stats[name].Failed++
+	} else {
+		e.stats[name].Succeeded++
+	}
+}
+
+// Broken returns the encoders that ffmpeg has, but that failed the test encode in ProbeEncoders, with the reason
+func (e *Encoders) Broken() map[string]error {
+	return maps.Clone(e.broken)
+}
+
+// Preferred is the encoder that is tried first
+func (e *Encoders) Preferred() string {
+	return e.names[0]
+}
+
+// Stats returns the numbers for every encoder, in the order they are tried
+func (e *Encoders) Stats() []EncoderStats {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	stats := make([]EncoderStats, len(e.names))
+	for i, name := range e.names {
+		stats[i] = *e.stats[name]
+	}
+	return stats
+}
+
+func (es EncoderStats) String() string {
+	return fmt.Sprintf("%s (%d ok, %d failed)", es.Name, es.Succeeded, es.Failed)
+}
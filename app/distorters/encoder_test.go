@@ -0,0 +1,123 @@
+package distorters
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+const encodersOutput = `Encoders:
+ V..... = Video
+ A..... = Audio
+ S..... = Subtitle
+ .F.... = Frame-level multithreading
+ ..S... = Slice-level multithreading
+ ...X.. = Codec is experimental
+ ....B. = Supports draw_horiz_band
+ .....D = Supports direct rendering method 1
+ ------
+ V....D a64multi             Multicolor charset for Commodore 64 (codec a64_multi)
+ V....D libx264              libx264 H.264 / AVC / MPEG-4 AVC / MPEG-4 part 10 (codec h264)
+ V....D h264_vaapi           H.264/AVC (VAAPI) (codec h264)
+ A....D libopus              libopus Opus (codec opus)
+`
+
+func TestParseEncoders(t *testing.T) {
+	encoders := parseEncoders(encodersOutput)
+	assert.Equal(t, map[string]bool{"a64multi": true, "libx264": true, "h264_vaapi": true}, encoders)
+}
+
+func TestEncoderCandidates(t *testing.T) {
+	assert.Equal(t, []string{"h264_nvenc", "h264_vaapi", "libx264"}, encoderCandidates(""))
+	assert.Equal(t, []string{"h264_vaapi", "h264_nvenc", "libx264"}, encoderCandidates("h264_vaapi"))
+	assert.Equal(t, []string{"libx265", "h264_nvenc", "h264_vaapi", "libx264"}, encoderCandidates("libx265"))
+}
+
+func TestEncodeArgs(t *testing.T) {
+	assert.Equal(t, []string{"-c:v", "libx264", "-vf", "scale=2:2", "-pix_fmt", "yuv420p"}, encodeArgs("libx264", "scale=2:2"))
+	assert.Equal(t, []string{"-c:v", "libx264", "-pix_fmt", "yuv420p"}, encodeArgs("libx264", ""))
+	assert.Equal(t, []string{"-c:v", "h264_vaapi", "-vf", "scale=2:2,format=nv12,hwupload"}, encodeArgs("h264_vaapi", "scale=2:2"))
+}
+
+func TestEncodeFallsBack(t *testing.T) {
+	encoders := NewEncoders("h264_nvenc", "libx264")
+	var tried []string
+	err := encoders.Encode(context.Background(), func(name string) error {
+		tried = append(tried, name)
+		if name == "h264_nvenc" {
+			return errors.New("no GPU")
+		}
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"h264_nvenc", "libx264"}, tried)
+	assert.Equal(t, []EncoderStats{{Name: "h264_nvenc", Failed: 1}, {Name: "libx264", Succeeded: 1}}, encoders.Stats())
+
+	err = encoders.Encode(context.Background(), func(name string) error {
+		return errors.New(name + " says broken input")
+	})
+	assert.EqualError(t, err, "after h264_nvenc: h264_nvenc says broken input, libx264: libx264 says broken input")
+	assert.Equal(t, "libx264 (1 ok, 1 failed)", encoders.Stats()[1].String())
+}
+
+func TestEncodeStopsWhenCancelled(t *testing.T) {
+	encoders := NewEncoders("h264_nvenc", "h264_vaapi", "libx264")
+	ctx, cancel := context.WithCancel(context.Background())
+	var tried []string
+	err := encoders.Encode(ctx, func(name string) error {
+		tried = append(tried, name)
+		cancel()
+		return errors.Wrap(context.Canceled, name)
+	})
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Equal(t, []string{"h264_nvenc"}, tried, "nothing should be tried after the cancellation")
+	assert.Equal(t, []EncoderStats{{Name: "h264_nvenc"}, {Name: "h264_vaapi"}, {Name: "libx264"}}, encoders.Stats(),
+		"the interrupted encoder is not to blame")
+
+	// ffmpeg killed on a timeout says nothing about the context, but the context does
+	ctx, cancel = context.WithTimeout(context.Background(), time.Millisecond)
+	defer cancel()
+	err = encoders.Encode(ctx, func(name string) error {
+		<-ctx.Done()
+		return errors.New("signal: killed")
+	})
+	assert.EqualError(t, err, "signal: killed")
+	assert.Equal(t, []EncoderStats{{Name: "h264_nvenc"}, {Name: "h264_vaapi"}, {Name: "libx264"}}, encoders.Stats())
+
+	err = encoders.Encode(ctx, func(name string) error {
+		t.Fatal("nothing should be run with the context done")
+		return nil
+	})
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+}
+
+func TestProbeEncoders(t *testing.T) {
+	withFakeRunner(t, func(name string, args []string) ([]byte, error) {
+		if slices.Contains(args, "-encoders") {
+			return []byte(encodersOutput), nil
+		}
+		if slices.Contains(args, "h264_vaapi") {
+			return nil, errors.New("no render node")
+		}
+		return nil, nil
+	})
+	encoders, err := ProbeEncoders(context.Background(), "h264_vaapi")
+	assert.NoError(t, err)
+	assert.Equal(t, "libx264", encoders.Preferred())
+	broken := encoders.Broken()
+	assert.Len(t, broken, 1)
+	assert.ErrorContains(t, broken["h264_vaapi"], "no render node")
+
+	withFakeRunner(t, func(name string, args []string) ([]byte, error) {
+		if slices.Contains(args, "-encoders") {
+			return []byte(encodersOutput), nil
+		}
+		return nil, errors.New("no render node")
+	})
+	_, err = ProbeEncoders(context.Background(), "")
+	assert.EqualError(t, err, "none of the encoders work (not compiled in: h264_nvenc; h264_vaapi: no render node; libx264: no render node)")
+}
@@ -1,30 +1,21 @@
 package distorters
 
 import (
-	"bytes"
-	"log"
-	"os/exec"
-	"syscall"
+	"context"
 
 	"github.com/pkg/errors"
+
+	"github.com/graynk/distortioner/tools"
 )
 
-func runFfmpeg(args ...string) error {
-	var outbuf, errbuf bytes.Buffer
-	cmd := exec.Command(
-		"ffmpeg",
-		args...)
-	cmd.SysProcAttr = &syscall.SysProcAttr{
-		Setpgid: true,
-	}
-	cmd.Stdout = &outbuf
-	cmd.Stderr = &errbuf
-	err := cmd.Run()
-	if err != nil {
-		log.Println(outbuf.String())
-		log.Println(errbuf.String())
-		err = errors.WithStack(err)
-		log.Println(err)
-	}
-	return err
+var runner tools.Runner = tools.NewExecRunner(tools.Limits{})
+
+// SetRunner replaces the way ffmpeg, ffprobe and magick are run: with limits in production, with a fake in tests
+func SetRunner(r tools.Runner) {
+	runner = r
+}
+
+func runFfmpeg(ctx context.Context, args ...string) error {
+	_, err := runner.Run(ctx, "ffmpeg", args...)
+	return errors.WithStack(err)
 }
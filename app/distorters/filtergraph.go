@@ -0,0 +1,58 @@
+package distorters
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Filter is a single ffmpeg filter, e.g. vibrato=f=6:d=1
+type Filter struct {
+	name    string
+	options []string
+}
+
+func NewFilter(name string) Filter {
+	return Filter{name: name}
+}
+
+// With adds a named option to the filter
+func (f Filter) With(key string, value any) Filter {
+	options := make([]string, len(f.options), len(f.options)+1)
+	copy(options, f.options)
+	f.options = append(options, key+"="+escapeFilterValue(fmt.Sprint(value)))
+	return f
+}
+
+// WithValue adds a positional option to the filter, for filters like chorus that don't name them
+func (f Filter) WithValue(value any) Filter {
+	options := make([]string, len(f.options), len(f.options)+1)
+	copy(options, f.options)
+	f.options = append(options, escapeFilterValue(fmt.Sprint(value)))
+	return f
+}
+
+func (f Filter) String() string {
+	if len(f.options) == 0 {
+		return f.name
+	}
+	return f.name + "=" + strings.Join(f.options, ":")
+}
+
+// escapeFilterValue quotes the values that would otherwise break the filtergraph, like expressions with commas in them
+func escapeFilterValue(value string) string {
+	if !strings.ContainsAny(value, `,:;[]'\=`) {
+		return value
+	}
+	return "'" + strings.ReplaceAll(value, "'", `'\''`) + "'"
+}
+
+// FilterChain is a list of filters applied one after another, the thing that goes into -af
+type FilterChain []Filter
+
+func (fc FilterChain) String() string {
+	filters := make([]string, len(fc))
+	for i, filter := range fc {
+		filters[i] = filter.String()
+	}
+	return strings.Join(filters, ",")
+}
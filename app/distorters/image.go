@@ -1,28 +1,109 @@
 package distorters
 
 import (
-	"log"
-	"os/exec"
-	"syscall"
+	"context"
+	"fmt"
+	"math/rand"
 
 	"github.com/pkg/errors"
 )
 
-func DistortImage(path string) error {
-	cmd := exec.Command(
-		"magick",
-		path,
-		"-resize", "512x512>", // A reasonable cutoff, I hope
-		"-liquid-rescale", "50%",
-		"-resize", "200%",
-		path)
-	cmd.SysProcAttr = &syscall.SysProcAttr{
-		Setpgid: true,
+const (
+	MinStrength     = 1
+	DefaultStrength = 3
+	MaxStrength     = 5
+
+	MaxPasses = 3 // /distort x3 at most, every pass costs as much as a whole separate request
+
+	DefaultMaxSide  = 512  // A reasonable cutoff, I hope
+	DocumentMaxSide = 1280 // Images sent as files are expected to come back in better quality
+)
+
+// PipelineVersion has to be bumped whenever the same media with the same options starts coming out differently,
+// so that the results cached before that don't get sent anymore
+const PipelineVersion = 1
+
+// how much of the image liquid-rescale keeps for each strength, the rest gets carved out
+var rescalePercents = [MaxStrength + 1]int{0, 80, 65, 50, 35, 25}
+
+// how far the seed can move the percents above, each side separately
+const seedJitter = 8
+
+// Options tweak how hard the media gets distorted
+type Options struct {
+	Strength    int
+	MaxSide     int      // Images get shrunk to fit into that before distorting, DefaultMaxSide if not set
+	SoundPreset string   // One of SoundPresets, DefaultSoundPreset if not set
+	TextMode    TextMode // One of TextModes, DefaultTextMode if not set
+	Start       float64  // Where to start videos and sounds from, in seconds
+	Length      float64  // How much of them to take, in seconds. Everything up to the limit if not set
+	FrameBudget int      // How much work a video may take, see Cost. DefaultFrameBudget if not set
+	MaxDuration float64  // How much of a video may be distorted, in seconds. MaxVideoDuration if not set
+	Seed        int64    // Wobbles the proportions of the distortion, so that the same media can come out differently
+	Passes      int      // How many times the media goes through the distortion, once if not set
+}
+
+func DefaultOptions() Options {
+	return Options{Strength: DefaultStrength, SoundPreset: DefaultSoundPreset, TextMode: DefaultTextMode, FrameBudget: DefaultFrameBudget}
+}
+
+// PassCount is how many times the media goes through the distortion, within 1 and MaxPasses
+func (o Options) PassCount() int {
+	return min(max(o.Passes, 1), MaxPasses)
+}
+
+func (o Options) rescalePercent() int {
+	strength := o.Strength
+	if strength < MinStrength || strength > MaxStrength {
+		strength = DefaultStrength
 	}
-	err := cmd.Run()
-	if err != nil {
-		err = errors.WithStack(err)
-		log.Println(err)
+	return rescalePercents[strength]
+}
+
+// rescaleGeometry returns how much of the image liquid-rescale keeps and how to scale it back to roughly the original size
+func (o Options) rescaleGeometry() (string, string) {
+	percent := o.rescalePercent()
+	if o.Seed == 0 {
+		return fmt.Sprintf("%d%%", percent), fmt.Sprintf("%d%%", 100*100/percent)
 	}
-	return err
+	random := rand.New(rand.NewSource(o.Seed))
+	width := percent + random.Intn(2*seedJitter+1) - seedJitter
+	height := percent + random.Intn(2*seedJitter+1) - seedJitter
+	return fmt.Sprintf("%dx%d%%", width, height), fmt.Sprintf("%dx%d%%", 100*100/width, 100*100/height)
+}
+
+func DistortImage(ctx context.Context, path string, options Options) error {
+	rescale, restore := options.rescaleGeometry()
+	maxSide := options.MaxSide
+	if maxSide == 0 {
+		maxSide = DefaultMaxSide
+	}
+	return atStage(StageDistort, runMagick(ctx,
+		path,
+		"-resize", fmt.Sprintf("%dx%d>", maxSide, maxSide),
+		"-liquid-rescale", rescale,
+		"-resize", restore,
+		path))
+}
+
+// DistortImagePasses runs the image through DistortImage as many times as the options ask for,
+// same as sending the result back to the bot over and over
+func DistortImagePasses(ctx context.Context, path string, options Options) error {
+	for i := 0; i < options.PassCount(); i++ {
+		err := DistortImage(ctx, path, options)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ConvertImage converts the image into whatever format the output extension says
+func ConvertImage(ctx context.Context, input, output string) error {
+	return atStage(StageConvert, runMagick(ctx, input, output))
+}
+
+func runMagick(ctx context.Context, args ...string) error {
+	_, err := runner.Run(ctx, "magick", args...)
+	return errors.WithStack(err)
 }
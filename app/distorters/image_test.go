@@ -0,0 +1,49 @@
+package distorters
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDistortImageCommand(t *testing.T) {
+	fake := withFakeRunner(t, func(name string, args []string) ([]byte, error) {
+		return nil, nil
+	})
+	err := DistortImage(context.Background(), "cat.jpg", DefaultOptions())
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"magick", "cat.jpg", "-resize", "512x512>", "-liquid-rescale", "50%", "-resize", "200%", "cat.jpg"},
+		fake.Calls()[0])
+}
+
+func TestRescaleGeometrySeed(t *testing.T) {
+	options := DefaultOptions()
+	options.Seed = 42
+	rescale, restore := options.rescaleGeometry()
+	again, _ := options.rescaleGeometry()
+	assert.Equal(t, rescale, again, "the same seed has to give the same result")
+	assert.Regexp(t, `^\d+x\d+%$`, rescale)
+	assert.Regexp(t, `^\d+x\d+%$`, restore)
+
+	different := false
+	for seed := int64(1); seed < 10 && !different; seed++ {
+		options.Seed = seed
+		other, _ := options.rescaleGeometry()
+		different = other != rescale
+	}
+	assert.True(t, different, "seeds should change the geometry")
+}
+
+func TestDistortImagePasses(t *testing.T) {
+	fake := withFakeRunner(t, nil)
+	options := DefaultOptions()
+	options.Passes = 2
+	assert.NoError(t, DistortImagePasses(context.Background(), "cat.jpg", options))
+	assert.Len(t, fake.Calls(), 2)
+
+	options.Passes = 100
+	assert.Equal(t, MaxPasses, options.PassCount(), "passes are capped")
+	options.Passes = 0
+	assert.Equal(t, 1, options.PassCount(), "once if not set")
+}
@@ -0,0 +1,194 @@
+package distorters
+
+import (
+	"context"
+	"encoding/json"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// MediaInfo is what ffprobe knows about a file
+type MediaInfo struct {
+	Format   string            // e.g. "mov,mp4,m4a,3gp,3g2,mj2" or "gif"
+	Duration float64           // in seconds, 0 if nobody knows
+	Tags     map[string]string // format-level metadata, keys are lowercase
+	Streams  []StreamInfo
+}
+
+type StreamInfo struct {
+	Index       int
+	CodecType   string // video, audio, subtitle, data, attachment
+	CodecName   string
+	Width       int
+	Height      int
+	PixFmt      string
+	FrameRate   string // as ffprobe reports it, e.g. 30000/1001
+	Duration    float64
+	Rotation    int // clockwise, in degrees: 0, 90, 180 or 270
+	AttachedPic bool
+	Tags        map[string]string // keys are lowercase
+}
+
+type ffprobeOutput struct {
+	Format struct {
+		FormatName string            `json:"format_name"`
+		Duration   string            `json:"duration"`
+		Tags       map[string]string `json:"tags"`
+	} `json:"format"`
+	Streams []struct {
+		Index        int               `json:"index"`
+		CodecType    string            `json:"codec_type"`
+		CodecName    string            `json:"codec_name"`
+		Width        int               `json:"width"`
+		Height       int               `json:"height"`
+		PixFmt       string            `json:"pix_fmt"`
+		AvgFrameRate string            `json:"avg_frame_rate"`
+		RFrameRate   string            `json:"r_frame_rate"`
+		Duration     string            `json:"duration"`
+		Tags         map[string]string `json:"tags"`
+		Disposition  map[string]int    `json:"disposition"`
+		SideDataList []struct {
+			SideDataType string  `json:"side_data_type"`
+			Rotation     float64 `json:"rotation"`
+		} `json:"side_data_list"`
+	} `json:"streams"`
+}
+
+func ProbeMedia(ctx context.Context, filename string) (MediaInfo, error) {
+	output, err := runner.Run(ctx,
+		"ffprobe",
+		"-v", "error",
+		"-of", "json",
+		"-show_format",
+		"-show_streams",
+		filename)
+	if err != nil {
+		return MediaInfo{}, atStage(StageProbe, errors.WithStack(err))
+	}
+	info, err := parseProbe(output)
+	return info, atStage(StageProbe, err)
+}
+
+func parseProbe(output []byte) (MediaInfo, error) {
+	var probed ffprobeOutput
+	err := json.Unmarshal(output, &probed)
+	if err != nil {
+		return MediaInfo{}, errors.WithStack(err)
+	}
+	info := MediaInfo{
+		Format:   probed.Format.FormatName,
+		Duration: parseDuration(probed.Format.Duration),
+		Tags:     lowercaseKeys(probed.Format.Tags),
+	}
+	for _, probedStream := range probed.Streams {
+		stream := StreamInfo{
+			Index:       probedStream.Index,
+			CodecType:   probedStream.CodecType,
+			CodecName:   probedStream.CodecName,
+			Width:       probedStream.Width,
+			Height:      probedStream.Height,
+			PixFmt:      probedStream.PixFmt,
+			FrameRate:   probedStream.AvgFrameRate,
+			Duration:    parseDuration(probedStream.Duration),
+			AttachedPic: probedStream.Disposition["attached_pic"] == 1,
+			Tags:        lowercaseKeys(probedStream.Tags),
+		}
+		if parseFrameRate(stream.FrameRate) <= 0 {
+			stream.FrameRate = probedStream.RFrameRate
+		}
+		// the display matrix is counter-clockwise, the old rotate tag is clockwise
+		rotation := 0.0
+		if rotate, err := strconv.ParseFloat(stream.Tags["rotate"], 64); err == nil {
+			rotation = rotate
+		}
+		for _, sideData := range probedStream.SideDataList {
+			if sideData.SideDataType == "Display Matrix" {
+				rotation = -sideData.Rotation
+			}
+		}
+		stream.Rotation = (int(math.Round(rotation/90))*90%360 + 360) % 360
+		info.Streams = append(info.Streams, stream)
+	}
+	if info.Duration == 0 {
+		// some containers only know it per stream
+		for _, stream := range info.Streams {
+			info.Duration = math.Max(info.Duration, stream.Duration)
+		}
+	}
+	return info, nil
+}
+
+func parseDuration(duration string) float64 {
+	seconds, err := strconv.ParseFloat(duration, 64)
+	if err != nil || seconds < 0 {
+		return 0 // N/A
+	}
+	return seconds
+}
+
+func lowercaseKeys(tags map[string]string) map[string]string {
+	lowercase := make(map[string]string, len(tags))
+	for key, value := range tags {
+		lowercase[strings.ToLower(key)] = value
+	}
+	return lowercase
+}
+
+// Video returns the first real video stream, cover art doesn't count
+func (mi MediaInfo) Video() (StreamInfo, bool) {
+	for _, stream := range mi.Streams {
+		if stream.CodecType == "video" && !stream.AttachedPic {
+			return stream, true
+		}
+	}
+	return StreamInfo{}, false
+}
+
+func (mi MediaInfo) Audio() (StreamInfo, bool) {
+	for _, stream := range mi.Streams {
+		if stream.CodecType == "audio" {
+			return stream, true
+		}
+	}
+	return StreamInfo{}, false
+}
+
+// Cover returns the embedded cover art of music files
+func (mi MediaInfo) Cover() (StreamInfo, bool) {
+	for _, stream := range mi.Streams {
+		if stream.CodecType == "video" && stream.AttachedPic {
+			return stream, true
+		}
+	}
+	return StreamInfo{}, false
+}
+
+func (mi MediaInfo) HasAudio() bool {
+	_, ok := mi.Audio()
+	return ok
+}
+
+// DisplaySize is the size the stream is shown at, ffmpeg rotates the frames accordingly when decoding
+func (si StreamInfo) DisplaySize() (int, int) {
+	if si.Rotation%180 == 90 {
+		return si.Height, si.Width
+	}
+	return si.Width, si.Height
+}
+
+// HasAlpha reports whether the stream is transparent. VP8/VP9 keep the alpha in a side channel,
+// so the pixel format doesn't say anything about it there
+func (si StreamInfo) HasAlpha() bool {
+	if si.Tags["alpha_mode"] == "1" {
+		return true
+	}
+	for _, alphaFormat := range []string{"yuva", "rgba", "bgra", "argb", "abgr", "gbrap", "ya8", "ya16"} {
+		if strings.HasPrefix(si.PixFmt, alphaFormat) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,156 @@
+package distorters
+
+import (
+	"context"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/graynk/distortioner/tools"
+)
+
+const rotatedPhoneVideo = `{
+    "streams": [
+        {
+            "index": 0,
+            "codec_name": "h264",
+            "codec_type": "video",
+            "width": 1920,
+            "height": 1080,
+            "pix_fmt": "yuv420p",
+            "r_frame_rate": "30/1",
+            "avg_frame_rate": "30000/1001",
+            "duration": "12.345000",
+            "disposition": {"default": 1, "attached_pic": 0},
+            "side_data_list": [
+                {"side_data_type": "Display Matrix", "displaymatrix": "...", "rotation": -90}
+            ]
+        },
+        {
+            "index": 1,
+            "codec_name": "aac",
+            "codec_type": "audio",
+            "duration": "12.400000",
+            "disposition": {"default": 1, "attached_pic": 0}
+        }
+    ],
+    "format": {
+        "format_name": "mov,mp4,m4a,3gp,3g2,mj2",
+        "duration": "12.400000"
+    }
+}`
+
+const transparentSticker = `{
+    "streams": [
+        {
+            "index": 0,
+            "codec_name": "vp9",
+            "codec_type": "video",
+            "width": 512,
+            "height": 512,
+            "pix_fmt": "yuv420p",
+            "r_frame_rate": "30/1",
+            "avg_frame_rate": "0/0",
+            "tags": {"ALPHA_MODE": "1", "DURATION": "00:00:02.900000000"}
+        }
+    ],
+    "format": {
+        "format_name": "matroska,webm",
+        "duration": "N/A"
+    }
+}`
+
+const music = `{
+    "streams": [
+        {"index": 0, "codec_name": "mp3", "codec_type": "audio", "duration": "200.5"},
+        {"index": 1, "codec_name": "mjpeg", "codec_type": "video", "width": 600, "height": 600, "pix_fmt": "yuvj420p",
+         "disposition": {"attached_pic": 1}}
+    ],
+    "format": {
+        "format_name": "mp3",
+        "duration": "200.500000",
+        "tags": {"title": "Song", "ARTIST": "Somebody"}
+    }
+}`
+
+func TestParseProbeRotatedVideo(t *testing.T) {
+	info, err := parseProbe([]byte(rotatedPhoneVideo))
+	assert.NoError(t, err)
+	assert.Equal(t, 12.4, info.Duration)
+	assert.True(t, info.HasAudio())
+	video, ok := info.Video()
+	assert.True(t, ok)
+	assert.Equal(t, 90, video.Rotation)
+	assert.Equal(t, "30000/1001", video.FrameRate)
+	assert.False(t, video.HasAlpha())
+	width, height := video.DisplaySize()
+	assert.Equal(t, []int{1080, 1920}, []int{width, height})
+}
+
+func TestParseProbeTransparentSticker(t *testing.T) {
+	info, err := parseProbe([]byte(transparentSticker))
+	assert.NoError(t, err)
+	assert.Equal(t, 0.0, info.Duration)
+	assert.False(t, info.HasAudio())
+	video, ok := info.Video()
+	assert.True(t, ok)
+	assert.True(t, video.HasAlpha())
+	assert.Equal(t, "30/1", video.FrameRate, "falls back to r_frame_rate")
+	assert.Equal(t, 0, video.Rotation)
+}
+
+func TestParseProbeMusic(t *testing.T) {
+	info, err := parseProbe([]byte(music))
+	assert.NoError(t, err)
+	assert.Equal(t, "Song", info.Tags["title"])
+	assert.Equal(t, "Somebody", info.Tags["artist"])
+	_, ok := info.Video()
+	assert.False(t, ok, "cover art is not a video")
+	cover, ok := info.Cover()
+	assert.True(t, ok)
+	assert.Equal(t, 600, cover.Width)
+}
+
+func TestParseProbeGarbage(t *testing.T) {
+	_, err := parseProbe([]byte("Duration: N/A"))
+	assert.Error(t, err)
+}
+
+func TestHasAlpha(t *testing.T) {
+	for _, pixFmt := range []string{"yuva420p", "rgba", "bgra", "argb", "gbrap", "ya8"} {
+		assert.True(t, StreamInfo{PixFmt: pixFmt}.HasAlpha(), pixFmt)
+	}
+	for _, pixFmt := range []string{"yuv420p", "rgb24", "gray", ""} {
+		assert.False(t, StreamInfo{PixFmt: pixFmt}.HasAlpha(), pixFmt)
+	}
+}
+
+// withFakeRunner swaps ffmpeg and friends for a fake until the test is over
+func withFakeRunner(t *testing.T, handle func(name string, args []string) ([]byte, error)) *tools.FakeRunner {
+	fake := tools.NewFakeRunner(handle)
+	previous := runner
+	SetRunner(fake)
+	t.Cleanup(func() {
+		SetRunner(previous)
+	})
+	return fake
+}
+
+func TestProbeMedia(t *testing.T) {
+	fake := withFakeRunner(t, func(name string, args []string) ([]byte, error) {
+		return []byte(music), nil
+	})
+	info, err := ProbeMedia(context.Background(), "song.mp3")
+	assert.NoError(t, err)
+	assert.Equal(t, 200.5, info.Duration)
+	assert.Equal(t, [][]string{{"ffprobe", "-v", "error", "-of", "json", "-show_format", "-show_streams", "song.mp3"}}, fake.Calls())
+}
+
+func TestProbeMediaFailure(t *testing.T) {
+	withFakeRunner(t, func(name string, args []string) ([]byte, error) {
+		return nil, &tools.RunError{Command: name, Err: errors.New("exit status 1"), Stderr: "song.mp3: Invalid data found when processing input"}
+	})
+	_, err := ProbeMedia(context.Background(), "song.mp3")
+	assert.ErrorContains(t, err, "Invalid data found")
+}
@@ -0,0 +1,53 @@
+package distorters
+
+import (
+	"context"
+	"strings"
+)
+
+type MediaKind int
+
+const (
+	KindUnknown MediaKind = iota
+	KindImage
+	KindAnimation
+	KindVideo
+	KindAudio
+)
+
+// SniffMedia figures out which pipeline can handle the file, since the MIME type of documents is whatever
+// the sender's client decided it to be. The MIME type is only used when probing can't tell the difference
+func SniffMedia(ctx context.Context, filename, mime string) MediaKind {
+	info, err := ProbeMedia(ctx, filename)
+	if err != nil {
+		// ffprobe doesn't know some of the more exotic image formats, ImageMagick might
+		if isImage(ctx, filename) {
+			return KindImage
+		}
+		return KindUnknown
+	}
+	_, hasVideo := info.Video()
+	hasAudio := info.HasAudio()
+	switch {
+	case info.Format == "gif":
+		return KindAnimation
+	case info.Format == "image2" || strings.HasSuffix(info.Format, "_pipe"):
+		return KindImage
+	case hasVideo && !hasAudio && strings.HasPrefix(mime, "image/"):
+		return KindAnimation // apng and the like
+	case hasVideo:
+		return KindVideo
+	case hasAudio:
+		return KindAudio
+	}
+	return KindUnknown
+}
+
+func isImage(ctx context.Context, filename string) bool {
+	_, err := runner.Run(ctx,
+		"magick",
+		"identify",
+		"-format", "%m",
+		filename+"[0]")
+	return err == nil
+}
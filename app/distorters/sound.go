@@ -1,10 +1,122 @@
 package distorters
 
-func DistortSound(filename, output string) error {
-	return runFfmpeg(
+import (
+	"context"
+	"strings"
+)
+
+const (
+	PresetVibrato     = "vibrato"
+	PresetPitchWobble = "pitch-wobble"
+	PresetBitcrush    = "bitcrush"
+	PresetReverse     = "reverse"
+	PresetChorus      = "chorus"
+	PresetFlanger     = "flanger"
+	PresetEarrape     = "earrape"
+	PresetRobot       = "robot"
+	PresetSlowDown    = "slow-down"
+
+	DefaultSoundPreset = PresetVibrato
+)
+
+// SoundPresets lists the names of all presets in the order they are shown in /settings
+var SoundPresets = []string{PresetVibrato, PresetPitchWobble, PresetBitcrush, PresetReverse, PresetChorus,
+	PresetFlanger, PresetEarrape, PresetRobot, PresetSlowDown}
+
+var soundPresets = map[string]FilterChain{
+	PresetVibrato: {
+		NewFilter("vibrato").With("f", 6).With("d", 1),
+	},
+	PresetPitchWobble: {
+		NewFilter("vibrato").With("f", 1.5).With("d", 1),
+		NewFilter("tremolo").With("f", 3).With("d", 0.3),
+	},
+	PresetBitcrush: {
+		NewFilter("acrusher").With("bits", 4).With("samples", 10).With("mode", "log").With("aa", 1),
+	},
+	PresetReverse: {
+		NewFilter("areverse"),
+	},
+	PresetChorus: {
+		NewFilter("chorus").WithValue(0.5).WithValue(0.9).WithValue("50|60|40").
+			WithValue("0.4|0.32|0.3").WithValue("0.25|0.4|0.3").WithValue("2|2.3|1.3"),
+	},
+	PresetFlanger: {
+		NewFilter("flanger").With("delay", 5).With("depth", 8).With("speed", 0.6).With("regen", 50),
+	},
+	PresetEarrape: {
+		NewFilter("volume").With("volume", "25dB"),
+		NewFilter("alimiter").With("limit", 0.8).With("attack", 1).With("release", 5),
+	},
+	PresetRobot: {
+		NewFilter("afftfilt").
+			With("real", "hypot(re,im)*sin(0)").
+			With("imag", "hypot(re,im)*cos(0)").
+			With("win_size", 512).
+			With("overlap", 0.75),
+	},
+	PresetSlowDown: {
+		NewFilter("atempo").With("tempo", 0.6),
+	},
+}
+
+// IsSoundPreset reports whether there's a preset with that name
+func IsSoundPreset(name string) bool {
+	_, ok := soundPresets[name]
+	return ok
+}
+
+// SoundFilter returns the filter for the preset, falling back to the default one for unknown names
+func SoundFilter(preset string) string {
+	chain, ok := soundPresets[preset]
+	if !ok {
+		chain = soundPresets[DefaultSoundPreset]
+	}
+	return chain.String()
+}
+
+// repeatablePresets get stronger with every pass. The rest are applied once: reversing twice plays forward again,
+// slowing down twice drifts away from the video, and crushing, clipping or robotizing twice adds nothing
+var repeatablePresets = map[string]bool{
+	PresetVibrato:     true,
+	PresetPitchWobble: true,
+	PresetChorus:      true,
+	PresetFlanger:     true,
+}
+
+// soundFilter repeats the preset's filter chain once for every pass, if the preset is one of repeatablePresets
+func (o Options) soundFilter() string {
+	preset := o.SoundPreset
+	if !IsSoundPreset(preset) {
+		preset = DefaultSoundPreset
+	}
+	passes := 1
+	if repeatablePresets[preset] {
+		passes = o.PassCount()
+	}
+	chains := make([]string, passes)
+	for i := range chains {
+		chains[i] = SoundFilter(preset)
+	}
+	return strings.Join(chains, ",")
+}
+
+func DistortSound(ctx context.Context, filename, output string, options Options) error {
+	args := append(options.seekArgs(),
+		"-i", filename,
+		"-vn",
+		"-c:a", "libopus",
+		"-af", options.soundFilter(),
+		output)
+	return atStage(StageSound, runFfmpeg(ctx, args...))
+}
+
+// ExtractSound re-encodes the soundtrack as-is, for when the chat prefers to keep the original audio
+func ExtractSound(ctx context.Context, filename, output string, options Options) error {
+	args := append(options.seekArgs(),
 		"-i", filename,
 		"-vn",
 		"-c:a", "libopus",
-		"-af", "vibrato=f=6:d=1",
 		output)
+	return atStage(StageSound, runFfmpeg(ctx, args...))
 }
@@ -0,0 +1,85 @@
+package distorters
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFilter(t *testing.T) {
+	assert.Equal(t, "areverse", NewFilter("areverse").String())
+	assert.Equal(t, "vibrato=f=6:d=1", NewFilter("vibrato").With("f", 6).With("d", 1).String())
+	assert.Equal(t, "chorus=0.5:0.9:50|60", NewFilter("chorus").WithValue(0.5).WithValue(0.9).WithValue("50|60").String())
+	assert.Equal(t, "afftfilt=real='hypot(re,im)'", NewFilter("afftfilt").With("real", "hypot(re,im)").String())
+	assert.Equal(t, `drawtext=text='it'\''s'`, NewFilter("drawtext").With("text", "it's").String())
+
+	// With must not modify the original filter
+	base := NewFilter("volume")
+	louder := base.With("volume", 2)
+	quieter := base.With("volume", 0.5)
+	assert.Equal(t, "volume", base.String())
+	assert.Equal(t, "volume=volume=2", louder.String())
+	assert.Equal(t, "volume=volume=0.5", quieter.String())
+}
+
+func TestFilterChain(t *testing.T) {
+	chain := FilterChain{NewFilter("areverse"), NewFilter("atempo").With("tempo", 0.6)}
+	assert.Equal(t, "areverse,atempo=tempo=0.6", chain.String())
+	assert.Equal(t, "", FilterChain{}.String())
+}
+
+func TestSoundPresets(t *testing.T) {
+	expected := map[string]string{
+		PresetVibrato:     "vibrato=f=6:d=1",
+		PresetPitchWobble: "vibrato=f=1.5:d=1,tremolo=f=3:d=0.3",
+		PresetBitcrush:    "acrusher=bits=4:samples=10:mode=log:aa=1",
+		PresetReverse:     "areverse",
+		PresetChorus:      "chorus=0.5:0.9:50|60|40:0.4|0.32|0.3:0.25|0.4|0.3:2|2.3|1.3",
+		PresetFlanger:     "flanger=delay=5:depth=8:speed=0.6:regen=50",
+		PresetEarrape:     "volume=volume=25dB,alimiter=limit=0.8:attack=1:release=5",
+		PresetRobot:       "afftfilt=real='hypot(re,im)*sin(0)':imag='hypot(re,im)*cos(0)':win_size=512:overlap=0.75",
+		PresetSlowDown:    "atempo=tempo=0.6",
+	}
+	assert.Len(t, SoundPresets, len(expected))
+	for _, preset := range SoundPresets {
+		assert.True(t, IsSoundPreset(preset))
+		assert.Equal(t, expected[preset], SoundFilter(preset), preset)
+	}
+	assert.False(t, IsSoundPreset("nope"))
+	assert.Equal(t, expected[DefaultSoundPreset], SoundFilter("nope"))
+	assert.Equal(t, expected[DefaultSoundPreset], SoundFilter(""))
+}
+
+func TestDistortSoundCommand(t *testing.T) {
+	fake := withFakeRunner(t, nil)
+	options := DefaultOptions()
+	options.SoundPreset = PresetReverse
+	options.Start, options.Length = 30, 20
+	assert.NoError(t, DistortSound(context.Background(), "in", "out.ogg", options))
+	assert.Equal(t, [][]string{{"ffmpeg", "-ss", "30.000", "-t", "20.000", "-i", "in", "-vn", "-c:a", "libopus", "-af", "areverse", "out.ogg"}}, fake.Calls())
+}
+
+func TestDistortSoundPasses(t *testing.T) {
+	fake := withFakeRunner(t, nil)
+	options := DefaultOptions()
+	options.SoundPreset = PresetVibrato
+	options.Passes = 3
+	assert.NoError(t, DistortSound(context.Background(), "in", "out.ogg", options))
+	assert.Contains(t, fake.Calls()[0], "vibrato=f=6:d=1,vibrato=f=6:d=1,vibrato=f=6:d=1")
+}
+
+func TestSoundFilterPasses(t *testing.T) {
+	options := DefaultOptions()
+	options.Passes = 2
+	for preset, expected := range map[string]string{
+		PresetReverse:     "areverse", // twice would play forward again
+		PresetSlowDown:    "atempo=tempo=0.6",
+		PresetEarrape:     "volume=volume=25dB,alimiter=limit=0.8:attack=1:release=5",
+		PresetPitchWobble: "vibrato=f=1.5:d=1,tremolo=f=3:d=0.3,vibrato=f=1.5:d=1,tremolo=f=3:d=0.3",
+		"nope":            "vibrato=f=6:d=1,vibrato=f=6:d=1",
+	} {
+		options.SoundPreset = preset
+		assert.Equal(t, expected, options.soundFilter(), preset)
+	}
+}
@@ -0,0 +1,49 @@
+package distorters
+
+import (
+	"github.com/pkg/errors"
+)
+
+// Stage is the step of the pipeline, used to tell where exactly the distortion failed
+type Stage string
+
+const (
+	StageProbe    Stage = "probe"
+	StageExtract  Stage = "extract"
+	StageDistort  Stage = "distort"
+	StageEncode   Stage = "encode"
+	StageSound    Stage = "sound"
+	StageMux      Stage = "mux"
+	StageConvert  Stage = "convert"
+	StageValidate Stage = "validate"
+)
+
+type StageError struct {
+	Stage Stage
+	Err   error
+}
+
+func (e *StageError) Error() string {
+	return string(e.Stage) + ": " + e.Err.Error()
+}
+
+func (e *StageError) Unwrap() error {
+	return e.Err
+}
+
+// StageOf returns the stage the error happened at, or an empty string if nobody said
+func StageOf(err error) Stage {
+	var stageError *StageError
+	if errors.As(err, &stageError) {
+		return stageError.Stage
+	}
+	return ""
+}
+
+// atStage marks the error with the stage, unless something deeper down already knows better
+func atStage(stage Stage, err error) error {
+	if err == nil || StageOf(err) != "" {
+		return err
+	}
+	return &StageError{Stage: stage, Err: err}
+}
@@ -0,0 +1,32 @@
+package distorters
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/graynk/distortioner/tools"
+)
+
+func TestStageOf(t *testing.T) {
+	withFakeRunner(t, func(name string, args []string) ([]byte, error) {
+		return nil, &tools.RunError{Command: name, Err: errors.New("exit status 1"), Stderr: "Invalid argument"}
+	})
+	err := DistortSound(context.Background(), "voice.ogg", "voice.ogg.ogg", DefaultOptions())
+	assert.Equal(t, StageSound, StageOf(err))
+	var runError *tools.RunError
+	assert.ErrorAs(t, err, &runError)
+	assert.Equal(t, "Invalid argument", runError.Stderr)
+
+	err = CollectAnimationAndSound(context.Background(), "video.mp4", "", "videoFinal.mp4")
+	assert.Equal(t, StageMux, StageOf(err))
+}
+
+func TestAtStageKeepsDeeperStage(t *testing.T) {
+	err := atStage(StageEncode, atStage(StageDistort, errors.New("magick died")))
+	assert.Equal(t, StageDistort, StageOf(err))
+	assert.Nil(t, atStage(StageEncode, nil))
+	assert.Equal(t, Stage(""), StageOf(errors.New("who knows")))
+}
@@ -0,0 +1,86 @@
+package distorters
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	StickerSide    = 512        // one side of a sticker has to be exactly that, the other one can't be bigger
+	MaxStickerSize = 512 * 1024 // bytes
+)
+
+var ErrBadSticker = errors.New("the result is not a valid sticker")
+
+// DistortSticker distorts a static sticker into a WEBP that Telegram accepts as a sticker, so that it can be saved
+// or added to a pack: one side exactly StickerSide, the other one at most StickerSide, transparency intact
+func DistortSticker(ctx context.Context, filename, output string, options Options) error {
+	rescale, _ := options.rescaleGeometry()
+	fitSticker := fmt.Sprintf("%dx%d", StickerSide, StickerSide) // no flags, so it scales up as well as down
+	args := []string{filename,
+		"-alpha", "set",
+		"-background", "none",
+		"-resize", fitSticker + ">"}
+	for i := 0; i < options.PassCount(); i++ {
+		args = append(args, "-liquid-rescale", rescale, "-resize", fitSticker)
+	}
+	err := runMagick(ctx, append(args, "-quality", "90", "webp:"+output)...)
+	if err != nil {
+		return atStage(StageDistort, err)
+	}
+	if ValidateSticker(ctx, output) == nil {
+		return nil
+	}
+	// big noisy stickers might not fit into the size limit, give it another go with worse quality
+	err = runMagick(ctx,
+		output,
+		"-resize", fitSticker,
+		"-quality", "50",
+		"webp:"+output)
+	if err != nil {
+		return atStage(StageDistort, err)
+	}
+	return atStage(StageValidate, ValidateSticker(ctx, output))
+}
+
+// ValidateSticker checks the file against Telegram requirements for static stickers
+func ValidateSticker(ctx context.Context, filename string) error {
+	stat, err := os.Stat(filename)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	if stat.Size() > MaxStickerSize {
+		return errors.Wrapf(ErrBadSticker, "%d bytes", stat.Size())
+	}
+	output, err := runner.Run(ctx,
+		"magick",
+		"identify",
+		"-format", "%m %w %h",
+		filename+"[0]")
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	var format string
+	var width, height int
+	_, err = fmt.Sscanf(strings.TrimSpace(string(output)), "%s %d %d", &format, &width, &height)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	return validateSticker(format, width, height)
+}
+
+func validateSticker(format string, width, height int) error {
+	switch {
+	case format != "WEBP":
+		return errors.Wrapf(ErrBadSticker, "format %s", format)
+	case width > StickerSide || height > StickerSide:
+		return errors.Wrapf(ErrBadSticker, "%dx%d is too big", width, height)
+	case width != StickerSide && height != StickerSide:
+		return errors.Wrapf(ErrBadSticker, "%dx%d has no %dpx side", width, height, StickerSide)
+	}
+	return nil
+}
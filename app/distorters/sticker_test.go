@@ -0,0 +1,51 @@
+package distorters
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateSticker(t *testing.T) {
+	assert.NoError(t, validateSticker("WEBP", 512, 512))
+	assert.NoError(t, validateSticker("WEBP", 512, 300))
+	assert.NoError(t, validateSticker("WEBP", 100, 512))
+
+	assert.ErrorIs(t, validateSticker("PNG", 512, 512), ErrBadSticker)
+	assert.ErrorIs(t, validateSticker("WEBP", 1024, 512), ErrBadSticker)
+	assert.ErrorIs(t, validateSticker("WEBP", 511, 300), ErrBadSticker)
+}
+
+func TestDistortStickerRetriesWithWorseQuality(t *testing.T) {
+	dir := t.TempDir()
+	output := dir + "/sticker.webp"
+	identified := 0
+	fake := withFakeRunner(t, func(name string, args []string) ([]byte, error) {
+		if args[0] == "identify" {
+			identified++
+			if identified == 1 {
+				return []byte("WEBP 511 340"), nil // pretend rounding went wrong the first time around
+			}
+			return []byte("WEBP 512 340"), nil
+		}
+		return nil, os.WriteFile(output, []byte("webp"), 0644)
+	})
+	assert.NoError(t, DistortSticker(context.Background(), "in", output, DefaultOptions()))
+	calls := fake.Calls()
+	assert.Len(t, calls, 4)
+	assert.Contains(t, calls[2], "50", "second attempt is with worse quality")
+}
+
+func TestDistortStickerGivesUp(t *testing.T) {
+	dir := t.TempDir()
+	output := dir + "/sticker.webp"
+	withFakeRunner(t, func(name string, args []string) ([]byte, error) {
+		if args[0] == "identify" {
+			return []byte("WEBP 600 600"), nil
+		}
+		return nil, os.WriteFile(output, []byte("webp"), 0644)
+	})
+	assert.ErrorIs(t, DistortSticker(context.Background(), "in", output, DefaultOptions()), ErrBadSticker)
+}
@@ -1,17 +1,308 @@
 package distorters
 
 import (
+	"math/rand"
 	"strings"
 	"unicode"
+
+	tb "gopkg.in/telebot.v3"
+)
+
+type TextMode string
+
+const (
+	TextCase      TextMode = "case"
+	TextZalgo     TextMode = "zalgo"
+	TextHomoglyph TextMode = "homoglyph"
+	TextShuffle   TextMode = "shuffle"
+	TextTypo      TextMode = "typo"
+	TextStutter   TextMode = "stutter"
+
+	DefaultTextMode = TextCase
+)
+
+// TextModes lists all the modes in the order they are shown to users
+var TextModes = []TextMode{TextCase, TextZalgo, TextHomoglyph, TextShuffle, TextTypo, TextStutter}
+
+const (
+	MaxTextLength    = 4096 // Telegram limits, in UTF-16 code units
+	MaxCaptionLength = 1024
 )
 
+// textDistorter turns a run of text into pieces, one per rune of the original text.
+// Keeping that correspondence is what lets us move the entities around afterwards
+type textDistorter func(runes []rune) [][]rune
+
+var textDistorters = map[TextMode]func() textDistorter{
+	TextCase:      alternateCase,
+	TextZalgo:     func() textDistorter { return zalgo },
+	TextHomoglyph: func() textDistorter { return homoglyphs },
+	TextShuffle:   func() textDistorter { return shuffle },
+	TextTypo:      func() textDistorter { return typos },
+	TextStutter:   func() textDistorter { return stutter },
+}
+
+// entities with these types stop working if their text is changed, so it is left as-is
+var protectedEntities = map[tb.EntityType]any{
+	tb.EntityMention:     nil,
+	tb.EntityHashtag:     nil,
+	tb.EntityCashtag:     nil,
+	tb.EntityCommand:     nil,
+	tb.EntityURL:         nil,
+	tb.EntityEmail:       nil,
+	tb.EntityPhone:       nil,
+	tb.EntityCode:        nil,
+	tb.EntityCodeBlock:   nil,
+	tb.EntityCustomEmoji: nil,
+}
+
+func IsTextMode(mode string) bool {
+	_, ok := textDistorters[TextMode(mode)]
+	return ok
+}
+
 func DistortText(text string) string {
+	distorted, _ := DistortTextEntities(text, nil, DefaultTextMode, MaxTextLength)
+	return distorted
+}
+
+// DistortTextEntities distorts the text with the given mode, leaving links, mentions and code intact and moving
+// the entities to where their text ended up. If the result doesn't fit into the limit, falls back to changing the case
+func DistortTextEntities(text string, entities tb.Entities, mode TextMode, limit int) (string, tb.Entities) {
+	newDistorter, ok := textDistorters[mode]
+	if !ok {
+		newDistorter = textDistorters[DefaultTextMode]
+	}
+	distorted, distortedEntities := distortTextEntities(text, entities, newDistorter())
+	if mode != TextCase && utf16Length([]rune(distorted)) > limit {
+		return distortTextEntities(text, entities, alternateCase())
+	}
+	return distorted, distortedEntities
+}
+
+func distortTextEntities(text string, entities tb.Entities, distorter textDistorter) (string, tb.Entities) {
+	runes := []rune(text)
+	starts := make([]int, len(runes)) // UTF-16 offset of each rune
+	offset := 0
+	for i, r := range runes {
+		starts[i] = offset
+		offset += utf16RuneLen(r)
+	}
+
+	protected := make([]bool, len(runes))
+	for _, entity := range entities {
+		if _, ok := protectedEntities[entity.Type]; !ok {
+			continue
+		}
+		for i, start := range starts {
+			if start >= entity.Offset && start < entity.Offset+entity.Length {
+				protected[i] = true
+			}
+		}
+	}
+
+	pieces := make([][]rune, len(runes))
+	for i := 0; i < len(runes); {
+		if protected[i] {
+			pieces[i] = runes[i : i+1]
+			i++
+			continue
+		}
+		end := i
+		for end < len(runes) && !protected[end] {
+			end++
+		}
+		copy(pieces[i:end], distorter(runes[i:end]))
+		i = end
+	}
+
+	// old UTF-16 offset -> new UTF-16 offset, for every rune boundary
+	offsets := make(map[int]int, len(runes)+1)
+	var builder strings.Builder
+	newOffset := 0
+	for i, piece := range pieces {
+		offsets[starts[i]] = newOffset
+		newOffset += utf16Length(piece)
+		builder.WriteString(string(piece))
+	}
+	offsets[offset] = newOffset
+
+	var distortedEntities tb.Entities
+	for _, entity := range entities {
+		start, okStart := offsets[entity.Offset]
+		end, okEnd := offsets[entity.Offset+entity.Length]
+		if !okStart || !okEnd {
+			continue // points into the middle of a surrogate pair, or outside the text. Nothing to save there
+		}
+		entity.Offset = start
+		entity.Length = end - start
+		distortedEntities = append(distortedEntities, entity)
+	}
+	return builder.String(), distortedEntities
+}
+
+func utf16Length(runes []rune) int {
+	length := 0
+	for _, r := range runes {
+		length += utf16RuneLen(r)
+	}
+	return length
+}
+
+// utf16RuneLen is how many UTF-16 code units the rune takes, the unit Telegram measures entities in
+func utf16RuneLen(r rune) int {
+	if r >= 0x10000 {
+		return 2
+	}
+	return 1
+}
+
+func single(runes []rune, transform func(r rune) rune) [][]rune {
+	pieces := make([][]rune, len(runes))
+	for i, r := range runes {
+		pieces[i] = []rune{transform(r)}
+	}
+	return pieces
+}
+
+func alternateCase() textDistorter {
 	count := 0
-	return strings.Map(func(r rune) rune {
-		count++ // index in `i, r := range text` counts +2 for 2-byte symbols, so count separate count is needed anyway
-		if count%2 == 0 {
-			return unicode.ToUpper(r)
+	return func(runes []rune) [][]rune {
+		return single(runes, func(r rune) rune {
+			count++ // index in `i, r := range text` counts +2 for 2-byte symbols, so count separate count is needed anyway
+			if count%2 == 0 {
+				return unicode.ToUpper(r)
+			}
+			return unicode.ToLower(r)
+		})
+	}
+}
+
+func zalgo(runes []rune) [][]rune {
+	pieces := make([][]rune, len(runes))
+	for i, r := range runes {
+		pieces[i] = []rune{r}
+		if !unicode.IsLetter(r) {
+			continue
+		}
+		for marks := 1 + rand.Intn(3); marks > 0; marks-- {
+			pieces[i] = append(pieces[i], rune(0x0300+rand.Intn(0x70))) // combining diacritical marks
+		}
+	}
+	return pieces
+}
+
+var homoglyphMap = map[rune]rune{
+	'a': 'а', 'c': 'с', 'e': 'е', 'i': 'і', 'o': 'о', 'p': 'р', 'x': 'х', 'y': 'у',
+	'A': 'А', 'B': 'В', 'C': 'С', 'E': 'Е', 'H': 'Н', 'K': 'К', 'M': 'М', 'O': 'О', 'P': 'Р', 'T': 'Т', 'X': 'Х',
+	'а': 'a', 'с': 'c', 'е': 'e', 'о': 'o', 'р': 'p', 'х': 'x', 'у': 'y',
+	'А': 'A', 'В': 'B', 'С': 'C', 'Е': 'E', 'Н': 'H', 'К': 'K', 'М': 'M', 'О': 'O', 'Р': 'P', 'Т': 'T', 'Х': 'X',
+}
+
+func homoglyphs(runes []rune) [][]rune {
+	return single(runes, func(r rune) rune {
+		if glyph, ok := homoglyphMap[r]; ok && rand.Intn(2) == 0 {
+			return glyph
+		}
+		return r
+	})
+}
+
+// words calls f with the boundaries of every run of letters
+func words(runes []rune, f func(start, end int)) {
+	for i := 0; i < len(runes); {
+		if !unicode.IsLetter(runes[i]) {
+			i++
+			continue
+		}
+		end := i
+		for end < len(runes) && unicode.IsLetter(runes[end]) {
+			end++
+		}
+		f(i, end)
+		i = end
+	}
+}
+
+func shuffle(runes []rune) [][]rune {
+	shuffled := make([]rune, len(runes))
+	copy(shuffled, runes)
+	words(shuffled, func(start, end int) {
+		if end-start < 4 {
+			return
+		}
+		inner := shuffled[start+1 : end-1]
+		rand.Shuffle(len(inner), func(i, j int) {
+			inner[i], inner[j] = inner[j], inner[i]
+		})
+	})
+	pieces := make([][]rune, len(runes))
+	for i, r := range shuffled {
+		pieces[i] = []rune{r}
+	}
+	return pieces
+}
+
+var keyboardRows = []string{"qwertyuiop", "asdfghjkl", "zxcvbnm", "йцукенгшщзхъ", "фывапролджэ", "ячсмитьбю"}
+
+var keyboardNeighbors = func() map[rune][]rune {
+	neighbors := make(map[rune][]rune)
+	rows := make([][]rune, len(keyboardRows))
+	for i, row := range keyboardRows {
+		rows[i] = []rune(row)
+	}
+	for i, row := range rows {
+		for j, r := range row {
+			if j > 0 {
+				neighbors[r] = append(neighbors[r], row[j-1])
+			}
+			if j < len(row)-1 {
+				neighbors[r] = append(neighbors[r], row[j+1])
+			}
+			// rows of the same layout come in threes
+			if i%3 > 0 && j < len(rows[i-1]) {
+				neighbors[r] = append(neighbors[r], rows[i-1][j])
+			}
+			if i%3 < 2 && j < len(rows[i+1]) {
+				neighbors[r] = append(neighbors[r], rows[i+1][j])
+			}
+		}
+	}
+	return neighbors
+}()
+
+func typos(runes []rune) [][]rune {
+	return single(runes, func(r rune) rune {
+		neighbors, ok := keyboardNeighbors[unicode.ToLower(r)]
+		if !ok || rand.Intn(6) != 0 {
+			return r
+		}
+		typo := neighbors[rand.Intn(len(neighbors))]
+		if unicode.IsUpper(r) {
+			return unicode.ToUpper(typo)
+		}
+		return typo
+	})
+}
+
+func stutter(runes []rune) [][]rune {
+	pieces := make([][]rune, len(runes))
+	for i, r := range runes {
+		pieces[i] = []rune{r}
+	}
+	first := true
+	words(runes, func(start, end int) {
+		if end-start < 2 || (!first && rand.Intn(3) != 0) {
+			return
+		}
+		first = false
+		letter := runes[start]
+		lower := unicode.ToLower(letter)
+		piece := []rune{letter, '-'}
+		for repeats := rand.Intn(2); repeats > 0; repeats-- {
+			piece = append(piece, lower, '-')
 		}
-		return unicode.ToLower(r)
-	}, text)
+		pieces[start] = append(piece, lower)
+	})
+	return pieces
 }
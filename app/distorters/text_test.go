@@ -0,0 +1,84 @@
+package distorters
+
+import (
+	"strings"
+	"testing"
+	"unicode"
+	"unicode/utf16"
+
+	"github.com/stretchr/testify/assert"
+	tb "gopkg.in/telebot.v3"
+)
+
+// entityText cuts the entity out of the text the same way Telegram does, in UTF-16 code units
+func entityText(text string, entity tb.MessageEntity) string {
+	units := utf16.Encode([]rune(text))
+	return string(utf16.Decode(units[entity.Offset : entity.Offset+entity.Length]))
+}
+
+func TestDistortTextCase(t *testing.T) {
+	assert.Equal(t, "hElLo, WoRlD", DistortText("Hello, world"))
+	assert.Equal(t, "пРиВеТ 👋 МиР", DistortText("привет 👋 мир"))
+}
+
+func TestDistortTextEntities(t *testing.T) {
+	text := "Check 😀 https://example.com with @someone, it is really bold"
+	url := tb.MessageEntity{Type: tb.EntityURL, Offset: 9, Length: 19}
+	mention := tb.MessageEntity{Type: tb.EntityMention, Offset: 34, Length: 8}
+	bold := tb.MessageEntity{Type: tb.EntityBold, Offset: 50, Length: 11}
+	entities := tb.Entities{url, mention, bold}
+	assert.Equal(t, "https://example.com", entityText(text, url))
+	assert.Equal(t, "@someone", entityText(text, mention))
+	assert.Equal(t, "really bold", entityText(text, bold))
+
+	for _, mode := range TextModes {
+		distorted, distortedEntities := DistortTextEntities(text, entities, mode, MaxTextLength)
+		assert.Len(t, distortedEntities, len(entities), mode)
+		assert.Equal(t, "https://example.com", entityText(distorted, distortedEntities[0]), mode)
+		assert.Equal(t, "@someone", entityText(distorted, distortedEntities[1]), mode)
+		assert.Contains(t, distorted, "😀", mode)
+		boldText := entityText(distorted, distortedEntities[2])
+		assert.NotEmpty(t, boldText, mode)
+		assert.True(t, strings.HasSuffix(distorted, boldText), mode)
+	}
+}
+
+func TestDistortTextZalgoKeepsLetters(t *testing.T) {
+	distorted, _ := DistortTextEntities("zalgo", nil, TextZalgo, MaxTextLength)
+	stripped := strings.Map(func(r rune) rune {
+		if unicode.Is(unicode.Mn, r) {
+			return -1
+		}
+		return r
+	}, distorted)
+	assert.Equal(t, "zalgo", stripped)
+	assert.Greater(t, len([]rune(distorted)), len("zalgo"))
+}
+
+func TestDistortTextStutter(t *testing.T) {
+	distorted, entities := DistortTextEntities("Hello", tb.Entities{{Type: tb.EntityItalic, Offset: 0, Length: 5}}, TextStutter, MaxTextLength)
+	assert.True(t, strings.HasPrefix(distorted, "H-h"), distorted)
+	assert.True(t, strings.HasSuffix(distorted, "hello"), distorted)
+	assert.Equal(t, distorted, entityText(distorted, entities[0]))
+}
+
+func TestDistortTextLimit(t *testing.T) {
+	text := strings.Repeat("a", MaxCaptionLength)
+	distorted, _ := DistortTextEntities(text, nil, TextZalgo, MaxCaptionLength)
+	assert.Equal(t, DistortText(text), distorted)
+}
+
+func TestDistortTextBrokenEntities(t *testing.T) {
+	// an entity pointing into the middle of the emoji can't be moved, it gets dropped instead of breaking the message
+	_, entities := DistortTextEntities("😀 hi", tb.Entities{{Type: tb.EntityBold, Offset: 1, Length: 3}}, TextCase, MaxTextLength)
+	assert.Empty(t, entities)
+	_, entities = DistortTextEntities("hi", tb.Entities{{Type: tb.EntityBold, Offset: 0, Length: 10}}, TextCase, MaxTextLength)
+	assert.Empty(t, entities)
+}
+
+func TestIsTextMode(t *testing.T) {
+	for _, mode := range TextModes {
+		assert.True(t, IsTextMode(string(mode)))
+	}
+	assert.False(t, IsTextMode("nope"))
+}
@@ -0,0 +1,93 @@
+package distorters
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	MaxVideoDuration        = 60 // seconds, anything longer gets trimmed
+	MaxVideoStickerDuration = 30
+)
+
+var ErrOutOfRange = errors.New("start is past the end of the media")
+
+// timestampPart is what goes between the colons, plain digits with an optional fraction: no signs, exponents or NaNs
+var timestampPart = regexp.MustCompile(`^[0-9]+(\.[0-9]+)?$`)
+
+// Clip fits the requested range into the duration of the media and the limit.
+// Reports whether the result is shorter than the whole media
+func (o Options) Clip(duration, limit float64) (Options, bool, error) {
+	if duration <= 0 {
+		// nobody knows how long it is, so just make sure we don't go past the limit
+		if limit > 0 && (o.Length == 0 || o.Length > limit) {
+			o.Length = limit
+		}
+		return o, false, nil
+	}
+	if o.Start >= duration {
+		return o, false, ErrOutOfRange
+	}
+	end := duration
+	if o.Length > 0 && o.Start+o.Length < end {
+		end = o.Start + o.Length
+	}
+	if limit > 0 && end-o.Start > limit {
+		end = o.Start + limit
+	}
+	o.Length = end - o.Start
+	return o, o.Start > 0 || end < duration, nil
+}
+
+func (o Options) maxDuration() float64 {
+	if o.MaxDuration <= 0 {
+		return MaxVideoDuration
+	}
+	return o.MaxDuration
+}
+
+// seekArgs are the input options that cut out the requested range, they go before -i
+func (o Options) seekArgs() []string {
+	var args []string
+	if o.Start > 0 {
+		args = append(args, "-ss", strconv.FormatFloat(o.Start, 'f', 3, 64))
+	}
+	if o.Length > 0 {
+		args = append(args, "-t", strconv.FormatFloat(o.Length, 'f', 3, 64))
+	}
+	return args
+}
+
+// ParseTimestamp parses seconds, m:ss or h:mm:ss into seconds
+func ParseTimestamp(timestamp string) (float64, error) {
+	parts := strings.Split(timestamp, ":")
+	if len(parts) > 3 {
+		return 0, errors.Errorf("bad timestamp %s", timestamp)
+	}
+	seconds := 0.0
+	for i, part := range parts {
+		if !timestampPart.MatchString(part) {
+			return 0, errors.Errorf("bad timestamp %s", timestamp)
+		}
+		value, err := strconv.ParseFloat(part, 64)
+		if err != nil || math.IsNaN(value) || math.IsInf(value, 0) || value < 0 || (i > 0 && value >= 60) {
+			return 0, errors.Errorf("bad timestamp %s", timestamp)
+		}
+		seconds = seconds*60 + value
+	}
+	return seconds, nil
+}
+
+// FormatTimestamp is the reverse of ParseTimestamp, rounded to whole seconds
+func FormatTimestamp(seconds float64) string {
+	total := int(seconds + 0.5)
+	if total >= 3600 {
+		return fmt.Sprintf("%d:%02d:%02d", total/3600, total/60%60, total%60)
+	}
+	return fmt.Sprintf("%d:%02d", total/60, total%60)
+}
@@ -0,0 +1,57 @@
+package distorters
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClip(t *testing.T) {
+	clip := func(start, length, duration, limit float64) (float64, float64, bool) {
+		options, trimmed, err := Options{Start: start, Length: length}.Clip(duration, limit)
+		assert.NoError(t, err)
+		return options.Start, options.Length, trimmed
+	}
+
+	start, length, trimmed := clip(0, 0, 45, MaxVideoDuration)
+	assert.Equal(t, []any{0.0, 45.0, false}, []any{start, length, trimmed})
+
+	start, length, trimmed = clip(0, 0, 61, MaxVideoDuration)
+	assert.Equal(t, []any{0.0, 60.0, true}, []any{start, length, trimmed})
+
+	start, length, trimmed = clip(30, 20, 120, MaxVideoDuration)
+	assert.Equal(t, []any{30.0, 20.0, true}, []any{start, length, trimmed})
+
+	start, length, trimmed = clip(30, 0, 120, MaxVideoDuration)
+	assert.Equal(t, []any{30.0, 60.0, true}, []any{start, length, trimmed})
+
+	start, length, trimmed = clip(10, 100, 40, MaxVideoStickerDuration)
+	assert.Equal(t, []any{10.0, 30.0, true}, []any{start, length, trimmed})
+
+	start, length, trimmed = clip(10, 0, 0, MaxVideoDuration)
+	assert.Equal(t, []any{10.0, 60.0, false}, []any{start, length, trimmed})
+
+	_, _, err := Options{Start: 50}.Clip(40, MaxVideoDuration)
+	assert.ErrorIs(t, err, ErrOutOfRange)
+}
+
+func TestSeekArgs(t *testing.T) {
+	assert.Empty(t, Options{}.seekArgs())
+	assert.Equal(t, []string{"-ss", "30.000", "-t", "20.000"}, Options{Start: 30, Length: 20}.seekArgs())
+	assert.Equal(t, []string{"-t", "60.000"}, Options{Length: 60}.seekArgs())
+}
+
+func TestTimestamps(t *testing.T) {
+	for timestamp, seconds := range map[string]float64{"30": 30, "0:30": 30, "1:05": 65, "1:00:01": 3601, "2.5": 2.5} {
+		parsed, err := ParseTimestamp(timestamp)
+		assert.NoError(t, err, timestamp)
+		assert.Equal(t, seconds, parsed, timestamp)
+	}
+	for _, timestamp := range []string{"", "a", "1:60", "-5", "1:2:3:4", "1:-1", "nan", "NaN", "inf", "+Inf", "1:nan", "1e3", "0x10", "+5", "5.", ".5", "1_0"} {
+		_, err := ParseTimestamp(timestamp)
+		assert.Error(t, err, timestamp)
+	}
+	assert.Equal(t, "0:30", FormatTimestamp(30))
+	assert.Equal(t, "1:05", FormatTimestamp(64.6))
+	assert.Equal(t, "1:00:01", FormatTimestamp(3601))
+}
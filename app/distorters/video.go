@@ -1,15 +1,31 @@
 package distorters
 
-func CollectAnimationAndSound(animation, sound, output string) error {
+import "context"
+
+func CollectAnimationAndSound(ctx context.Context, animation, sound, output string) error {
 	if sound != "" {
-		return runFfmpeg("-i", animation,
+		return atStage(StageMux, runFfmpeg(ctx, "-i", animation,
 			"-i", sound,
 			"-c:v", "copy",
 			"-c:a", "copy",
-			output)
+			output))
 	}
-	return runFfmpeg("-i", animation,
+	return atStage(StageMux, runFfmpeg(ctx, "-i", animation,
 		"-c:v", "copy",
 		"-an",
-		output)
+		output))
+}
+
+// ConvertToGif turns the distorted animation back into a .gif, for those who sent it as a file
+func ConvertToGif(ctx context.Context, input, output string) error {
+	return atStage(StageConvert, runFfmpeg(ctx, "-i", input,
+		"-vf", "split[a][b];[a]palettegen[p];[b][p]paletteuse",
+		"-f", "gif",
+		output))
+}
+
+// ConvertContainer re-encodes the distorted video into whatever container the output extension says,
+// letting ffmpeg pick the default codecs for it
+func ConvertContainer(ctx context.Context, input, output string) error {
+	return atStage(StageConvert, runFfmpeg(ctx, "-i", input, output))
 }
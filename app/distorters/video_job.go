@@ -0,0 +1,64 @@
+package distorters
+
+import (
+	"context"
+	"os"
+
+	"github.com/graynk/distortioner/locale"
+)
+
+// SoundMode is what happens to the sound of a video
+type SoundMode string
+
+const (
+	SoundNone    SoundMode = ""        // animations, the result has no sound at all
+	SoundKeep    SoundMode = "keep"    // the sound is kept as it was
+	SoundDistort SoundMode = "distort" // the sound gets distorted as well
+)
+
+// VideoJob is everything about distorting a video or an animation except for the file itself, so that it can be
+// handed over to a worker process. The range to distort is expected to be clipped already, see Options.Clip
+type VideoJob struct {
+	Info    MediaInfo
+	Options Options
+	Sound   SoundMode
+	Lang    string // for the progress reports
+}
+
+// Run distorts the file into output, reporting the progress until the channel is closed
+func (j VideoJob) Run(ctx context.Context, encoders *Encoders, filename, output string, progressChan chan string) error {
+	defer close(progressChan)
+	animation := output
+	if j.Sound != SoundNone {
+		animation = filename + ".mp4"
+		defer os.Remove(animation)
+	}
+	frames := make(chan string, 3)
+	errChan := make(chan error, 1)
+	go func() {
+		errChan <- DistortVideo(ctx, filename, j.Info, encoders, animation, j.Lang, j.Options, frames)
+	}()
+	for report := range frames {
+		progressChan <- report
+	}
+	err := <-errChan
+	if err != nil || j.Sound == SoundNone {
+		return err
+	}
+	sound := ""
+	if j.Info.HasAudio() {
+		sound = filename + ".ogg"
+		if j.Sound == SoundDistort {
+			err = DistortSound(ctx, filename, sound, j.Options)
+		} else {
+			err = ExtractSound(ctx, filename, sound, j.Options)
+		}
+		if err != nil {
+			sound = "" // better without sound than without a video
+		} else {
+			defer os.Remove(sound)
+		}
+	}
+	progressChan <- locale.Get(j.Lang, locale.Muxing)
+	return CollectAnimationAndSound(ctx, animation, sound, output)
+}
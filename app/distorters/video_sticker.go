@@ -1,50 +1,52 @@
 package distorters
 
 import (
+	"context"
 	"fmt"
-	"log"
 	"os"
-	"sync"
+	"path/filepath"
 
 	"github.com/pkg/errors"
 )
 
-func DistortVideoSticker(filename, output string, group *sync.WaitGroup) {
-	defer group.Done()
+func DistortVideoSticker(ctx context.Context, filename string, info MediaInfo, output string, options Options) error {
 	framesDir := filename + "Frames"
 	err := os.Mkdir(framesDir, 0755)
 	if err != nil {
-		err = errors.WithStack(err)
-		log.Println(err)
-		return
+		return atStage(StageExtract, errors.WithStack(err))
 	}
 	defer os.RemoveAll(framesDir)
-	frameRateFraction, duration, err := GetFrameRateFractionAndDuration(filename)
+	video, ok := info.Video()
+	if !ok {
+		return atStage(StageProbe, errors.New("no video stream"))
+	}
+	options, _, err = options.Clip(info.Duration, MaxVideoStickerDuration)
 	if err != nil {
-		return
-	} else if duration > 30 {
-		return
+		return atStage(StageExtract, err)
 	}
-	numberedFileName := fmt.Sprintf("%s/%s%%04d.png", framesDir, filename)
-	err = extractFramesFromVideoSticker(frameRateFraction, filename, numberedFileName)
+	// stickers have to stay 512px, so only the frame rate is taken from the plan
+	frameRateFraction := planProcessing(video, options.Length, options).FrameRate
+	numberedFileName := fmt.Sprintf("%s/%s%%04d.png", framesDir, filepath.Base(filename))
+	err = extractFramesFromVideoSticker(ctx, frameRateFraction, video, filename, numberedFileName, options)
 	if err != nil {
-		return
+		return atStage(StageExtract, errors.Wrap(err, "extracting the frames"))
 	}
 
 	distortedFrames := 0
 	doneChan := make(chan int, 8)
-	go poolDistortImages(framesDir, doneChan)
+	errChan := make(chan error, 1)
+	go poolDistortImages(ctx, framesDir, options, doneChan, errChan)
 
 	for totalFrames := <-doneChan; distortedFrames != totalFrames; {
 		framesDistorted := <-doneChan
 		if framesDistorted == -1 {
-			return
+			return atStage(StageDistort, <-errChan)
 		}
 		distortedFrames += framesDistorted
 	}
-	err = collectFramesToVideoSticker(numberedFileName, frameRateFraction, output)
+	err = collectFramesToVideoSticker(ctx, numberedFileName, frameRateFraction, output)
 	if err != nil {
-		log.Println(err)
+		return atStage(StageEncode, errors.Wrap(err, "collecting the frames"))
 	}
-	return
+	return nil
 }
@@ -1,10 +1,13 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"log"
 	"os"
 	"os/signal"
+	"path/filepath"
 	"strconv"
 	"strings"
 	"sync"
@@ -16,104 +19,170 @@ import (
 	"gopkg.in/telebot.v3/middleware"
 
 	"github.com/graynk/distortioner/distorters"
+	"github.com/graynk/distortioner/locale"
+	"github.com/graynk/distortioner/queue"
 	"github.com/graynk/distortioner/stats"
 	"github.com/graynk/distortioner/tools"
 )
 
-const (
-	MaxSizeMb = 20_000_000
-)
+const MaxAudioDuration = 600
 
 type DistorterBot struct {
 	adminID     int64
+	db          *stats.DistortionerDB
 	rl          *tools.RateLimiter
 	logger      *zap.SugaredLogger
 	mu          *sync.Mutex
 	graceWg     *sync.WaitGroup
 	videoWorker *tools.VideoWorker
-	codec       string
+	albums      *tools.AlbumCollector
+	encoders    *distorters.Encoders
+	processor   videoProcessor
+	workspace   *tools.Workspace
+	quarantine  *tools.Quarantine
+	botAPI      tools.BotAPI
+	// DISTORTIONER_PRIORITY_CHATS, which are in the priority tier unless put into another one with /priority
+	priorityChats map[int64]any
+	ctx           context.Context // cancelled on shutdown, taking whatever ffmpeg is still running with it
 }
 
 func (d DistorterBot) handleAnimationDistortion(c tb.Context) error {
 	m := c.Message()
-	b := c.Bot()
-	if m.Animation.FileSize > MaxSizeMb {
-		return d.SendMessageWithRepeater(c, distorters.TooBig)
-	} else if rate, diff := d.rl.GetRateOverPeriod(m.Chat.ID, time.Now().Unix()); rate > tools.AllowedOverTime {
-		return d.SendMessageWithRepeater(c, tools.FormatRateLimitResponse(diff))
+	lang := d.language(c)
+	if m.Animation.FileSize > d.maxFileSize(c) {
+		return d.notify(c, locale.Get(lang, locale.TooBig))
+	} else if d.rateLimited(c, lang) {
+		return nil
+	}
+	key := d.resultKey(c)
+	if d.sendCached(c, key) {
+		return nil
+	}
+
+	scratch := d.newScratch(c)
+	if scratch == nil {
+		return nil
 	}
 
 	//TODO: Jesus, just find the time to refactor all of this already
-	err := d.videoWorker.Submit(m.Chat.ID, func() {
-		progressMessage, filename, output, err := d.HandleAnimationCommon(c)
+	err := d.videoWorker.SubmitTier(m.Chat.ID, d.jobCost(c), d.tier(c).Queue, d.animationJob(c, scratch, key, ""))
+	if err != nil {
+		scratch.Close()
+		d.notify(c, queueErrorMessage(lang, err))
+		return nil
+	}
+	if d.videoWorker.IsBusy() {
+		d.notify(c, locale.Get(lang, locale.Queued))
+	}
+	return nil
+}
+
+// animationJob distorts the GIF and sends it back, downloading it first unless the file is already there
+func (d DistorterBot) animationJob(c tb.Context, scratch *tools.Scratch, key, filename string) func() {
+	return func() {
+		defer scratch.Close()
+		progressMessage, output, err := d.HandleAnimationCommon(c, scratch, filename)
 		failed := err != nil
 		if failed {
-			if progressMessage != nil && progressMessage.Text != distorters.TooLong {
-				d.DoneMessageWithRepeater(b, progressMessage, failed)
-			}
+			d.DoneMessageWithRepeater(c, progressMessage, failed)
 			d.logger.Error(err)
 			return
 		}
-		defer os.Remove(filename)
-		defer os.Remove(output)
 
 		// not sure why, but now I'm forced to specify filename manually
 		distorted := &tb.Animation{File: tb.FromDisk(output), FileName: output}
-		if m.Caption != "" {
-			distorted.Caption = distorters.DistortText(m.Caption)
+		var entities tb.Entities
+		distorted.Caption, entities = d.caption(c)
+		err = d.sendAndCache(c, key, distorted, entities)
+		d.DoneMessageWithRepeater(c, progressMessage, failed)
+		if err != nil {
+			d.logger.Error(err)
 		}
-		err = d.SendMessageWithRepeater(c, distorted)
-		d.DoneMessageWithRepeater(b, progressMessage, failed)
-	})
-	if err != nil {
-		d.SendMessageWithRepeater(c, err.Error())
-		return nil
 	}
-	if d.videoWorker.IsBusy() {
-		d.SendMessageWithRepeater(c, distorters.Queued)
-	}
-	return nil
 }
 
 func (d DistorterBot) handlePhotoDistortion(c tb.Context) error {
 	m := c.Message()
-	filename, err := tools.JustGetTheFile(c.Bot(), m)
+	lang := d.language(c)
+	if isAuto(c) && d.rateLimited(c, lang) {
+		return nil
+	}
+	key := d.resultKey(c)
+	if d.sendCached(c, key) {
+		return nil
+	}
+	scratch := d.newScratch(c)
+	if scratch == nil {
+		return nil
+	}
+	defer scratch.Close()
+	filename, err := tools.JustGetTheFile(c.Bot(), d.botAPI, m, lang, scratch)
 	if err != nil {
 		d.logger.Error(err)
 		return err
 	}
-	defer os.Remove(filename)
-	err = distorters.DistortImage(filename)
+	return d.distortPhotoFile(c, key, filename)
+}
+
+func (d DistorterBot) distortPhotoFile(c tb.Context, key, filename string) error {
+	err := distorters.DistortImagePasses(d.ctx, filename, d.options(c))
 	if err != nil {
-		d.SendMessageWithRepeater(c, distorters.Failed)
+		d.keepFailed(c, filename, err)
+		d.notify(c, locale.Get(d.language(c), locale.Failed))
 		return err
 	}
 	distorted := &tb.Photo{File: tb.FromDisk(filename)}
-	if m.Caption != "" {
-		distorted.Caption = distorters.DistortText(m.Caption)
-	}
-	return d.SendMessageWithRepeater(c, distorted)
+	var entities tb.Entities
+	distorted.Caption, entities = d.caption(c)
+	return d.sendAndCache(c, key, distorted, entities)
 }
 
 func (d DistorterBot) handleRegularStickerDistortion(c tb.Context) error {
 	m := c.Message()
-	filename, err := tools.JustGetTheFile(c.Bot(), m)
+	lang := d.language(c)
+	if isAuto(c) && d.rateLimited(c, lang) {
+		return nil
+	}
+	key := d.resultKey(c)
+	if d.sendCached(c, key) {
+		return nil
+	}
+	scratch := d.newScratch(c)
+	if scratch == nil {
+		return nil
+	}
+	defer scratch.Close()
+	filename, err := tools.JustGetTheFile(c.Bot(), d.botAPI, m, lang, scratch)
 	if err != nil {
 		d.logger.Error(err)
 		return err
 	}
-	defer os.Remove(filename)
-	err = distorters.DistortImage(filename)
+	return d.distortStickerFile(c, key, filename)
+}
+
+func (d DistorterBot) distortStickerFile(c tb.Context, key, filename string) error {
+	output := filename + ".webp"
+	err := distorters.DistortSticker(d.ctx, filename, output, d.options(c))
+	if errors.Is(err, distorters.ErrBadSticker) {
+		// still distorted, just not something Telegram would take as a sticker. Better a picture than nothing
+		d.logger.Warn(err)
+		converted := filename + ".png"
+		err = distorters.ConvertImage(d.ctx, output, converted)
+		if err == nil {
+			return d.sendAndCache(c, key, &tb.Photo{File: tb.FromDisk(converted)})
+		}
+	}
 	if err != nil {
-		d.SendMessageWithRepeater(c, distorters.Failed)
+		d.keepFailed(c, filename, err)
+		d.notify(c, locale.Get(d.language(c), locale.Failed))
 		return err
 	}
-	distorted := &tb.Sticker{File: tb.FromDisk(filename)}
-	return d.SendMessageWithRepeater(c, distorted)
+	distorted := &tb.Sticker{File: tb.FromDisk(output)}
+	return d.sendAndCache(c, key, distorted)
 }
 
 func (d DistorterBot) handleVideoStickerDistortion(c tb.Context) error {
-	return c.Reply("You can go vote for this suggestion, for .webm stickers handling to become somewhat tolerable https://bugs.telegram.org/c/14858")
+	return d.notify(c, locale.Get(d.language(c), locale.VideoStickers))
 }
 
 func (d DistorterBot) handleStickerDistortion(c tb.Context) error {
@@ -121,7 +190,7 @@ func (d DistorterBot) handleStickerDistortion(c tb.Context) error {
 	var err error
 	switch {
 	case m.Sticker.Animated:
-		err = d.SendMessageWithRepeater(c, NotSupported)
+		err = d.notify(c, locale.Get(d.language(c), locale.NotSupported))
 	case m.Sticker.Video:
 		err = d.handleVideoStickerDistortion(c)
 	default:
@@ -131,132 +200,267 @@ func (d DistorterBot) handleStickerDistortion(c tb.Context) error {
 }
 
 func (d DistorterBot) handleTextDistortion(c tb.Context) error {
-	return d.SendMessageWithRepeater(c, distorters.DistortText(c.Text()))
+	m := c.Message()
+	text, entities := distorters.DistortTextEntities(m.Text, m.Entities, d.options(c).TextMode, distorters.MaxTextLength)
+	return d.SendMessageWithRepeater(c, text, entities)
 }
 
 func (d DistorterBot) handleVideoDistortion(c tb.Context) error {
 	m := c.Message()
-	b := c.Bot()
-	if m.Video.FileSize > MaxSizeMb {
-		return d.SendMessageWithRepeater(c, distorters.TooBig)
-	} else if rate, diff := d.rl.GetRateOverPeriod(m.Chat.ID, time.Now().Unix()); rate > tools.AllowedOverTime {
-		return d.SendMessageWithRepeater(c, tools.FormatRateLimitResponse(diff))
+	lang := d.language(c)
+	if m.Video.FileSize > d.maxFileSize(c) {
+		return d.notify(c, locale.Get(lang, locale.TooBig))
+	} else if d.rateLimited(c, lang) {
+		return nil
+	}
+	key := d.resultKey(c)
+	if d.sendCached(c, key) {
+		return nil
+	}
+
+	scratch := d.newScratch(c)
+	if scratch == nil {
+		return nil
 	}
 
-	err := d.videoWorker.Submit(m.Chat.ID, func() {
-		output, progressMessage, err := d.HandleVideoCommon(c)
+	err := d.videoWorker.SubmitTier(m.Chat.ID, d.jobCost(c), d.tier(c).Queue, d.videoJob(c, scratch, key, ""))
+	if err != nil {
+		scratch.Close()
+		d.notify(c, queueErrorMessage(lang, err))
+		return nil
+	}
+	if d.videoWorker.IsBusy() {
+		d.notify(c, locale.Get(lang, locale.Queued))
+	}
+	return nil
+}
+
+// videoJob distorts the video and sends it back, downloading it first unless the file is already there
+func (d DistorterBot) videoJob(c tb.Context, scratch *tools.Scratch, key, filename string) func() {
+	return func() {
+		defer scratch.Close()
+		output, progressMessage, err := d.HandleVideoCommon(c, scratch, filename)
 		failed := err != nil
 		if failed {
-			if progressMessage != nil && progressMessage.Text != distorters.TooLong {
-				d.DoneMessageWithRepeater(b, progressMessage, failed)
-			}
+			d.DoneMessageWithRepeater(c, progressMessage, failed)
 			d.logger.Error(err)
 			return
 		}
-		defer os.Remove(output)
 
 		distorted := &tb.Video{File: tb.FromDisk(output)}
-		err = d.SendMessageWithRepeater(c, distorted)
-		d.DoneMessageWithRepeater(b, progressMessage, failed)
+		var entities tb.Entities
+		distorted.Caption, entities = d.caption(c)
+		err = d.sendAndCache(c, key, distorted, entities)
+		d.DoneMessageWithRepeater(c, progressMessage, failed)
 		if err != nil {
 			d.logger.Error(err)
 		}
-	})
+	}
+}
+
+func (d DistorterBot) handleVideoNoteDistortion(c tb.Context) error {
+	m := c.Message()
+	lang := d.language(c)
+	if m.VideoNote.FileSize > d.maxFileSize(c) {
+		return d.notify(c, locale.Get(lang, locale.TooBig))
+	} else if d.rateLimited(c, lang) {
+		return nil
+	}
+	key := d.resultKey(c)
+	if d.sendCached(c, key) {
+		return nil
+	}
+
+	scratch := d.newScratch(c)
+	if scratch == nil {
+		return nil
+	}
+
+	err := d.videoWorker.SubmitTier(m.Chat.ID, d.jobCost(c), d.tier(c).Queue, d.videoNoteJob(c, scratch, key, ""))
 	if err != nil {
-		d.SendMessageWithRepeater(c, err.Error())
+		scratch.Close()
+		d.notify(c, queueErrorMessage(lang, err))
 		return nil
 	}
 	if d.videoWorker.IsBusy() {
-		d.SendMessageWithRepeater(c, distorters.Queued)
+		d.notify(c, locale.Get(lang, locale.Queued))
 	}
 	return nil
 }
 
-func (d DistorterBot) handleVideoNoteDistortion(c tb.Context) error {
-	m := c.Message()
-	b := c.Bot()
-	if m.VideoNote.FileSize > MaxSizeMb {
-		return d.SendMessageWithRepeater(c, distorters.TooBig)
-	} else if rate, diff := d.rl.GetRateOverPeriod(m.Chat.ID, time.Now().Unix()); rate > tools.AllowedOverTime {
-		return d.SendMessageWithRepeater(c, tools.FormatRateLimitResponse(diff))
-	}
-
-	err := d.videoWorker.Submit(m.Chat.ID, func() {
-		output, progressMessage, err := d.HandleVideoCommon(c)
+// videoNoteJob distorts the video note and sends it back, downloading it first unless the file is already there
+func (d DistorterBot) videoNoteJob(c tb.Context, scratch *tools.Scratch, key, filename string) func() {
+	return func() {
+		defer scratch.Close()
+		output, progressMessage, err := d.HandleVideoCommon(c, scratch, filename)
 		failed := err != nil
 		if failed {
-			if progressMessage != nil && progressMessage.Text != distorters.TooLong {
-				d.logger.Error(err)
-				d.DoneMessageWithRepeater(b, progressMessage, failed)
-			}
+			d.DoneMessageWithRepeater(c, progressMessage, failed)
+			d.logger.Error(err)
 			return
 		}
-		defer os.Remove(output)
 		distorted := &tb.VideoNote{File: tb.FromDisk(output)}
-		err = d.SendMessageWithRepeater(c, distorted)
-		d.DoneMessageWithRepeater(b, progressMessage, failed)
-	})
-	if err != nil {
-		d.SendMessageWithRepeater(c, err.Error())
-		return nil
-	}
-	if d.videoWorker.IsBusy() {
-		d.SendMessageWithRepeater(c, distorters.Queued)
+		err = d.sendAndCache(c, key, distorted)
+		d.DoneMessageWithRepeater(c, progressMessage, failed)
+		if err != nil {
+			d.logger.Error(err)
+		} else if note := d.trimNote(c); note != "" {
+			// video notes can't have captions
+			d.notify(c, note)
+		}
 	}
-	return nil
 }
 
 func (d DistorterBot) handleVoiceDistortion(c tb.Context) error {
 	m := c.Message()
-	if m.Voice.FileSize > MaxSizeMb {
-		return c.Reply(distorters.TooBig)
+	lang := d.language(c)
+	if m.Voice.FileSize > d.maxFileSize(c) {
+		return d.notify(c, locale.Get(lang, locale.TooBig))
+	} else if isAuto(c) && d.rateLimited(c, lang) {
+		return nil
+	}
+	key := d.resultKey(c)
+	if d.sendCached(c, key) {
+		return nil
+	}
+	scratch := d.newScratch(c)
+	if scratch == nil {
+		return nil
 	}
-	filename, err := tools.JustGetTheFile(c.Bot(), m)
+	defer scratch.Close()
+	filename, err := tools.JustGetTheFile(c.Bot(), d.botAPI, m, lang, scratch)
 	if err != nil {
 		d.logger.Error(err)
 		return err
 	}
-	defer os.Remove(filename)
+	return d.distortVoiceFile(c, key, filename)
+}
+
+func (d DistorterBot) distortVoiceFile(c tb.Context, key, filename string) error {
 	output := filename + ".ogg"
-	err = distorters.DistortSound(filename, output)
+	err := distorters.DistortSound(d.ctx, filename, output, d.options(c))
 	if err != nil {
-		d.SendMessageWithRepeater(c, distorters.Failed)
+		d.keepFailed(c, filename, err)
+		d.notify(c, locale.Get(d.language(c), locale.Failed))
 		return err
 	}
-	defer os.Remove(output)
 
 	distorted := &tb.Voice{File: tb.FromDisk(output)}
-	return d.SendMessageWithRepeater(c, distorted)
+	return d.sendAndCache(c, key, distorted)
+}
+
+func (d DistorterBot) handleAudioDistortion(c tb.Context) error {
+	m := c.Message()
+	lang := d.language(c)
+	if m.Audio.FileSize > d.maxFileSize(c) {
+		return d.notify(c, locale.Get(lang, locale.TooBig))
+	} else if m.Audio.Duration > d.tier(c).MaxAudioDuration {
+		return d.notify(c, locale.Get(lang, locale.TooLong))
+	} else if d.rateLimited(c, lang) {
+		return nil
+	}
+	scratch := d.newScratch(c)
+	if scratch == nil {
+		return nil
+	}
+	defer scratch.Close()
+	filename, err := tools.JustGetTheFile(c.Bot(), d.botAPI, m, lang, scratch)
+	if err != nil {
+		d.logger.Error(err)
+		return err
+	}
+	return d.distortAudioFile(c, filename, m.Audio.Title, m.Audio.Performer, m.Audio.FileName)
+}
+
+// distortAudioFile distorts already downloaded music. Tags from the file are used for whatever Telegram didn't tell us
+func (d DistorterBot) distortAudioFile(c tb.Context, filename, title, performer, name string) error {
+	lang := d.language(c)
+	info, err := distorters.ProbeMedia(d.ctx, filename)
+	if err != nil {
+		d.keepFailed(c, filename, err)
+		d.notify(c, locale.Get(lang, locale.Failed))
+		return err
+	} else if info.Duration > float64(d.tier(c).MaxAudioDuration) {
+		// documents don't come with duration, so we only know it now
+		return d.notify(c, locale.Get(lang, locale.TooLong))
+	}
+	if title == "" {
+		title = info.Tags["title"]
+	}
+	if performer == "" {
+		performer = info.Tags["artist"]
+	}
+	output := filename + ".mp3"
+	err = distorters.DistortAudio(d.ctx, filename, info, output, title, d.options(c))
+	if err != nil {
+		d.keepFailed(c, filename, err)
+		d.notify(c, locale.Get(lang, locale.Failed))
+		return err
+	}
+
+	distorted := &tb.Audio{
+		File:      tb.FromDisk(output),
+		Title:     distorters.DistortText(title),
+		Performer: performer,
+		FileName:  replaceExt(name, ".mp3"),
+	}
+	var entities tb.Entities
+	distorted.Caption, entities = d.caption(c)
+	return d.SendMessageWithRepeater(c, distorted, d.resultButtons(c, entities)...)
 }
 
 func (d DistorterBot) handleReplyDistortion(c tb.Context) error {
 	m := c.Message()
 	if m.ReplyTo == nil {
-		msg := "You need to reply with this command to the media you want distorted."
+		lang := d.language(c)
+		msg := locale.Get(lang, locale.ReplyRequired)
 		if m.FromGroup() {
-			msg += "\nYou might also need to make chat history visible for new members if your group is private."
+			msg += "\n" + locale.Get(lang, locale.PrivateHistory)
 		}
 		return c.Reply(msg)
 	}
+	arguments, err := ParseArguments(c.Args())
+	if err != nil {
+		var argumentError ArgumentError
+		errors.As(err, &argumentError)
+		lang := d.language(c)
+		return c.Reply(locale.Get(lang, locale.BadArgument, argumentError.Argument,
+			strings.Join(distorters.SoundPresets, ", "), strings.Join(textModeNames(), ", ")))
+	}
 	original := m.ReplyTo
+	if original.AlbumID != "" && isAlbumItem(original) {
+		if items := d.albums.Album(original.AlbumID); len(items) > 1 {
+			d.handleAlbum(c.Bot(), items, arguments)
+			return nil
+		}
+	}
 	update := c.Update()
 	update.Message = original
-	tweakedContext := c.Bot().NewContext(update)
+	return d.distortMessage(d.withSettingsOf(withArguments(c.Bot().NewContext(update), arguments), c))
+}
+
+// distortMessage picks the handler for whatever is in the context's message
+func (d DistorterBot) distortMessage(c tb.Context) error {
+	m := c.Message()
 	switch {
-	case original.Animation != nil:
-		return d.handleAnimationDistortion(tweakedContext)
-	case original.Sticker != nil:
-		return d.handleStickerDistortion(tweakedContext)
-	case original.Photo != nil:
-		return d.handlePhotoDistortion(tweakedContext)
-	case original.Voice != nil:
-		return d.handleVoiceDistortion(tweakedContext)
-	case original.Video != nil:
-		return d.handleVideoDistortion(tweakedContext)
-	case original.VideoNote != nil:
-		return d.handleVideoNoteDistortion(tweakedContext)
-	case original.Text != "":
-		return d.handleTextDistortion(tweakedContext)
+	case m.Animation != nil:
+		return d.handleAnimationDistortion(c)
+	case m.Sticker != nil:
+		return d.handleStickerDistortion(c)
+	case m.Photo != nil:
+		return d.handlePhotoDistortion(c)
+	case m.Voice != nil:
+		return d.handleVoiceDistortion(c)
+	case m.Audio != nil:
+		return d.handleAudioDistortion(c)
+	case m.Document != nil:
+		return d.handleDocumentDistortion(c)
+	case m.Video != nil:
+		return d.handleVideoDistortion(c)
+	case m.VideoNote != nil:
+		return d.handleVideoNoteDistortion(c)
+	case m.Text != "":
+		return d.handleTextDistortion(c)
 	}
 	return nil
 }
@@ -292,10 +496,12 @@ _GIFs_: %d
 _Videos_: %d
 _Video notes_: %d
 _Voice messages_: %d
+_Music_: %d
 _Photos_: %d
+_Documents_: %d
 _Text messages_: %d
 `,
-		stat.Sticker, stat.Animation, stat.Video, stat.VideoNote, stat.Voice, stat.Photo, stat.Text)
+		stat.Sticker, stat.Animation, stat.Video, stat.VideoNote, stat.Voice, stat.Audio, stat.Photo, stat.Document, stat.Text)
 	return c.Reply(message+details, tb.ModeMarkdown)
 }
 
@@ -304,7 +510,40 @@ func (d DistorterBot) handleQueueStats(c tb.Context) error {
 		return nil
 	}
 	length, users := d.videoWorker.QueueStats()
-	return c.Reply(fmt.Sprintf("Currently in queue: %d requests from %d users", length, users))
+	var encoders []string
+	for _, encoder := range d.encoders.Stats() {
+		encoders = append(encoders, encoder.String())
+	}
+	return c.Reply(fmt.Sprintf("Currently in queue: %d requests from %d users\nEncoders: %s",
+		length, users, strings.Join(encoders, ", ")))
+}
+
+func (d DistorterBot) handleLanguage(c tb.Context) error {
+	m := c.Message()
+	lang := d.language(c)
+	available := strings.Join(locale.Languages, ", ")
+	args := c.Args()
+	if len(args) == 0 {
+		return c.Reply(locale.Get(lang, locale.LanguageCurrent, lang, available))
+	}
+	requested := strings.ToLower(args[0])
+	if requested == "auto" {
+		requested = ""
+	} else if !locale.IsSupported(requested) {
+		return c.Reply(locale.Get(lang, locale.LanguageUnknown, available))
+	}
+	if !d.canChangeSettings(c.Bot(), m.Chat, m.Sender, m.SenderChat) {
+		return c.Reply(locale.Get(lang, locale.AdminsOnly))
+	}
+	settings := d.settings(c)
+	settings.Language = requested
+	err := d.saveSettings(c, settings)
+	if err != nil {
+		d.logger.Error(err)
+		return c.Reply(locale.Get(lang, locale.Failed))
+	}
+	lang = d.language(c)
+	return c.Reply(locale.Get(lang, locale.LanguageSet, lang))
 }
 
 func (d DistorterBot) handleMaintenance(c tb.Context) error {
@@ -315,124 +554,322 @@ func (d DistorterBot) handleMaintenance(c tb.Context) error {
 	return c.Reply(fmt.Sprintf("Maintenance on: %v", currentMode))
 }
 
-func main() {
-	lg, err := zap.NewProduction()
-	if err != nil {
-		log.Fatal(err)
+// runnerLimits reads the limits for ffmpeg and magick from the environment. Without any, the commands
+// still get killed after 10 minutes
+func runnerLimits() (tools.Limits, error) {
+	limits := tools.Limits{Timeout: 10 * time.Minute}
+	var err error
+	if timeout := os.Getenv("DISTORTIONER_COMMAND_TIMEOUT"); timeout != "" {
+		limits.Timeout, err = time.ParseDuration(timeout)
+		if err != nil {
+			return limits, err
+		}
 	}
-	defer lg.Sync() // flushes buffer, if any
-	logger := lg.Sugar()
-	db := stats.InitDB(logger)
-	defer db.Close()
+	for name, limit := range map[string]*int{
+		"DISTORTIONER_NICE":            &limits.Nice,
+		"DISTORTIONER_MAX_MEMORY_MB":   &limits.MaxMemoryMb,
+		"DISTORTIONER_MAX_CPU_SECONDS": &limits.MaxCPUSeconds,
+	} {
+		value := os.Getenv(name)
+		if value == "" {
+			continue
+		}
+		*limit, err = strconv.Atoi(value)
+		if err != nil {
+			return limits, fmt.Errorf("%s: %w", name, err)
+		}
+	}
+	return limits, nil
+}
 
-	adminID, err := strconv.ParseInt(os.Getenv("DISTORTIONER_ADMIN_ID"), 10, 64)
+// newWorkspace sets up the scratch directory from the environment and clears whatever the previous run left behind.
+// By default the files go to the name directory in the system temp directory without any quota
+func newWorkspace(logger *zap.SugaredLogger, name string) (*tools.Workspace, error) {
+	root := os.Getenv("DISTORTIONER_SCRATCH_DIR")
+	if root == "" {
+		root = filepath.Join(os.TempDir(), name)
+	}
+	var quotaMb int64
+	var err error
+	if quota := os.Getenv("DISTORTIONER_SCRATCH_QUOTA_MB"); quota != "" {
+		quotaMb, err = strconv.ParseInt(quota, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("DISTORTIONER_SCRATCH_QUOTA_MB: %w", err)
+		}
+	}
+	workspace, err := tools.NewWorkspace(root, quotaMb)
 	if err != nil {
-		adminID = -1
-		logger.Fatal("DISTORTIONER_ADMIN_ID variable is not set")
+		return nil, err
 	}
-
-	b, err := tb.NewBot(tb.Settings{
-		Token: os.Getenv("DISTORTIONER_BOT_TOKEN"),
-	})
+	removed, err := workspace.Sweep()
 	if err != nil {
-		logger.Fatal(err)
+		return nil, err
 	}
-	codec := os.Getenv("DISTORTIONER_CODEC")
-	if codec == "" {
-		codec = "libx264"
+	if removed > 0 {
+		logger.Infow("swept leftovers from the previous run", "root", root, "removed", removed)
 	}
-	priorityChatsStr := strings.Split(os.Getenv("DISTORTIONER_PRIORITY_CHATS"), ",")
-	priorityChats := make([]int64, len(priorityChatsStr))
-	for i, s := range priorityChatsStr {
-		if s == "" {
-			continue
-		}
-		priorityChats[i], err = strconv.ParseInt(s, 10, 64)
+	return workspace, nil
+}
+
+// newQuarantine sets up the storage for the media that failed to get distorted, expiring it in the background.
+// The failures are kept for a week by default
+func newQuarantine(logger *zap.SugaredLogger) (*tools.Quarantine, error) {
+	ttl := 7 * 24 * time.Hour
+	if value := os.Getenv("DISTORTIONER_FAILED_TTL"); value != "" {
+		var err error
+		ttl, err = time.ParseDuration(value)
 		if err != nil {
-			logger.Fatal(err)
+			return nil, fmt.Errorf("DISTORTIONER_FAILED_TTL: %w", err)
 		}
 	}
-
-	d := DistorterBot{
-		adminID:     adminID,
-		rl:          tools.NewRateLimiter(),
-		logger:      logger,
-		mu:          &sync.Mutex{},
-		graceWg:     &sync.WaitGroup{},
-		videoWorker: tools.NewVideoWorker(3, priorityChats),
-		codec:       codec,
-	}
-	b.Poller = tb.NewMiddlewarePoller(&tb.LongPoller{Timeout: 10 * time.Second}, func(update *tb.Update) bool {
-		if update.Message == nil {
-			return false
+	quarantine, err := tools.NewQuarantine(filepath.Join("data", "failed"), ttl)
+	if err != nil {
+		return nil, err
+	}
+	go func() {
+		for now := time.Now(); ; now = <-time.After(time.Hour) {
+			removed, err := quarantine.Expire(now)
+			if err != nil {
+				logger.Error(err)
+			} else if removed > 0 {
+				logger.Infow("expired failed media", "removed", removed)
+			}
 		}
-		m := update.Message
-		isCommand := len(m.Entities) > 0 && m.Entities[0].Type == tb.EntityCommand
-		text := update.Message.Text
-		if m.FromGroup() && !(isCommand && strings.HasSuffix(text, b.Me.Username)) {
-			return false
+	}()
+	return quarantine, nil
+}
+
+// newEncoders finds the video encoders that work, starting with DISTORTIONER_CODEC if it's set
+func newEncoders(ctx context.Context, logger *zap.SugaredLogger) (*distorters.Encoders, error) {
+	codec := os.Getenv("DISTORTIONER_CODEC")
+	encoders, err := distorters.ProbeEncoders(ctx, codec)
+	if err != nil {
+		return nil, err
+	}
+	for name, err := range encoders.Broken() {
+		logger.Warnw("encoder doesn't work", "encoder", name, "error", err)
+	}
+	if codec != "" && encoders.Preferred() != codec {
+		logger.Warnw("configured encoder is not available, falling back", "configured", codec, "using", encoders.Preferred())
+	}
+	logger.Infow("video encoders", "encoders", encoders.Stats())
+	return encoders, nil
+}
+
+// ApplyFilterMiddleware drops the updates that aren't worth handling at all. It goes before ApplySettingsMiddleware,
+// so that the chats only get their settings loaded for the updates that make it through, once
+func (d DistorterBot) ApplyFilterMiddleware(h tb.HandlerFunc) tb.HandlerFunc {
+	return func(c tb.Context) error {
+		if !d.filterUpdate(c) {
+			return nil
 		}
-		// throw away old messages
-		if time.Now().Sub(m.Time()) > 2*time.Hour {
+		return h(c)
+	}
+}
+
+// filterUpdate decides which updates are worth handling at all, saving the stats along the way
+func (d DistorterBot) filterUpdate(c tb.Context) bool {
+	update := c.Update()
+	if update.Callback != nil {
+		return true
+	}
+	if update.Message == nil {
+		return false
+	}
+	b := c.Bot()
+	m := update.Message
+	// replies to an album item should be able to find the rest of the album, so we keep them all around for a bit
+	d.albums.Remember(m)
+	isCommand := len(m.Entities) > 0 && m.Entities[0].Type == tb.EntityCommand
+	command := ""
+	if isCommand {
+		command = m.EntityText(m.Entities[0])
+	}
+	if m.FromGroup() && !(isCommand && strings.HasSuffix(command, b.Me.Username)) && !d.shouldAutoDistort(c) {
+		return false
+	}
+	// throw away old messages
+	if time.Now().Sub(m.Time()) > 2*time.Hour {
+		return false
+	}
+	if m.FromGroup() {
+		chat, err := b.ChatByID(m.Chat.ID)
+		if err != nil {
+			d.logger.Error("Failed to get chat", zap.Int64("chat_id", m.Chat.ID), zap.Error(err))
 			return false
 		}
-		if m.FromGroup() {
-			chat, err := b.ChatByID(m.Chat.ID)
-			if err != nil {
-				logger.Error("Failed to get chat", zap.Int64("chat_id", m.Chat.ID), zap.Error(err))
+		permissions := chat.Permissions
+		if permissions != nil {
+			if !permissions.CanSendMessages {
+				d.logger.Warn("can't send anything at all", zap.Int64("chat_id", m.Chat.ID))
 				return false
 			}
-			permissions := chat.Permissions
-			if permissions != nil {
-				if !permissions.CanSendMessages {
-					logger.Warn("can't send anything at all", zap.Int64("chat_id", m.Chat.ID))
-					return false
-				} else if (!permissions.CanSendMedia && tools.IsMedia(m.ReplyTo)) || (!permissions.CanSendOther && tools.IsNonMediaMedia(m.ReplyTo)) {
-					b.Reply(m, NotEnoughRights)
-					return false
+			target := m.ReplyTo
+			if !isCommand {
+				target = m // auto-distortion
+			}
+			if (!permissions.CanSendMedia && tools.IsMedia(target)) || (!permissions.CanSendOther && tools.IsNonMediaMedia(target)) {
+				if isCommand {
+					b.Reply(m, locale.Get(d.languageOf(m.Chat.ID, m.Sender), locale.NotEnoughRights))
 				}
+				return false
 			}
 		}
-		if text != "/daily" && text != "/weekly" && text != "/monthly" && text != "/queue" {
-			go db.SaveStat(update.Message, isCommand)
-		}
-		return true
-	})
-
-	if err != nil {
-		logger.Fatal(err)
-		return
 	}
+	command, _, _ = strings.Cut(command, "@")
+	if command != "/daily" && command != "/weekly" && command != "/monthly" && command != "/queue" {
+		go d.db.SaveStat(update.Message, isCommand)
+	}
+	return true
+}
 
-	b.Use(middleware.Recover())
+// registerHandlers routes the commands, the buttons and the media to their handlers
+func (d DistorterBot) registerHandlers(b *tb.Bot) {
+	b.Use(middleware.Recover(), d.ApplyFilterMiddleware, d.ApplySettingsMiddleware)
 	b.Handle("/start", func(c tb.Context) error {
-		return c.Reply("Send me a picture, a sticker, a voice message, a video[note] or a GIF and I'll distort it")
+		return c.Reply(locale.Get(d.language(c), locale.Start))
 	})
 
 	b.Handle("/daily", d.ApplyShutdownMiddleware(func(c tb.Context) error {
-		return d.handleStatRequest(c, db, stats.Daily)
+		return d.handleStatRequest(c, d.db, stats.Daily)
 	}))
 
 	b.Handle("/weekly", d.ApplyShutdownMiddleware(func(c tb.Context) error {
-		return d.handleStatRequest(c, db, stats.Weekly)
+		return d.handleStatRequest(c, d.db, stats.Weekly)
 	}))
 
 	b.Handle("/monthly", d.ApplyShutdownMiddleware(func(c tb.Context) error {
-		return d.handleStatRequest(c, db, stats.Monthly)
+		return d.handleStatRequest(c, d.db, stats.Monthly)
 	}))
 
 	b.Handle("/queue", d.handleQueueStats)
 
 	b.Handle("/maintenance", d.handleMaintenance)
+	b.Handle("/priority", d.handlePriority)
+
+	b.Handle("/failed", d.handleFailed)
+	b.Handle("/retry", d.ApplyShutdownMiddleware(d.handleRetry))
+
+	b.Handle("/lang", d.ApplyShutdownMiddleware(d.handleLanguage))
+	b.Handle("/settings", d.ApplyShutdownMiddleware(d.handleSettings))
+	b.Handle(&tb.Btn{Unique: settingsUnique}, d.ApplyShutdownMiddleware(d.handleSettingsCallback))
+	b.Handle(&tb.Btn{Unique: resultUnique}, d.ApplyShutdownMiddleware(d.handleResultCallback))
 
 	b.Handle("/distort", d.ApplyShutdownMiddleware(d.handleReplyDistortion))
-	b.Handle(tb.OnAnimation, d.ApplyShutdownMiddleware(d.handleAnimationDistortion))
-	b.Handle(tb.OnSticker, d.ApplyShutdownMiddleware(d.handleStickerDistortion))
-	b.Handle(tb.OnPhoto, d.ApplyShutdownMiddleware(d.handlePhotoDistortion))
-	b.Handle(tb.OnVoice, d.ApplyShutdownMiddleware(d.handleVoiceDistortion))
-	b.Handle(tb.OnVideo, d.ApplyShutdownMiddleware(d.handleVideoDistortion))
-	b.Handle(tb.OnVideoNote, d.ApplyShutdownMiddleware(d.handleVideoNoteDistortion))
+	b.Handle(tb.OnAnimation, d.ApplyShutdownMiddleware(d.ApplyAutoDistortMiddleware(d.handleAnimationDistortion)))
+	b.Handle(tb.OnSticker, d.ApplyShutdownMiddleware(d.ApplyAutoDistortMiddleware(d.handleStickerDistortion)))
+	b.Handle(tb.OnPhoto, d.ApplyShutdownMiddleware(d.ApplyAutoDistortMiddleware(d.ApplyAlbumMiddleware(d.handlePhotoDistortion))))
+	b.Handle(tb.OnVoice, d.ApplyShutdownMiddleware(d.ApplyAutoDistortMiddleware(d.handleVoiceDistortion)))
+	b.Handle(tb.OnAudio, d.ApplyShutdownMiddleware(d.handleAudioDistortion))
+	b.Handle(tb.OnDocument, d.ApplyShutdownMiddleware(d.handleDocumentDistortion))
+	b.Handle(tb.OnVideo, d.ApplyShutdownMiddleware(d.ApplyAutoDistortMiddleware(d.ApplyAlbumMiddleware(d.handleVideoDistortion))))
+	b.Handle(tb.OnVideoNote, d.ApplyShutdownMiddleware(d.ApplyAutoDistortMiddleware(d.handleVideoNoteDistortion)))
 	b.Handle(tb.OnText, d.ApplyShutdownMiddleware(d.handleTextDistortion))
+}
+
+func main() {
+	lg, err := zap.NewProduction()
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer lg.Sync() // flushes buffer, if any
+	logger := lg.Sugar()
+	if len(os.Args) > 1 && os.Args[1] == "worker" {
+		runWorker(logger)
+		return
+	}
+	db := stats.InitDB(logger)
+	defer db.Close()
+
+	adminID, err := strconv.ParseInt(os.Getenv("DISTORTIONER_ADMIN_ID"), 10, 64)
+	if err != nil {
+		adminID = -1
+		logger.Fatal("DISTORTIONER_ADMIN_ID variable is not set")
+	}
+
+	botAPI, err := tools.BotAPIFromEnv()
+	if err != nil {
+		logger.Fatal(err)
+	}
+	b, err := tb.NewBot(botAPI.Settings(tb.Settings{
+		Token: os.Getenv("DISTORTIONER_BOT_TOKEN"),
+	}))
+	if err != nil {
+		logger.Fatal(err)
+	}
+	err = startPruning(db, logger)
+	if err != nil {
+		logger.Fatal(err)
+	}
+	limits, err := runnerLimits()
+	if err != nil {
+		logger.Fatal(err)
+	}
+	distorters.SetRunner(tools.NewExecRunner(limits))
+	workspace, err := newWorkspace(logger, "distortioner")
+	if err != nil {
+		logger.Fatal(err)
+	}
+	quarantine, err := newQuarantine(logger)
+	if err != nil {
+		logger.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	encoders, err := newEncoders(ctx, logger)
+	if err != nil {
+		logger.Fatal(err)
+	}
+	priorityChats := make(map[int64]any)
+	for _, s := range strings.Split(os.Getenv("DISTORTIONER_PRIORITY_CHATS"), ",") {
+		if s == "" {
+			continue
+		}
+		chatID, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			logger.Fatal(err)
+		}
+		priorityChats[chatID] = nil
+	}
+	scheduler, err := queue.NewScheduler(os.Getenv("DISTORTIONER_SCHEDULER"))
+	if err != nil {
+		logger.Fatal(err)
+	}
+	workerAddr := os.Getenv("DISTORTIONER_WORKER_LISTEN")
+	workerCount := 3
+	if workerAddr != "" {
+		workerCount = 0 // nothing is run until the workers show up
+	}
+	videoWorker := tools.NewVideoWorker(workerCount, scheduler)
+	var processor videoProcessor = localProcessor{encoders: encoders}
+	if workerAddr != "" {
+		processor, err = startCoordinator(ctx, workerAddr, videoWorker, logger)
+		if err != nil {
+			logger.Fatal(err)
+		}
+	}
+
+	d := DistorterBot{
+		adminID:       adminID,
+		db:            db,
+		rl:            tools.NewRateLimiter(),
+		logger:        logger,
+		mu:            &sync.Mutex{},
+		graceWg:       &sync.WaitGroup{},
+		videoWorker:   videoWorker,
+		albums:        tools.NewAlbumCollector(time.Second, 10*time.Minute),
+		encoders:      encoders,
+		processor:     processor,
+		workspace:     workspace,
+		quarantine:    quarantine,
+		botAPI:        botAPI,
+		priorityChats: priorityChats,
+		ctx:           ctx,
+	}
+	b.Poller = &tb.LongPoller{Timeout: 10 * time.Second}
+
+	d.registerHandlers(b)
 
 	go func() {
 		signChan := make(chan os.Signal, 1)
@@ -442,6 +879,7 @@ func main() {
 		logger.Info("shutdown: ", zap.String("signal", sig.String()))
 		d.videoWorker.Shutdown()
 		d.graceWg.Wait()
+		cancel()
 		b.Stop()
 	}()
 
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+
+	tb "gopkg.in/telebot.v3"
+
+	"github.com/graynk/distortioner/distorters"
+	"github.com/graynk/distortioner/locale"
+	"github.com/graynk/distortioner/tools"
+)
+
+// mightBeMedia filters out documents that are obviously not something we can distort, so that we don't download them
+func mightBeMedia(mime string) bool {
+	return mime == "" || mime == "application/octet-stream" ||
+		strings.HasPrefix(mime, "image/") || strings.HasPrefix(mime, "video/") || strings.HasPrefix(mime, "audio/")
+}
+
+// replaceExt swaps the extension of the original filename, making one up if there was no name at all
+func replaceExt(name, ext string) string {
+	if name == "" {
+		name = "distorted"
+	}
+	return strings.TrimSuffix(name, filepath.Ext(name)) + ext
+}
+
+func (d DistorterBot) handleDocumentDistortion(c tb.Context) error {
+	m := c.Message()
+	document := m.Document
+	if !mightBeMedia(document.MIME) {
+		return nil
+	}
+	lang := d.language(c)
+	if document.FileSize > d.maxFileSize(c) {
+		return d.notify(c, locale.Get(lang, locale.TooBig))
+	} else if d.rateLimited(c, lang) {
+		return nil
+	}
+	scratch := d.newScratch(c)
+	if scratch == nil {
+		return nil
+	}
+	filename, err := tools.JustGetTheFile(c.Bot(), d.botAPI, m, lang, scratch)
+	if err != nil {
+		scratch.Close()
+		d.logger.Error(err)
+		return err
+	}
+	return d.distortDocumentFile(c, scratch, filename, document.MIME, document.FileName)
+}
+
+// distortDocumentFile figures out what the downloaded file is and distorts it accordingly, sending it back as a file.
+// Takes care of closing the scratch directory, whether the job gets queued or not
+func (d DistorterBot) distortDocumentFile(c tb.Context, scratch *tools.Scratch, filename, mime, name string) error {
+	kind := distorters.SniffMedia(d.ctx, filename, mime)
+	switch kind {
+	case distorters.KindImage:
+		defer scratch.Close()
+		return d.distortImageDocument(c, filename, name)
+	case distorters.KindAnimation, distorters.KindVideo:
+		// the job takes care of the scratch directory from now on
+		return d.submitVideoDocument(c, scratch, filename, name, kind)
+	case distorters.KindAudio:
+		defer scratch.Close()
+		return d.distortAudioFile(c, filename, "", "", name)
+	}
+	scratch.Close()
+	return d.notify(c, locale.Get(d.language(c), locale.NotSupported))
+}
+
+func (d DistorterBot) distortImageDocument(c tb.Context, filename, name string) error {
+	options := d.options(c)
+	options.MaxSide = distorters.DocumentMaxSide
+	err := distorters.DistortImagePasses(d.ctx, filename, options)
+	if err != nil {
+		d.keepFailed(c, filename, err)
+		d.notify(c, locale.Get(d.language(c), locale.Failed))
+		return err
+	}
+	if name == "" {
+		name = "distorted.png"
+	}
+	distorted := &tb.Document{File: tb.FromDisk(filename), FileName: name}
+	var entities tb.Entities
+	distorted.Caption, entities = d.caption(c)
+	return d.SendMessageWithRepeater(c, distorted, d.resultButtons(c, entities)...)
+}
+
+// restoreContainer converts the distorted mp4 back into the format of the original file, if we can.
+// Returns the file to send and its name
+func (d DistorterBot) restoreContainer(output, name string, kind distorters.MediaKind) (string, string) {
+	ext := strings.ToLower(filepath.Ext(name))
+	if ext == "" || ext == ".mp4" {
+		return output, replaceExt(name, ".mp4")
+	}
+	converted := output + ext
+	var err error
+	if ext == ".gif" && kind == distorters.KindAnimation {
+		err = distorters.ConvertToGif(d.ctx, output, converted)
+	} else {
+		err = distorters.ConvertContainer(d.ctx, output, converted)
+	}
+	if err != nil {
+		os.Remove(converted)
+		d.logger.Warnw("failed to convert the document back, sending mp4 instead", "error", err)
+		return output, replaceExt(name, ".mp4")
+	}
+	return converted, name
+}
+
+func (d DistorterBot) submitVideoDocument(c tb.Context, scratch *tools.Scratch, filename, name string, kind distorters.MediaKind) error {
+	lang := d.language(c)
+	err := d.videoWorker.SubmitTier(c.Chat().ID, d.fileCost(c, filename), d.tier(c).Queue, func() {
+		defer scratch.Close()
+		progressMessage, _ := d.startProgress(c, locale.Extracting)
+		var output string
+		var err error
+		if kind == distorters.KindAnimation {
+			progressMessage, output, err = d.DistortAnimationFile(c, progressMessage, filename)
+		} else {
+			output, progressMessage, err = d.DistortVideoFile(c, progressMessage, filename)
+		}
+		failed := err != nil
+		if failed {
+			d.DoneMessageWithRepeater(c, progressMessage, failed)
+			d.keepFailed(c, filename, err)
+			d.logger.Error(err)
+			return
+		}
+
+		converted, convertedName := d.restoreContainer(output, name, kind)
+		distorted := &tb.Document{File: tb.FromDisk(converted), FileName: convertedName}
+		var entities tb.Entities
+		distorted.Caption, entities = d.caption(c)
+		err = d.SendMessageWithRepeater(c, distorted, d.resultButtons(c, entities)...)
+		d.DoneMessageWithRepeater(c, progressMessage, failed)
+		if err != nil {
+			d.logger.Error(err)
+		}
+	})
+	if err != nil {
+		scratch.Close()
+		d.notify(c, queueErrorMessage(lang, err))
+		return nil
+	}
+	if d.videoWorker.IsBusy() {
+		d.notify(c, locale.Get(lang, locale.Queued))
+	}
+	return nil
+}
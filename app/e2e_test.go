@@ -0,0 +1,564 @@
+package main
+
+import (
+	"context"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	tb "gopkg.in/telebot.v3"
+
+	"github.com/graynk/distortioner/cluster"
+	"github.com/graynk/distortioner/distorters"
+	"github.com/graynk/distortioner/locale"
+	"github.com/graynk/distortioner/queue"
+	"github.com/graynk/distortioner/stats"
+	"github.com/graynk/distortioner/tools"
+)
+
+const (
+	userID  = 42
+	groupID = -100500
+)
+
+// what ffprobe would say about a short video, served by the fake Bot API as the video itself
+const videoProbe = `{"format": {"format_name": "mov,mp4,m4a,3gp,3g2,mj2", "duration": "2.000000"},
+	"streams": [{"index": 0, "codec_type": "video", "codec_name": "h264", "width": 320, "height": 240,
+		"pix_fmt": "yuv420p", "avg_frame_rate": "10/1", "r_frame_rate": "10/1", "duration": "2.000000"}]}`
+
+// startTestBot runs the whole bot against the fake Bot API, with the fake binaries and a fresh database
+func startTestBot(t *testing.T, api *fakeBotAPI) DistorterBot {
+	d, _ := newTestBot(t, api, false)
+	return d
+}
+
+// startRemoteTestBot is startTestBot that leaves the videos to the workers, see startWorkerProcess.
+// Returns the URL the workers go to
+func startRemoteTestBot(t *testing.T, api *fakeBotAPI) (DistorterBot, string) {
+	return newTestBot(t, api, true)
+}
+
+func newTestBot(t *testing.T, api *fakeBotAPI, remote bool) (DistorterBot, string) {
+	installFakeBinaries(t)
+	wd, err := os.Getwd()
+	require.NoError(t, err)
+	dir := t.TempDir()
+	require.NoError(t, os.Chdir(dir)) // the database and the quarantine live in ./data
+	t.Cleanup(func() {
+		os.Chdir(wd)
+	})
+
+	logger := zap.NewNop().Sugar()
+	db := stats.InitDB(logger)
+	distorters.SetRunner(tools.NewExecRunner(tools.Limits{}))
+	ctx, cancel := context.WithCancel(context.Background())
+	encoders, err := distorters.ProbeEncoders(ctx, "")
+	require.NoError(t, err)
+	workspace, err := tools.NewWorkspace(filepath.Join(dir, "scratch"), 0)
+	require.NoError(t, err)
+	quarantine, err := tools.NewQuarantine(filepath.Join("data", "failed"), 0)
+	require.NoError(t, err)
+	botAPI := tools.BotAPI{URL: api.URL()}
+	// synchronous, so that the updates are handled one by one, in order
+	b, err := tb.NewBot(botAPI.Settings(tb.Settings{Token: testToken, Synchronous: true}))
+	require.NoError(t, err)
+	workerCount := 1
+	if remote {
+		workerCount = 0
+	}
+	videoWorker := tools.NewVideoWorker(workerCount, queue.NewHonestScheduler())
+	var processor videoProcessor = localProcessor{encoders: encoders}
+	workersURL := ""
+	if remote {
+		coordinator := cluster.NewCoordinator(testWorkerToken, time.Second)
+		coordinator.OnSlots(videoWorker.Resize)
+		coordinator.StartExpiring(ctx)
+		server := httptest.NewServer(coordinator)
+		t.Cleanup(server.Close)
+		processor = remoteProcessor{coordinator: coordinator}
+		workersURL = server.URL
+	}
+
+	d := DistorterBot{
+		adminID:     userID,
+		db:          db,
+		rl:          tools.NewRateLimiter(),
+		logger:      logger,
+		mu:          &sync.Mutex{},
+		graceWg:     &sync.WaitGroup{},
+		videoWorker: videoWorker,
+		albums:      tools.NewAlbumCollector(time.Second, time.Minute),
+		encoders:    encoders,
+		processor:   processor,
+		workspace:   workspace,
+		quarantine:  quarantine,
+		botAPI:      botAPI,
+		ctx:         ctx,
+	}
+	d.registerHandlers(b)
+	go b.Start()
+	t.Cleanup(func() {
+		b.Stop()
+		d.videoWorker.Shutdown()
+		d.graceWg.Wait()
+		cancel()
+		db.Close()
+	})
+	return d, workersURL
+}
+
+func privateMessage(id int) *tb.Message {
+	return &tb.Message{
+		ID:     id,
+		Chat:   &tb.Chat{ID: userID, Type: tb.ChatPrivate},
+		Sender: &tb.User{ID: userID, FirstName: "Tester", LanguageCode: "en"},
+	}
+}
+
+func photoMessage(api *fakeBotAPI, id int) *tb.Message {
+	api.addFile("cat", []byte("a photo of a cat"))
+	m := privateMessage(id)
+	m.Photo = &tb.Photo{File: tb.File{FileID: "cat", UniqueID: "cat"}, Width: 512, Height: 512}
+	return m
+}
+
+func textMessage(id int, text string) *tb.Message {
+	m := privateMessage(id)
+	m.Text = text
+	return m
+}
+
+func TestE2EPhoto(t *testing.T) {
+	api := newFakeBotAPI(t)
+	startTestBot(t, api)
+	api.push(photoMessage(api, 10))
+
+	sent := api.waitFor(t, "sendPhoto", 1)[0]
+	assert.Equal(t, "distorted by magick", string(sent.Params["photo"]))
+	assert.Equal(t, "10", sent.Params["reply_to_message_id"])
+	assert.Contains(t, sent.Params["reply_markup"], resultUnique, "the result should come with the buttons")
+	assert.Len(t, api.callsTo("getFile"), 1)
+}
+
+func TestE2EVideo(t *testing.T) {
+	api := newFakeBotAPI(t)
+	startTestBot(t, api)
+	api.push(videoMessage(api, 11))
+
+	sent := api.waitFor(t, "sendVideo", 1)[0]
+	assert.Equal(t, "distorted by ffmpeg", string(sent.Params["video"]))
+	progress := api.callsTo("sendMessage")
+	require.Len(t, progress, 1)
+	assert.Equal(t, locale.Get("en", locale.Downloading), progress[0].Params["text"])
+	assert.NotEmpty(t, api.callsTo("editMessageText"), "the progress should be reported")
+	api.waitFor(t, "deleteMessage", 1) // and cleaned up after
+}
+
+func videoMessage(api *fakeBotAPI, id int) *tb.Message {
+	api.addFile("clip", []byte(videoProbe))
+	m := privateMessage(id)
+	m.Video = &tb.Video{File: tb.File{FileID: "clip", UniqueID: "clip"}, Width: 320, Height: 240, Duration: 2}
+	return m
+}
+
+func TestE2EText(t *testing.T) {
+	api := newFakeBotAPI(t)
+	startTestBot(t, api)
+	api.push(textMessage(12, "hello there"))
+
+	sent := api.waitFor(t, "sendMessage", 1)[0]
+	assert.NotEmpty(t, sent.Params["text"])
+	assert.Equal(t, "12", sent.Params["reply_to_message_id"])
+}
+
+func TestE2ERetryAfter(t *testing.T) {
+	api := newFakeBotAPI(t)
+	startTestBot(t, api)
+	api.failNext("sendPhoto", apiError{Code: 429, Description: "Too Many Requests: retry after 1", RetryAfter: 1})
+	started := time.Now()
+	api.push(photoMessage(api, 13))
+
+	api.waitFor(t, "sendPhoto", 2)
+	assert.GreaterOrEqual(t, time.Since(started), time.Second, "should've waited as told")
+}
+
+func TestE2EBlockedByUser(t *testing.T) {
+	api := newFakeBotAPI(t)
+	startTestBot(t, api)
+	api.failNext("sendPhoto", apiError{Code: 403, Description: "Forbidden: bot was blocked by the user"})
+	api.push(photoMessage(api, 14))
+	api.push(textMessage(15, "still there?"))
+
+	// the updates are handled in order, so the photo is done with by the time the text is answered
+	api.waitFor(t, "sendMessage", 1)
+	assert.Len(t, api.callsTo("sendPhoto"), 1, "no point in sending to someone who blocked the bot")
+}
+
+func TestE2EReplyNotFound(t *testing.T) {
+	api := newFakeBotAPI(t)
+	startTestBot(t, api)
+	api.failNext("sendPhoto", apiError{Code: 400, Description: "Bad Request: message to be replied not found"})
+	api.push(photoMessage(api, 16))
+
+	calls := api.waitFor(t, "sendPhoto", 2)
+	assert.Equal(t, "16", calls[0].Params["reply_to_message_id"])
+	assert.Empty(t, calls[1].Params["reply_to_message_id"], "should've sent it without replying")
+}
+
+func TestE2EFailedMediaIsQuarantined(t *testing.T) {
+	api := newFakeBotAPI(t)
+	d := startTestBot(t, api)
+	t.Setenv(failingBinaryEnv, "magick")
+	api.push(photoMessage(api, 17))
+
+	sent := api.waitFor(t, "sendMessage", 1)[0]
+	assert.Equal(t, locale.Get("en", locale.Failed), sent.Params["text"])
+	failures, err := d.quarantine.List()
+	require.NoError(t, err)
+	require.Len(t, failures, 1)
+	assert.Equal(t, "photo", failures[0].Kind)
+	assert.Equal(t, string(distorters.StageDistort), failures[0].Stage)
+	assert.Contains(t, failures[0].Stderr, "Invalid data")
+}
+
+// groupReply is /distort sent in a group in reply to a photo
+func groupReply(api *fakeBotAPI, id int) *tb.Message {
+	group := &tb.Chat{ID: groupID, Type: tb.ChatSuperGroup}
+	photo := photoMessage(api, id-1)
+	photo.Chat = group
+	command := "/distort@" + testBotUsername
+	return &tb.Message{
+		ID:       id,
+		Chat:     group,
+		Sender:   &tb.User{ID: userID, FirstName: "Tester", LanguageCode: "en"},
+		Text:     command,
+		Entities: tb.Entities{{Type: tb.EntityCommand, Offset: 0, Length: len(command)}},
+		ReplyTo:  photo,
+	}
+}
+
+func TestE2EGroupCommand(t *testing.T) {
+	api := newFakeBotAPI(t)
+	startTestBot(t, api)
+	api.addChat(tb.Chat{ID: groupID, Type: tb.ChatSuperGroup, Permissions: &tb.Rights{
+		CanSendMessages: true,
+		CanSendMedia:    true,
+		CanSendOther:    true,
+	}})
+	// some random chatter is not for the bot
+	other := textMessage(20, "hello everyone")
+	other.Chat = &tb.Chat{ID: groupID, Type: tb.ChatSuperGroup}
+	api.push(other)
+	api.push(groupReply(api, 22))
+
+	sent := api.waitFor(t, "sendPhoto", 1)[0]
+	assert.Equal(t, "21", sent.Params["reply_to_message_id"], "the result goes in reply to the photo")
+	assert.Empty(t, api.callsTo("sendMessage"), "nothing should be said about the chatter")
+	assert.Len(t, api.callsTo("getChat"), 1)
+}
+
+func TestE2EGroupWithoutRights(t *testing.T) {
+	api := newFakeBotAPI(t)
+	startTestBot(t, api)
+	api.addChat(tb.Chat{ID: groupID, Type: tb.ChatSuperGroup, Permissions: &tb.Rights{CanSendMessages: true}})
+	api.push(groupReply(api, 31))
+
+	sent := api.waitFor(t, "sendMessage", 1)[0]
+	assert.Equal(t, locale.Get("en", locale.NotEnoughRights), sent.Params["text"])
+	assert.Empty(t, api.callsTo("sendPhoto"))
+}
+
+func TestE2EPriority(t *testing.T) {
+	api := newFakeBotAPI(t)
+	startTestBot(t, api)
+
+	api.push(textMessage(30, "/priority add 42 supporter"))
+	api.waitForText(t, "42 is supporter now")
+	// regular chats get three videos per five minutes, supporters twice as many
+	for id := 31; id <= 34; id++ {
+		api.push(videoMessage(api, id))
+	}
+	api.waitFor(t, "sendVideo", 4)
+
+	api.push(textMessage(35, "/priority list"))
+	api.waitForText(t, "42: supporter since")
+
+	api.push(textMessage(36, "/priority remove 42"))
+	api.waitForText(t, "42 is regular now")
+	api.push(videoMessage(api, 37))
+	api.waitForText(t, "Please, not so often")
+	assert.Len(t, api.callsTo("sendVideo"), 4)
+}
+
+func TestE2EPriorityIsForAdminsOnly(t *testing.T) {
+	api := newFakeBotAPI(t)
+	d := startTestBot(t, api)
+	m := textMessage(38, "/priority add 7 priority")
+	m.Sender.ID = 7
+	api.push(m)
+	api.push(textMessage(39, "/priority"))
+
+	api.waitForText(t, "Nobody is in any tier yet")
+	assert.Equal(t, regularTier, d.tierOf(7).Name)
+}
+
+func TestE2EVideoOnWorkers(t *testing.T) {
+	api := newFakeBotAPI(t)
+	_, url := startRemoteTestBot(t, api)
+	api.push(videoMessage(api, 40))
+	api.push(textMessage(39, "anybody?"))
+	// the updates are handled in order, so the video is queued by the time the text is answered
+	api.waitFor(t, "sendMessage", 1)
+	time.Sleep(100 * time.Millisecond)
+	assert.Empty(t, api.callsTo("sendVideo"), "there's nobody to distort it yet")
+	assert.Len(t, api.callsTo("sendMessage"), 1, "a single video waiting for the workers is not called queued")
+
+	startWorkerProcess(t, url, "")
+	startWorkerProcess(t, url, "")
+	api.push(videoMessage(api, 41))
+	api.push(videoMessage(api, 42))
+	for _, sent := range api.waitFor(t, "sendVideo", 3) {
+		assert.Equal(t, "distorted by ffmpeg", string(sent.Params["video"]))
+	}
+	assert.NotEmpty(t, api.callsTo("editMessageText"), "the workers should report the progress")
+}
+
+func TestE2EFailedOnWorker(t *testing.T) {
+	api := newFakeBotAPI(t)
+	d, url := startRemoteTestBot(t, api)
+	startWorkerProcess(t, url, "magick")
+	api.push(videoMessage(api, 43))
+
+	var failures []tools.Failure
+	require.Eventually(t, func() bool {
+		var err error
+		failures, err = d.quarantine.List()
+		return err == nil && len(failures) == 1
+	}, waitTimeout, 10*time.Millisecond, "the failure should be quarantined")
+	assert.Equal(t, "video", failures[0].Kind)
+	assert.Equal(t, string(distorters.StageDistort), failures[0].Stage, "the stage should come back from the worker")
+	// the failure is quarantined before the progress message says so
+	require.Eventually(t, func() bool {
+		edits := api.callsTo("editMessageText")
+		return len(edits) > 0 && edits[len(edits)-1].Params["text"] == locale.Get("en", locale.Failed)
+	}, waitTimeout, 10*time.Millisecond, "the progress message should say it failed")
+}
+
+func TestLeaseTimeout(t *testing.T) {
+	t.Setenv("DISTORTIONER_WORKER_LEASE", "")
+	timeout, err := leaseTimeout()
+	require.NoError(t, err)
+	assert.Equal(t, cluster.DefaultLeaseTimeout, timeout)
+	t.Setenv("DISTORTIONER_WORKER_LEASE", "90s")
+	timeout, err = leaseTimeout()
+	require.NoError(t, err)
+	assert.Equal(t, 90*time.Second, timeout)
+	for _, value := range []string{"0", "0s", "-1m", "soon"} {
+		t.Setenv("DISTORTIONER_WORKER_LEASE", value)
+		_, err = leaseTimeout()
+		assert.Error(t, err, value)
+	}
+}
+
+func TestE2EFailureIsInUsersLanguage(t *testing.T) {
+	api := newFakeBotAPI(t)
+	startTestBot(t, api)
+	t.Setenv(failingBinaryEnv, "magick")
+	m := videoMessage(api, 44)
+	m.Sender.LanguageCode = "ru"
+	api.push(m)
+
+	require.Eventually(t, func() bool {
+		edits := api.callsTo("editMessageText")
+		return len(edits) > 0 && edits[len(edits)-1].Params["text"] == locale.Get("ru", locale.Failed)
+	}, waitTimeout, 10*time.Millisecond, "the progress message should say it failed in Russian")
+}
+
+func TestE2EFailedVideoIsReportedOnce(t *testing.T) {
+	api := newFakeBotAPI(t)
+	d := startTestBot(t, api)
+	t.Setenv(failingBinaryEnv, "magick")
+	api.push(videoMessage(api, 45))
+
+	require.Eventually(t, func() bool {
+		failures, err := d.quarantine.List()
+		return err == nil && len(failures) == 1
+	}, waitTimeout, 10*time.Millisecond, "the failure should be quarantined")
+	failed := 0
+	for _, edit := range api.callsTo("editMessageText") {
+		if edit.Params["text"] == locale.Get("en", locale.Failed) {
+			failed++
+		}
+	}
+	assert.Equal(t, 1, failed, "the progress message should only be edited once")
+}
+
+// distortReply is /distort with the arguments, sent in reply to the message
+func distortReply(id int, args string, original *tb.Message) *tb.Message {
+	m := textMessage(id, "/distort "+args)
+	m.Entities = tb.Entities{{Type: tb.EntityCommand, Offset: 0, Length: len("/distort")}}
+	m.ReplyTo = original
+	return m
+}
+
+func TestE2ERangePastTheEnd(t *testing.T) {
+	api := newFakeBotAPI(t)
+	startTestBot(t, api)
+	api.push(distortReply(47, "0:10-", videoMessage(api, 46)))
+
+	badRange := locale.Get("en", locale.BadRange, "0:02")
+	require.Eventually(t, func() bool {
+		edits := api.callsTo("editMessageText")
+		return len(edits) > 0 && edits[len(edits)-1].Params["text"] == badRange
+	}, waitTimeout, 10*time.Millisecond, "the progress message should say what's wrong")
+	api.push(textMessage(48, "still there?"))
+	api.waitFor(t, "sendMessage", 2) // the progress message and the answer to the text
+	assert.Empty(t, api.callsTo("deleteMessage"), "the progress message should stay")
+	assert.Empty(t, api.callsTo("sendVideo"))
+}
+
+// what ffprobe would say about a short song, served by the fake Bot API as the song itself
+const audioProbe = `{"format": {"format_name": "mp3", "duration": "2.000000"},
+	"streams": [{"index": 0, "codec_type": "audio", "codec_name": "mp3", "duration": "2.000000"}]}`
+
+func stickerMessage(api *fakeBotAPI, id int) *tb.Message {
+	api.addFile("sticker", []byte("a sticker"))
+	m := privateMessage(id)
+	m.Sticker = &tb.Sticker{File: tb.File{FileID: "sticker", UniqueID: "sticker"}, Type: tb.StickerRegular, Width: 512, Height: 512}
+	return m
+}
+
+func animationMessage(api *fakeBotAPI, id int) *tb.Message {
+	api.addFile("gif", []byte(videoProbe))
+	m := privateMessage(id)
+	m.Animation = &tb.Animation{File: tb.File{FileID: "gif", UniqueID: "gif"}, Width: 320, Height: 240, Duration: 2, MIME: "video/mp4"}
+	return m
+}
+
+func videoNoteMessage(api *fakeBotAPI, id int) *tb.Message {
+	api.addFile("note", []byte(videoProbe))
+	m := privateMessage(id)
+	m.VideoNote = &tb.VideoNote{File: tb.File{FileID: "note", UniqueID: "note"}, Length: 240, Duration: 2}
+	return m
+}
+
+func voiceMessage(api *fakeBotAPI, id int) *tb.Message {
+	api.addFile("voice", []byte(audioProbe))
+	m := privateMessage(id)
+	m.Voice = &tb.Voice{File: tb.File{FileID: "voice", UniqueID: "voice"}, Duration: 2, MIME: "audio/ogg"}
+	return m
+}
+
+func audioMessage(api *fakeBotAPI, id int) *tb.Message {
+	api.addFile("song", []byte(audioProbe))
+	m := privateMessage(id)
+	m.Audio = &tb.Audio{File: tb.File{FileID: "song", UniqueID: "song"}, Duration: 2, MIME: "audio/mpeg", FileName: "song.mp3"}
+	return m
+}
+
+func documentMessage(api *fakeBotAPI, id int) *tb.Message {
+	api.addFile("document", []byte(videoProbe))
+	m := privateMessage(id)
+	m.Document = &tb.Document{File: tb.File{FileID: "document", UniqueID: "document"}, MIME: "video/x-matroska", FileName: "clip.mkv"}
+	return m
+}
+
+const imageProbe = `{"format": {"format_name": "webp_pipe"},
+	"streams": [{"index": 0, "codec_type": "video", "codec_name": "webp", "width": 512, "height": 512}]}`
+
+func imageDocumentMessage(api *fakeBotAPI, id int) *tb.Message {
+	api.addFile("picture", []byte(imageProbe))
+	m := privateMessage(id)
+	m.Document = &tb.Document{File: tb.File{FileID: "picture", UniqueID: "picture"}, MIME: "image/webp", FileName: "cat.webp"}
+	return m
+}
+
+// the retried media goes through the same pipeline as the original message of that kind, so it comes out the same way
+func TestE2ERetry(t *testing.T) {
+	for _, test := range []struct {
+		name    string
+		kind    string
+		message func(api *fakeBotAPI, id int) *tb.Message
+		failing string // the fake binary that fails the first time around
+		method  string
+	}{
+		{"photo", "photo", photoMessage, "magick", "sendPhoto"},
+		{"sticker", "sticker", stickerMessage, "magick", "sendSticker"},
+		{"animation", "animation", animationMessage, "magick", "sendAnimation"},
+		{"video", "video", videoMessage, "magick", "sendVideo"},
+		{"videonote", "videonote", videoNoteMessage, "magick", "sendVideoNote"},
+		{"voice", "voice", voiceMessage, "ffmpeg", "sendVoice"},
+		{"audio", "audio", audioMessage, "ffmpeg", "sendAudio"},
+		{"video document", "document", documentMessage, "magick", "sendDocument"},
+		{"image document", "document", imageDocumentMessage, "magick", "sendDocument"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			api := newFakeBotAPI(t)
+			d := startTestBot(t, api)
+			t.Setenv(failingBinaryEnv, test.failing)
+			api.push(test.message(api, 60))
+
+			var failures []tools.Failure
+			require.Eventually(t, func() bool {
+				var err error
+				failures, err = d.quarantine.List()
+				return err == nil && len(failures) == 1
+			}, waitTimeout, 10*time.Millisecond, "the failure should be quarantined")
+			require.Equal(t, test.kind, failures[0].Kind)
+
+			t.Setenv(failingBinaryEnv, "")
+			api.push(textMessage(61, "/retry "+failures[0].ID))
+			retried := api.waitFor(t, test.method, 1)[0]
+			api.push(test.message(api, 62))
+			original := api.waitFor(t, test.method, 2)[1]
+			media := sentMedia[test.method]
+			assert.NotEmpty(t, original.Params[media])
+			assert.Equal(t, original.Params[media], retried.Params[media])
+			if test.kind == "document" || test.kind == "audio" {
+				// the rest are sent under made-up names anyway
+				assert.Equal(t, original.Files[media], retried.Files[media], "the file should come back under the same name")
+			}
+		})
+	}
+}
+
+func TestE2EStillFailing(t *testing.T) {
+	api := newFakeBotAPI(t)
+	d := startTestBot(t, api)
+	t.Setenv(failingBinaryEnv, "magick")
+	api.push(photoMessage(api, 63))
+
+	var failures []tools.Failure
+	require.Eventually(t, func() bool {
+		var err error
+		failures, err = d.quarantine.List()
+		return err == nil && len(failures) == 1
+	}, waitTimeout, 10*time.Millisecond, "the failure should be quarantined")
+	m := textMessage(64, "/retry "+failures[0].ID)
+	m.Sender.LanguageCode = "ru"
+	api.push(m)
+	api.waitForText(t, "Всё ещё не получается на этапе distort")
+	failures, err := d.quarantine.List()
+	require.NoError(t, err)
+	assert.Len(t, failures, 1, "the retry shouldn't be quarantined again")
+}
+
+func TestTierIsResolvedOncePerUpdate(t *testing.T) {
+	d := startTestBot(t, newFakeBotAPI(t))
+	b, err := tb.NewBot(tb.Settings{Offline: true})
+	require.NoError(t, err)
+	update := tb.Update{Message: privateMessage(70)}
+	c := b.NewContext(update)
+	assert.Equal(t, regularTier, d.tier(c).Name)
+
+	require.NoError(t, d.db.SetChatTier(userID, priorityTier))
+	assert.Equal(t, regularTier, d.tier(c).Name, "the update should stick to the tier it started with")
+	assert.Equal(t, regularTier, d.tier(d.withSettingsOf(b.NewContext(update), c)).Name, "and pass it on")
+	assert.Equal(t, priorityTier, d.tier(b.NewContext(update)).Name, "the next update should see the new one")
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	tb "gopkg.in/telebot.v3"
+
+	"github.com/graynk/distortioner/distorters"
+	"github.com/graynk/distortioner/locale"
+	"github.com/graynk/distortioner/stats"
+	"github.com/graynk/distortioner/tools"
+)
+
+const (
+	retryKey          = "retry"
+	failuresPerList   = 20
+	failureErrorShown = 200
+)
+
+// keepFailed moves the media that failed to get distorted into the quarantine, so that it can be looked at later.
+// Has to be called before the job's scratch directory is closed
+func (d DistorterBot) keepFailed(c tb.Context, filename string, err error) {
+	if d.ctx.Err() != nil || errors.Is(err, distorters.ErrOutOfRange) {
+		// shutting down or asked for something impossible, nothing wrong with the media itself
+		return
+	}
+	stage := distorters.StageOf(err)
+	if isRetry(c) {
+		c.Reply(locale.Get(d.language(c), locale.StillFailing, stage, err))
+		return
+	}
+	m := c.Message()
+	failure := tools.Failure{
+		Kind:  stats.MessageType(m),
+		Stage: string(stage),
+		Error: err.Error(),
+	}
+	if m.Document != nil {
+		failure.MIME, failure.Name = m.Document.MIME, m.Document.FileName
+	} else if m.Audio != nil {
+		failure.MIME, failure.Name = m.Audio.MIME, m.Audio.FileName
+	}
+	var runError *tools.RunError
+	if errors.As(err, &runError) {
+		failure.Stderr = runError.Stderr
+	}
+	if info, err := distorters.ProbeMedia(d.ctx, filename); err == nil {
+		failure.Probe, _ = json.Marshal(info)
+	}
+	failure.Options, _ = json.Marshal(d.options(c))
+	failure, err = d.quarantine.Keep(filename, failure)
+	if err != nil {
+		d.logger.Errorw("failed to quarantine the media", "error", err)
+		return
+	}
+	d.logger.Infow("quarantined failed media", "id", failure.ID, "kind", failure.Kind, "stage", failure.Stage)
+}
+
+// withRetry makes the handlers use the options the failure was recorded with, instead of the chat settings
+func withRetry(c tb.Context, options distorters.Options) tb.Context {
+	c.Set(retryKey, true)
+	return withOptions(c, options)
+}
+
+func isRetry(c tb.Context) bool {
+	retry, _ := c.Get(retryKey).(bool)
+	return retry
+}
+
+func describeFailure(failure tools.Failure) string {
+	description := failure.Error
+	if len(description) > failureErrorShown {
+		description = description[:failureErrorShown] + "…"
+	}
+	stage := failure.Stage
+	if stage == "" {
+		stage = "unknown"
+	}
+	return fmt.Sprintf("%s %s %s, %s stage: %s",
+		failure.ID, failure.Time.Format("2006-01-02 15:04"), failure.Kind, stage, description)
+}
+
+// handleFailed lists the quarantined media, or sends the media itself along with the logs if given an ID
+func (d DistorterBot) handleFailed(c tb.Context) error {
+	if c.Message().Sender.ID != d.adminID {
+		return nil
+	}
+	if c.Message().Payload != "" {
+		return d.sendFailure(c, c.Message().Payload)
+	}
+	failures, err := d.quarantine.List()
+	if err != nil {
+		d.logger.Error(err)
+		return c.Reply(err.Error())
+	}
+	if len(failures) == 0 {
+		return c.Reply("Nothing failed, nice")
+	}
+	lines := make([]string, 0, failuresPerList+1)
+	for i, failure := range failures {
+		if i == failuresPerList {
+			lines = append(lines, fmt.Sprintf("…and %d more", len(failures)-failuresPerList))
+			break
+		}
+		lines = append(lines, describeFailure(failure))
+	}
+	return c.Reply(strings.Join(lines, "\n\n") + "\n\n/failed <id> to get the file, /retry <id> to run it again")
+}
+
+func (d DistorterBot) sendFailure(c tb.Context, id string) error {
+	failure, media, sidecar, err := d.quarantine.Get(id)
+	if err != nil {
+		return c.Reply(err.Error())
+	}
+	_, err = c.Bot().Reply(c.Message(), &tb.Document{
+		File:     tb.FromDisk(media),
+		FileName: failure.ID,
+		Caption:  describeFailure(failure),
+	})
+	if err != nil {
+		d.logger.Error(err)
+		return c.Reply(err.Error())
+	}
+	return c.Reply(&tb.Document{File: tb.FromDisk(sidecar), FileName: failure.ID + ".json"})
+}
+
+// handleRetry runs the quarantined media through the same pipeline its kind went through originally, with the same options,
+// sending the result to the admin. The media stays in the quarantine either way
+func (d DistorterBot) handleRetry(c tb.Context) error {
+	if c.Message().Sender.ID != d.adminID {
+		return nil
+	}
+	failure, media, _, err := d.quarantine.Get(c.Message().Payload)
+	if err != nil {
+		return c.Reply(err.Error())
+	}
+	var options distorters.Options
+	err = json.Unmarshal(failure.Options, &options)
+	if err != nil {
+		options = d.options(c)
+	}
+	c = withRetry(c, options)
+	scratch := d.newScratch(c)
+	if scratch == nil {
+		return nil
+	}
+	// the pipeline distorts some files in place, the original has to stay intact
+	filename := scratch.Path(failure.ID)
+	err = tools.CopyFile(media, filename)
+	if err != nil {
+		scratch.Close()
+		d.logger.Error(err)
+		return c.Reply(err.Error())
+	}
+	switch failure.Kind {
+	case "animation":
+		return d.submitRetry(c, scratch, filename, d.animationJob(c, scratch, "", filename))
+	case "video":
+		return d.submitRetry(c, scratch, filename, d.videoJob(c, scratch, "", filename))
+	case "videonote":
+		return d.submitRetry(c, scratch, filename, d.videoNoteJob(c, scratch, "", filename))
+	case "document":
+		return d.distortDocumentFile(c, scratch, filename, failure.MIME, failure.Name)
+	}
+	defer scratch.Close()
+	switch failure.Kind {
+	case "photo":
+		return d.distortPhotoFile(c, "", filename)
+	case "sticker":
+		return d.distortStickerFile(c, "", filename)
+	case "voice":
+		return d.distortVoiceFile(c, "", filename)
+	case "audio":
+		return d.distortAudioFile(c, filename, "", "", failure.Name)
+	}
+	return c.Reply("Don't know how to retry " + failure.Kind)
+}
+
+// submitRetry queues the retry of a video, a video note or a GIF like their handlers do
+func (d DistorterBot) submitRetry(c tb.Context, scratch *tools.Scratch, filename string, job func()) error {
+	err := d.videoWorker.SubmitTier(c.Chat().ID, d.fileCost(c, filename), d.tier(c).Queue, job)
+	if err != nil {
+		scratch.Close()
+		return c.Reply(queueErrorMessage(d.language(c), err))
+	}
+	return nil
+}
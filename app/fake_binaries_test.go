@@ -0,0 +1,133 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+// the test binary pretends to be ffmpeg, ffprobe and magick when it's started under their names,
+// so the whole pipeline runs through the real ExecRunner without any of them installed
+var fakeBinaries = map[string]func(args []string) error{
+	"ffmpeg":  fakeFfmpeg,
+	"ffprobe": fakeFfprobe,
+	"magick":  fakeMagick,
+}
+
+const (
+	// failingBinaryEnv makes the fake binary with that name fail, to see how the bot deals with broken media
+	failingBinaryEnv = "DISTORTIONER_FAKE_FAIL"
+	// workerProcessEnv makes the test binary run as `distortioner worker`, see startWorkerProcess
+	workerProcessEnv = "DISTORTIONER_TEST_WORKER"
+	testWorkerToken  = "worker token"
+)
+
+func TestMain(m *testing.M) {
+	name := filepath.Base(os.Args[0])
+	fake, ok := fakeBinaries[name]
+	if !ok && os.Getenv(workerProcessEnv) != "" {
+		runWorker(zap.NewNop().Sugar())
+		os.Exit(0)
+	} else if !ok {
+		os.Exit(m.Run())
+	}
+	if os.Getenv(failingBinaryEnv) == name {
+		fmt.Fprintln(os.Stderr, "Invalid data found when processing input")
+		os.Exit(1)
+	}
+	if err := fake(os.Args[1:]); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	os.Exit(0)
+}
+
+// installFakeBinaries puts the fake binaries first in PATH for the rest of the test
+func installFakeBinaries(t *testing.T) {
+	executable, err := os.Executable()
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	for name := range fakeBinaries {
+		err = os.Symlink(executable, filepath.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+}
+
+// startWorkerProcess runs `distortioner worker` for the bot at url until the end of the test, with the fake binaries.
+// failing is the fake binary that fails in that worker, if any
+func startWorkerProcess(t *testing.T, url, failing string) {
+	executable, err := os.Executable()
+	require.NoError(t, err)
+	cmd := exec.Command(executable)
+	cmd.Env = append(os.Environ(),
+		workerProcessEnv+"=1",
+		"DISTORTIONER_WORKER_URL="+url,
+		"DISTORTIONER_WORKER_TOKEN="+testWorkerToken,
+		"DISTORTIONER_WORKER_SLOTS=1",
+		"DISTORTIONER_SCRATCH_DIR="+t.TempDir(),
+		failingBinaryEnv+"="+failing,
+	)
+	cmd.Stderr = os.Stderr
+	require.NoError(t, cmd.Start())
+	t.Cleanup(func() {
+		cmd.Process.Signal(syscall.SIGTERM)
+		cmd.Wait()
+	})
+}
+
+// fakeFfprobe prints the file itself: the fake media downloaded from the fake Bot API is ffprobe's JSON output
+func fakeFfprobe(args []string) error {
+	probe, err := os.ReadFile(args[len(args)-1])
+	if err != nil {
+		return err
+	}
+	_, err = os.Stdout.Write(probe)
+	return err
+}
+
+// fakeFfmpeg lists a single working encoder, extracts a few frames and writes something into any other output
+func fakeFfmpeg(args []string) error {
+	if len(args) > 1 && args[1] == "-encoders" {
+		fmt.Println(" V..... = Video\n ------\n V....D libx264              libx264 H.264 / AVC / MPEG-4 AVC")
+		return nil
+	}
+	output := args[len(args)-1]
+	switch {
+	case output == "-":
+		return nil
+	case strings.Contains(output, "%04d"):
+		for frame := 1; frame <= 3; frame++ {
+			err := os.WriteFile(fmt.Sprintf(output, frame), []byte("frame"), 0644)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return os.WriteFile(output, []byte("distorted by ffmpeg"), 0644)
+}
+
+// fakeMagick identifies everything as a perfect sticker and writes something into the output
+func fakeMagick(args []string) error {
+	if args[0] == "identify" {
+		fmt.Println("WEBP 512 512")
+		return nil
+	}
+	_, output, found := strings.Cut(args[len(args)-1], ":")
+	if !found {
+		output = args[len(args)-1]
+	}
+	return os.WriteFile(output, []byte("distorted by magick"), 0644)
+}
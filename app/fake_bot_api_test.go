@@ -0,0 +1,316 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	tb "gopkg.in/telebot.v3"
+)
+
+const (
+	testToken       = "123456:fake"
+	testBotUsername = "distortioner_bot"
+	waitTimeout     = 10 * time.Second
+)
+
+// apiCall is a request the bot made to the fake Bot API. Uploaded files are in Params too, as their content
+type apiCall struct {
+	Method string
+	Params map[string]string
+	Files  map[string]string // the names the files were uploaded under
+}
+
+// apiError is what the fake Bot API answers instead of the next successful call to the method
+type apiError struct {
+	Code        int
+	Description string
+	RetryAfter  int
+}
+
+// what the sent message carries, for the methods that send media
+var sentMedia = map[string]string{
+	"sendPhoto":     "photo",
+	"sendVideo":     "video",
+	"sendAnimation": "animation",
+	"sendSticker":   "sticker",
+	"sendVoice":     "voice",
+	"sendVideoNote": "video_note",
+	"sendDocument":  "document",
+	"sendAudio":     "audio",
+}
+
+// fakeBotAPI is an in-process stand-in for the Bot API: it hands out the updates and the files it's given,
+// remembers every call the bot makes and fails the calls it's told to
+type fakeBotAPI struct {
+	server  *httptest.Server
+	mu      *sync.Mutex
+	calls   []apiCall
+	updates []tb.Update
+	files   map[string][]byte     // file ID to the content
+	chats   map[int64]tb.Chat     // what getChat answers, private chats by default
+	errors  map[string][]apiError // method to the errors to answer with, in order
+	sent    int                   // for the IDs of the sent messages and files
+}
+
+func newFakeBotAPI(t *testing.T) *fakeBotAPI {
+	api := &fakeBotAPI{
+		mu:     &sync.Mutex{},
+		files:  make(map[string][]byte),
+		chats:  make(map[int64]tb.Chat),
+		errors: make(map[string][]apiError),
+	}
+	api.server = httptest.NewServer(http.HandlerFunc(api.serve))
+	t.Cleanup(api.server.Close)
+	return api
+}
+
+func (api *fakeBotAPI) URL() string {
+	return api.server.URL
+}
+
+// addFile makes the file downloadable by its ID
+func (api *fakeBotAPI) addFile(fileID string, content []byte) {
+	api.mu.Lock()
+	defer api.mu.Unlock()
+	api.files[fileID] = content
+}
+
+func (api *fakeBotAPI) addChat(chat tb.Chat) {
+	api.mu.Lock()
+	defer api.mu.Unlock()
+	api.chats[chat.ID] = chat
+}
+
+// push queues the message to be handed out with the next getUpdates
+func (api *fakeBotAPI) push(m *tb.Message) {
+	api.mu.Lock()
+	defer api.mu.Unlock()
+	if m.Unixtime == 0 {
+		m.Unixtime = time.Now().Unix()
+	}
+	api.updates = append(api.updates, tb.Update{ID: len(api.updates) + 1, Message: m})
+}
+
+// failNext makes the next call to the method fail with the error
+func (api *fakeBotAPI) failNext(method string, err apiError) {
+	api.mu.Lock()
+	defer api.mu.Unlock()
+	api.errors[method] = append(api.errors[method], err)
+}
+
+// callsTo returns the calls made to the method so far
+func (api *fakeBotAPI) callsTo(method string) []apiCall {
+	api.mu.Lock()
+	defer api.mu.Unlock()
+	var calls []apiCall
+	for _, call := range api.calls {
+		if call.Method == method {
+			calls = append(calls, call)
+		}
+	}
+	return calls
+}
+
+// waitFor waits until the method gets called count times, failing the test if it doesn't
+func (api *fakeBotAPI) waitFor(t *testing.T, method string, count int) []apiCall {
+	t.Helper()
+	deadline := time.Now().Add(waitTimeout)
+	for time.Now().Before(deadline) {
+		if calls := api.callsTo(method); len(calls) >= count {
+			return calls
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("%s was called %d times, expected %d", method, len(api.callsTo(method)), count)
+	return nil
+}
+
+// waitForText waits until a message containing the text gets sent, failing the test if it doesn't
+func (api *fakeBotAPI) waitForText(t *testing.T, text string) apiCall {
+	t.Helper()
+	deadline := time.Now().Add(waitTimeout)
+	for time.Now().Before(deadline) {
+		for _, call := range api.callsTo("sendMessage") {
+			if strings.Contains(call.Params["text"], text) {
+				return call
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("%q was never sent", text)
+	return apiCall{}
+}
+
+func (api *fakeBotAPI) serve(w http.ResponseWriter, r *http.Request) {
+	if fileID, found := strings.CutPrefix(r.URL.Path, "/file/bot"+testToken+"/files/"); found {
+		api.mu.Lock()
+		content, ok := api.files[fileID]
+		api.mu.Unlock()
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write(content)
+		return
+	}
+	method, found := strings.CutPrefix(r.URL.Path, "/bot"+testToken+"/")
+	if !found {
+		http.NotFound(w, r)
+		return
+	}
+	call, err := readCall(method, r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if method == "getUpdates" {
+		api.answer(w, api.pendingUpdates(call))
+		return
+	}
+
+	api.mu.Lock()
+	api.calls = append(api.calls, call)
+	var failure *apiError
+	if queued := api.errors[method]; len(queued) > 0 {
+		failure = &queued[0]
+		api.errors[method] = queued[1:]
+	}
+	api.mu.Unlock()
+	if failure != nil {
+		api.fail(w, *failure)
+		return
+	}
+
+	switch method {
+	case "getMe":
+		api.answer(w, tb.User{ID: 1, IsBot: true, FirstName: "Distortioner", Username: testBotUsername})
+	case "getFile":
+		fileID := call.Params["file_id"]
+		api.answer(w, map[string]string{"file_id": fileID, "file_unique_id": fileID, "file_path": "files/" + fileID})
+	case "getChat":
+		id, _ := strconv.ParseInt(call.Params["chat_id"], 10, 64)
+		api.mu.Lock()
+		chat, ok := api.chats[id]
+		api.mu.Unlock()
+		if !ok {
+			chat = tb.Chat{ID: id, Type: tb.ChatPrivate}
+		}
+		api.answer(w, chat)
+	case "sendMessage", "editMessageText", "editMessageCaption":
+		api.answer(w, api.sentMessage(call, "text", call.Params["text"]))
+	default:
+		if field, ok := sentMedia[method]; ok {
+			api.answer(w, api.sentMessage(call, field, nil))
+			return
+		}
+		api.answer(w, true)
+	}
+}
+
+// readCall reads the parameters, sent either as JSON or as a multipart form along with the files
+func readCall(method string, r *http.Request) (apiCall, error) {
+	call := apiCall{Method: method, Params: make(map[string]string), Files: make(map[string]string)}
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+		err := r.ParseMultipartForm(32 << 20)
+		if err != nil {
+			return call, err
+		}
+		// telebot doesn't always name the files, and the unnamed ones end up with the values
+		for key, values := range r.MultipartForm.Value {
+			call.Params[key] = values[0]
+		}
+		for key, headers := range r.MultipartForm.File {
+			file, err := headers[0].Open()
+			if err != nil {
+				return call, err
+			}
+			content, err := io.ReadAll(file)
+			file.Close()
+			if err != nil {
+				return call, err
+			}
+			call.Params[key] = string(content)
+			call.Files[key] = headers[0].Filename
+		}
+		return call, nil
+	}
+	var params map[string]interface{}
+	err := json.NewDecoder(r.Body).Decode(&params)
+	if err != nil && err != io.EOF {
+		return call, err
+	}
+	for key, value := range params {
+		if s, ok := value.(string); ok {
+			call.Params[key] = s
+			continue
+		}
+		encoded, _ := json.Marshal(value)
+		call.Params[key] = string(encoded)
+	}
+	return call, nil
+}
+
+func (api *fakeBotAPI) pendingUpdates(call apiCall) []tb.Update {
+	offset, _ := strconv.Atoi(call.Params["offset"])
+	// long polling, more or less
+	for deadline := time.Now().Add(50 * time.Millisecond); time.Now().Before(deadline); time.Sleep(5 * time.Millisecond) {
+		api.mu.Lock()
+		var pending []tb.Update
+		for _, update := range api.updates {
+			if update.ID >= offset {
+				pending = append(pending, update)
+			}
+		}
+		api.mu.Unlock()
+		if len(pending) > 0 {
+			return pending
+		}
+	}
+	return []tb.Update{}
+}
+
+// sentMessage makes up the message the method would have sent, with a new file in the field for media
+func (api *fakeBotAPI) sentMessage(call apiCall, field string, value interface{}) map[string]interface{} {
+	api.mu.Lock()
+	api.sent++
+	id := api.sent
+	api.mu.Unlock()
+	chatID, _ := strconv.ParseInt(call.Params["chat_id"], 10, 64)
+	if messageID, err := strconv.Atoi(call.Params["message_id"]); err == nil {
+		id = messageID // edited, not sent
+	}
+	if value == nil {
+		file := map[string]interface{}{"file_id": fmt.Sprintf("sent%d", id), "file_unique_id": fmt.Sprintf("unique%d", id)}
+		value = file
+		if field == "photo" {
+			value = []interface{}{file}
+		}
+	}
+	return map[string]interface{}{
+		"message_id": id,
+		"date":       time.Now().Unix(),
+		"chat":       map[string]interface{}{"id": chatID, "type": "private"},
+		field:        value,
+	}
+}
+
+func (api *fakeBotAPI) answer(w http.ResponseWriter, result interface{}) {
+	json.NewEncoder(w).Encode(map[string]interface{}{"ok": true, "result": result})
+}
+
+func (api *fakeBotAPI) fail(w http.ResponseWriter, failure apiError) {
+	response := map[string]interface{}{"ok": false, "error_code": failure.Code, "description": failure.Description}
+	if failure.RetryAfter > 0 {
+		response["parameters"] = map[string]int{"retry_after": failure.RetryAfter}
+	}
+	w.WriteHeader(failure.Code)
+	json.NewEncoder(w).Encode(response)
+}
@@ -1,45 +1,101 @@
 package main
 
 import (
-	"os"
+	"errors"
 	"strings"
-	"sync"
 	"time"
+	"unicode/utf16"
 
 	tb "gopkg.in/telebot.v3"
 
 	"github.com/graynk/distortioner/distorters"
+	"github.com/graynk/distortioner/locale"
+	"github.com/graynk/distortioner/queue"
+	"github.com/graynk/distortioner/stats"
 	"github.com/graynk/distortioner/tools"
 )
 
-const (
-	NotEnoughRights = "The bot does not have enough rights to send media to your chat"
-	NotSupported    = "Not supported yet, sorry"
-)
-
 type MethodOfResponding = int
 
+var errTooBigToUpload = errors.New("the result is too big to upload")
+
 const (
 	Reply MethodOfResponding = iota
 	Send
 )
 
-func (d DistorterBot) HandleAnimationCommon(c tb.Context) (*tb.Message, string, string, error) {
-	m := c.Message()
-	b := c.Bot()
-	progressMessage, err := d.SendMessage(c, "Downloading...", Reply)
+// startProgress sends the first progress message, unless the chat doesn't want to see those
+func (d DistorterBot) startProgress(c tb.Context, key locale.Key) (*tb.Message, error) {
+	settings := d.settings(c)
+	if !settings.ShowProgress || isAuto(c) {
+		return nil, nil
+	}
+	progressMessage, err := d.SendMessage(c, locale.Get(d.language(c), key), d.method(settings))
 	if err != nil {
 		d.logger.Error(err)
-		return nil, "", "", err
 	}
-	filename, err := tools.JustGetTheFile(b, m)
-	if err != nil {
+	return progressMessage, err
+}
+
+// newScratch creates the directory for the files of a new job, letting the user know if there's no room for one
+func (d DistorterBot) newScratch(c tb.Context) *tools.Scratch {
+	scratch, err := d.workspace.NewJob()
+	if errors.Is(err, tools.ErrQuotaExceeded) {
+		d.logger.Warn(err)
+		d.notify(c, locale.Get(d.language(c), locale.NoSpace))
+		return nil
+	} else if err != nil {
 		d.logger.Error(err)
-		return nil, "", "", err
+		d.notify(c, locale.Get(d.language(c), locale.Failed))
+		return nil
 	}
-	animationOutput := filename + ".mp4"
+	return scratch
+}
+
+// HandleAnimationCommon downloads the GIF, unless the file is already there, and distorts it
+func (d DistorterBot) HandleAnimationCommon(c tb.Context, scratch *tools.Scratch, filename string) (*tb.Message, string, error) {
+	progressMessage, err := d.startProgress(c, locale.Downloading)
+	if err != nil {
+		return nil, "", err
+	}
+	if filename == "" {
+		filename, err = tools.JustGetTheFile(c.Bot(), d.botAPI, c.Message(), d.language(c), scratch)
+		if err != nil {
+			d.logger.Error(err)
+			return nil, "", err
+		}
+	}
+	progressMessage, animationOutput, err := d.DistortAnimationFile(c, progressMessage, filename)
+	if err != nil {
+		d.keepFailed(c, filename, err)
+	}
+	return progressMessage, animationOutput, err
+}
+
+// DistortAnimationFile runs the frame-by-frame distortion for an already downloaded file, reporting the progress
+func (d DistorterBot) DistortAnimationFile(c tb.Context, progressMessage *tb.Message, filename string) (*tb.Message, string, error) {
+	info, err := distorters.ProbeMedia(d.ctx, filename)
+	if err != nil {
+		return progressMessage, "", err
+	}
+	output := filename + ".mp4"
+	progressMessage, err = d.distortVideo(c, progressMessage, filename, output, info, distorters.SoundNone)
+	return progressMessage, output, err
+}
+
+// distortVideo clips the video and has the processor distort it, editing the progress message with the reports
+func (d DistorterBot) distortVideo(c tb.Context, progressMessage *tb.Message, filename, output string, info distorters.MediaInfo, sound distorters.SoundMode) (*tb.Message, error) {
+	b := c.Bot()
+	err := d.clip(c, info, d.tier(c).MaxVideoDuration)
+	if err != nil {
+		return d.badRange(c, progressMessage, info), err
+	}
+	job := distorters.VideoJob{Info: info, Options: d.options(c), Sound: sound, Lang: d.language(c)}
 	progressChan := make(chan string, 3)
-	go distorters.DistortVideo(filename, d.codec, animationOutput, progressChan)
+	errChan := make(chan error, 1)
+	go func() {
+		errChan <- d.processor.Process(d.ctx, job, filename, output, progressChan)
+	}()
 	for report := range progressChan {
 		if progressMessage == nil {
 			continue
@@ -49,66 +105,86 @@ func (d DistorterBot) HandleAnimationCommon(c tb.Context) (*tb.Message, string,
 			progressMessage = msg
 		}
 	}
-	_, err = os.Stat(animationOutput)
-	return progressMessage, filename, animationOutput, err
+	return progressMessage, <-errChan
 }
 
-func (d DistorterBot) HandleVideoCommon(c tb.Context) (string, *tb.Message, error) {
-	progressMessage, filename, animationOutput, err := d.HandleAnimationCommon(c)
-	defer os.Remove(filename)
+// HandleVideoCommon downloads the video, unless the file is already there, and distorts it
+func (d DistorterBot) HandleVideoCommon(c tb.Context, scratch *tools.Scratch, filename string) (string, *tb.Message, error) {
+	progressMessage, err := d.startProgress(c, locale.Downloading)
 	if err != nil {
-		if progressMessage != nil && progressMessage.Text != distorters.TooLong {
-			d.DoneMessageWithRepeater(c.Bot(), progressMessage, true)
+		return "", nil, err
+	}
+	if filename == "" {
+		filename, err = tools.JustGetTheFile(c.Bot(), d.botAPI, c.Message(), d.language(c), scratch)
+		if err != nil {
+			d.logger.Error(err)
+			return "", nil, err
 		}
-		return "", progressMessage, err
 	}
-	defer os.Remove(animationOutput)
-	soundOutput := filename + ".ogg"
-	err = distorters.DistortSound(filename, soundOutput)
+	output, progressMessage, err := d.DistortVideoFile(c, progressMessage, filename)
 	if err != nil {
-		soundOutput = ""
-	} else {
-		defer os.Remove(soundOutput)
+		d.keepFailed(c, filename, err)
+	}
+	return output, progressMessage, err
+}
+
+// DistortVideoFile distorts both the frames and the sound of an already downloaded file.
+// The progress message is left for the caller to deal with, whatever happens
+func (d DistorterBot) DistortVideoFile(c tb.Context, progressMessage *tb.Message, filename string) (string, *tb.Message, error) {
+	info, err := distorters.ProbeMedia(d.ctx, filename)
+	if err != nil {
+		return "", progressMessage, err
+	}
+	sound := distorters.SoundKeep
+	if d.settings(c).DistortAudio {
+		sound = distorters.SoundDistort
 	}
 	output := filename + "Final.mp4"
-	if progressMessage != nil {
-		// intentionally not updating progressMessage variable
-		c.Edit(progressMessage, "Muxing frames with sound back together...")
+	progressMessage, err = d.distortVideo(c, progressMessage, filename, output, info, sound)
+	if err != nil {
+		return "", progressMessage, err
 	}
-	err = distorters.CollectAnimationAndSound(animationOutput, soundOutput, output)
-	return output, progressMessage, err
+	return output, progressMessage, nil
 }
 
-func (d DistorterBot) HandleVideoSticker(c tb.Context) (string, string, error) {
-	filename, err := tools.JustGetTheFile(c.Bot(), c.Message())
+func (d DistorterBot) HandleVideoSticker(c tb.Context, scratch *tools.Scratch) (string, string, error) {
+	filename, err := tools.JustGetTheFile(c.Bot(), d.botAPI, c.Message(), d.language(c), scratch)
 	if err != nil {
 		d.logger.Error(err)
 		return "", "", err
 	}
+	info, err := distorters.ProbeMedia(d.ctx, filename)
+	if err != nil {
+		return filename, "", err
+	}
+	err = d.clip(c, info, distorters.MaxVideoStickerDuration)
+	if err != nil {
+		d.badRange(c, nil, info)
+		return filename, "", err
+	}
 	animationOutput := filename + ".webm"
-	group := sync.WaitGroup{}
-	group.Add(1)
-	go distorters.DistortVideoSticker(filename, animationOutput, &group)
-	group.Wait()
-	_, err = os.Stat(animationOutput)
+	err = distorters.DistortVideoSticker(d.ctx, filename, info, animationOutput, d.options(c))
 	return filename, animationOutput, err
 }
 
-func (d DistorterBot) dealWithStatusMessage(b *tb.Bot, m *tb.Message, failed bool) error {
+func (d DistorterBot) dealWithStatusMessage(b *tb.Bot, m *tb.Message, lang string, failed bool) error {
 	if m == nil {
 		return nil
 	}
 	var err error
 	if failed {
-		_, err = b.Edit(m, distorters.Failed)
+		_, err = b.Edit(m, locale.Get(lang, locale.Failed))
 	} else {
 		err = b.Delete(m)
 	}
 	return err
 }
 
-func (d DistorterBot) DoneMessageWithRepeater(b *tb.Bot, m *tb.Message, failed bool) {
-	err := d.dealWithStatusMessage(b, m, failed)
+// DoneMessageWithRepeater deletes the progress message, or says it failed in the language of whoever asked
+func (d DistorterBot) DoneMessageWithRepeater(c tb.Context, m *tb.Message, failed bool) {
+	b := c.Bot()
+	lang := d.language(c)
+	err := d.dealWithStatusMessage(b, m, lang, failed)
 	for err != nil {
 		var timeout int
 		timeout, err = tools.ExtractPossibleTimeout(err)
@@ -116,30 +192,34 @@ func (d DistorterBot) DoneMessageWithRepeater(b *tb.Bot, m *tb.Message, failed b
 			return
 		}
 		time.Sleep(time.Duration(timeout) * time.Second)
-		err = d.dealWithStatusMessage(b, m, failed)
+		err = d.dealWithStatusMessage(b, m, lang, failed)
 	}
 }
 
-func (d DistorterBot) SendMessage(c tb.Context, toSend interface{}, method MethodOfResponding) (*tb.Message, error) {
+func (d DistorterBot) SendMessage(c tb.Context, toSend interface{}, method MethodOfResponding, opts ...interface{}) (*tb.Message, error) {
 	b := c.Bot()
 	message := c.Message()
+	if d.botAPI.TooBigToUpload(toSend) {
+		d.notify(c, locale.Get(d.language(c), locale.TooBig))
+		return nil, errTooBigToUpload
+	}
 
 	var m *tb.Message
 	var err error
 	if method == Reply {
-		m, err = b.Reply(message, toSend)
+		m, err = b.Reply(message, toSend, opts...)
 	} else {
-		m, err = b.Send(message.Chat, toSend)
+		m, err = b.Send(message.Chat, toSend, opts...)
 	}
 	for err != nil {
 		switch {
 		case strings.Contains(err.Error(), "not enough rights to send"):
-			b.Reply(message, NotEnoughRights)
+			b.Reply(message, locale.Get(d.language(c), locale.NotEnoughRights))
 		case strings.Contains(err.Error(), "bot was blocked by the user (403)"):
 			d.videoWorker.BanUser(message.Chat.ID)
 			return nil, nil
 		case strings.Contains(err.Error(), "telegram: Bad Request: message to be replied not found (400)"):
-			return d.SendMessage(c, toSend, Send)
+			return d.SendMessage(c, toSend, Send, opts...)
 		}
 
 		var timeout int
@@ -149,7 +229,7 @@ func (d DistorterBot) SendMessage(c tb.Context, toSend interface{}, method Metho
 			return nil, err
 		}
 		time.Sleep(time.Duration(timeout) * time.Second)
-		m, err = b.Reply(message, toSend)
+		m, err = b.Reply(message, toSend, opts...)
 		if err != nil {
 			d.logger.Error(err)
 		}
@@ -158,11 +238,152 @@ func (d DistorterBot) SendMessage(c tb.Context, toSend interface{}, method Metho
 	return m, nil
 }
 
-func (d DistorterBot) SendMessageWithRepeater(c tb.Context, toSend interface{}) error {
-	_, err := d.SendMessage(c, toSend, Reply)
+func (d DistorterBot) SendAlbumWithRepeater(c tb.Context, album tb.Album) error {
+	b := c.Bot()
+	options := &tb.SendOptions{}
+	if d.settings(c).Reply {
+		options.ReplyTo = c.Message()
+	}
+	_, err := b.SendAlbum(c.Chat(), album, options)
+	for err != nil {
+		switch {
+		case strings.Contains(err.Error(), "bot was blocked by the user (403)"):
+			d.videoWorker.BanUser(c.Chat().ID)
+			return nil
+		case strings.Contains(err.Error(), "message to be replied not found (400)"):
+			options.ReplyTo = nil
+			_, err = b.SendAlbum(c.Chat(), album, options)
+			continue
+		}
+
+		var timeout int
+		timeout, err = tools.ExtractPossibleTimeout(err)
+		if err != nil {
+			d.logger.Error(err)
+			return err
+		}
+		time.Sleep(time.Duration(timeout) * time.Second)
+		_, err = b.SendAlbum(c.Chat(), album, options)
+	}
+	return nil
+}
+
+func (d DistorterBot) SendMessageWithRepeater(c tb.Context, toSend interface{}, opts ...interface{}) error {
+	_, err := d.SendMessage(c, toSend, d.method(d.settings(c)), opts...)
 	return err
 }
 
+// rateLimited checks the rate limit for the chat's tier, letting the user know how long to wait unless it's
+// an auto-distortion. Every pass of /distort xN counts as a separate request
+func (d DistorterBot) rateLimited(c tb.Context, lang string) bool {
+	rate, diff := d.rl.GetWeightedRateOverPeriod(c.Chat().ID, time.Now().Unix(), d.options(c).PassCount())
+	if rate <= d.tier(c).AllowedOverTime {
+		return false
+	}
+	if !isAuto(c) {
+		d.SendMessageWithRepeater(c, tools.FormatRateLimitResponse(lang, diff))
+	}
+	return true
+}
+
+// jobCost estimates the work for the media in the message before it's downloaded, from what Telegram tells about it
+func (d DistorterBot) jobCost(c tb.Context) float64 {
+	return messageCost(c.Message(), d.options(c))
+}
+
+func messageCost(m *tb.Message, options distorters.Options) float64 {
+	var video distorters.StreamInfo
+	var duration int
+	switch {
+	case m.Animation != nil:
+		video, duration = distorters.StreamInfo{Width: m.Animation.Width, Height: m.Animation.Height}, m.Animation.Duration
+	case m.Video != nil:
+		video, duration = distorters.StreamInfo{Width: m.Video.Width, Height: m.Video.Height}, m.Video.Duration
+	case m.VideoNote != nil:
+		video, duration = distorters.StreamInfo{Width: m.VideoNote.Length, Height: m.VideoNote.Length}, m.VideoNote.Duration
+	case m.Photo != nil:
+		return float64(options.PassCount()) // just the one frame
+	}
+	return distorters.JobCost(video, float64(duration), options)
+}
+
+// fileCost estimates the work for an already downloaded video, which unlike the message gives away the frame rate
+func (d DistorterBot) fileCost(c tb.Context, filename string) float64 {
+	info, err := distorters.ProbeMedia(d.ctx, filename)
+	if err != nil {
+		// it's going to fail anyway
+		return 1
+	}
+	video, _ := info.Video()
+	return distorters.JobCost(video, info.Duration, d.options(c))
+}
+
+// notify sends service messages (errors, limits, queue status), which are skipped for auto-distortions
+func (d DistorterBot) notify(c tb.Context, text string) error {
+	if isAuto(c) {
+		return nil
+	}
+	return d.SendMessageWithRepeater(c, text)
+}
+
+func (d DistorterBot) method(settings stats.ChatSettings) MethodOfResponding {
+	if settings.Reply {
+		return Reply
+	}
+	return Send
+}
+
+// caption returns the caption for the distorted media and its formatting, depending on the chat settings
+func (d DistorterBot) caption(c tb.Context) (string, tb.Entities) {
+	m := c.Message()
+	caption, entities := m.Caption, m.CaptionEntities
+	if caption != "" && d.settings(c).DistortCaption {
+		caption, entities = distorters.DistortTextEntities(caption, entities, d.options(c).TextMode, distorters.MaxCaptionLength)
+	}
+	note := d.trimNote(c)
+	switch {
+	case note == "":
+	case caption == "":
+		caption = note
+	case len(utf16.Encode([]rune(caption+note)))+2 <= distorters.MaxCaptionLength:
+		caption += "\n\n" + note
+	}
+	return caption, entities
+}
+
+// pickLanguage picks the language for the chat: the /lang override if there is one, otherwise the user's Telegram language
+func pickLanguage(settings stats.ChatSettings, user *tb.User) string {
+	if settings.Language != "" {
+		return settings.Language
+	}
+	if user != nil {
+		return locale.Normalize(user.LanguageCode)
+	}
+	return locale.Default
+}
+
+// languageOf is language for when there's no update to keep the settings in
+func (d DistorterBot) languageOf(chatID int64, user *tb.User) string {
+	return pickLanguage(d.chatSettings(chatID), user)
+}
+
+func (d DistorterBot) language(c tb.Context) string {
+	return pickLanguage(d.settings(c), c.Sender())
+}
+
+// queueErrorMessage translates the errors returned by the video queue, passing through anything unknown as-is
+func queueErrorMessage(lang string, err error) string {
+	switch {
+	case errors.Is(err, queue.ErrMaintenance):
+		return locale.Get(lang, locale.Maintenance)
+	case errors.Is(err, queue.ErrQueueFull):
+		return locale.Get(lang, locale.QueueFull)
+	case errors.Is(err, queue.ErrTooOften):
+		return locale.Get(lang, locale.TooOften)
+	}
+	return err.Error()
+}
+
 func (d DistorterBot) ApplyShutdownMiddleware(h tb.HandlerFunc) tb.HandlerFunc {
 	return func(c tb.Context) error {
 		d.graceWg.Add(1)
@@ -0,0 +1,235 @@
+package locale
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Key identifies a user-facing message in the catalog
+type Key string
+
+const (
+	Failed          Key = "failed"
+	TooLong         Key = "too_long"
+	TooBig          Key = "too_big"
+	Queued          Key = "queued"
+	NotEnoughRights Key = "not_enough_rights"
+	NotSupported    Key = "not_supported"
+	Start           Key = "start"
+	RateLimited     Key = "rate_limited"
+	Downloading     Key = "downloading"
+	DownloadFailed  Key = "download_failed"
+	Extracting      Key = "extracting"
+	Processing      Key = "processing"
+	Collecting      Key = "collecting"
+	Muxing          Key = "muxing"
+	ReplyRequired   Key = "reply_required"
+	PrivateHistory  Key = "private_history"
+	VideoStickers   Key = "video_stickers"
+	Maintenance     Key = "maintenance"
+	QueueFull       Key = "queue_full"
+	TooOften        Key = "too_often"
+	LanguageCurrent Key = "language_current"
+	LanguageSet     Key = "language_set"
+	LanguageUnknown Key = "language_unknown"
+	Settings        Key = "settings"
+	SetStrength     Key = "set_strength"
+	SetCaptions     Key = "set_captions"
+	SetAudio        Key = "set_audio"
+	SetReply        Key = "set_reply"
+	SetProgress     Key = "set_progress"
+	SetLanguage     Key = "set_language"
+	ValueDistorted  Key = "value_distorted"
+	ValueOriginal   Key = "value_original"
+	ValueOn         Key = "value_on"
+	ValueOff        Key = "value_off"
+	ValueAuto       Key = "value_auto"
+	AdminsOnly      Key = "admins_only"
+	SetAuto         Key = "set_auto"
+	SetAutoChance   Key = "set_auto_chance"
+	Stickers        Key = "stickers"
+	GIFs            Key = "gifs"
+	Photos          Key = "photos"
+	Videos          Key = "videos"
+	VideoNotes      Key = "video_notes"
+	Voices          Key = "voices"
+	AlbumPartFailed Key = "album_part_failed"
+	SetSound        Key = "set_sound"
+	SetText         Key = "set_text"
+	BadArgument     Key = "bad_argument"
+	Trimmed         Key = "trimmed"
+	BadRange        Key = "bad_range"
+	NoSpace         Key = "no_space"
+	Again           Key = "again"
+	Stronger        Key = "stronger"
+	Weaker          Key = "weaker"
+	Delete          Key = "delete"
+	NotYours        Key = "not_yours"
+	JobExpired      Key = "job_expired"
+	StillFailing    Key = "still_failing"
+)
+
+const (
+	English = "en"
+	Russian = "ru"
+
+	// Default is used whenever we don't have a translation for the user's language
+	Default = English
+)
+
+var catalog = map[string]map[Key]string{
+	English: {
+		Failed:          "Failed",
+		TooLong:         "Senpai, it's too long..",
+		TooBig:          "Senpai, it's too big..",
+		Queued:          "Your message has been queued",
+		NotEnoughRights: "The bot does not have enough rights to send media to your chat",
+		NotSupported:    "Not supported yet, sorry",
+		Start:           "Send me a picture, a sticker, a voice message, a song, a video[note] or a GIF and I'll distort it",
+		RateLimited:     "Please, not so often. Try again in %d seconds",
+		Downloading:     "Downloading...",
+		DownloadFailed:  "Failed to download media",
+		Extracting:      "Extracting frames...",
+		Processing:      "Processing frames...",
+		Collecting:      "Collecting frames...",
+		Muxing:          "Muxing frames with sound back together...",
+		ReplyRequired:   "You need to reply with this command to the media you want distorted.",
+		PrivateHistory:  "You might also need to make chat history visible for new members if your group is private.",
+		VideoStickers:   "You can go vote for this suggestion, for .webm stickers handling to become somewhat tolerable https://bugs.telegram.org/c/14858",
+		Maintenance:     "The server is on temporary maintenance, no new videos are being processed at the moment, try again later",
+		QueueFull:       "There are too many items queued already, try again later",
+		TooOften:        "You're distorting videos too often, wait until the previous ones have been processed",
+		LanguageCurrent: "Current language: %s\nAvailable: %s\nUse /lang <code> to change it or /lang auto to follow your Telegram settings",
+		LanguageSet:     "Language set to %s",
+		LanguageUnknown: "Unknown language. Available: %s",
+		Settings:        "Settings for this chat",
+		SetStrength:     "Strength: %d/%d",
+		SetCaptions:     "Captions: %s",
+		SetAudio:        "Video sound: %s",
+		SetReply:        "Reply to the original: %s",
+		SetProgress:     "Progress messages: %s",
+		SetLanguage:     "Language: %s",
+		ValueDistorted:  "distorted",
+		ValueOriginal:   "original",
+		ValueOn:         "on",
+		ValueOff:        "off",
+		ValueAuto:       "auto",
+		AdminsOnly:      "Only chat admins can change the settings",
+		SetAuto:         "Auto-distort: %s",
+		SetAutoChance:   "Chance: %d%%",
+		Stickers:        "Stickers",
+		GIFs:            "GIFs",
+		Photos:          "Photos",
+		Videos:          "Videos",
+		VideoNotes:      "Video notes",
+		Voices:          "Voice",
+		AlbumPartFailed: "Couldn't distort %d out of %d items",
+		SetSound:        "Sound: %s",
+		SetText:         "Text: %s",
+		BadArgument:     "Don't know what %s means. Try something like /distort voice=robot, /distort text=zalgo, /distort 0:30-0:50 or /distort x3\nSound presets: %s\nText modes: %s",
+		Trimmed:         "✂️ Trimmed to %s-%s",
+		BadRange:        "That's past the end, it's only %s long",
+		NoSpace:         "I'm running out of disk space at the moment, try again a bit later",
+		Again:           "🎲 Again",
+		Stronger:        "➕ Stronger",
+		Weaker:          "➖ Weaker",
+		Delete:          "🗑 Delete",
+		NotYours:        "Only the one who asked for it can do that",
+		JobExpired:      "That was too long ago, send it again",
+		StillFailing:    "Still failing at %s stage: %s",
+	},
+	Russian: {
+		Failed:          "Не получилось",
+		TooLong:         "Семпай, оно слишком длинное..",
+		TooBig:          "Семпай, оно слишком большое..",
+		Queued:          "Ваше сообщение поставлено в очередь",
+		NotEnoughRights: "У бота недостаточно прав, чтобы отправлять медиа в этот чат",
+		NotSupported:    "Пока не поддерживается, извините",
+		Start:           "Отправь мне картинку, стикер, голосовое, песню, видео[сообщение] или гифку, и я их искажу",
+		RateLimited:     "Пожалуйста, не так часто. Попробуйте снова через %d секунд",
+		Downloading:     "Скачиваю...",
+		DownloadFailed:  "Не удалось скачать медиа",
+		Extracting:      "Извлекаю кадры...",
+		Processing:      "Обрабатываю кадры...",
+		Collecting:      "Собираю кадры...",
+		Muxing:          "Склеиваю кадры со звуком...",
+		ReplyRequired:   "Эту команду нужно отправить ответом на медиа, которое вы хотите исказить.",
+		PrivateHistory:  "Если группа приватная, возможно, нужно сделать историю чата видимой для новых участников.",
+		VideoStickers:   "Можно проголосовать за это предложение, чтобы обработка .webm стикеров стала хоть сколько-то терпимой https://bugs.telegram.org/c/14858",
+		Maintenance:     "Сервер на временном обслуживании, новые видео сейчас не обрабатываются, попробуйте позже",
+		QueueFull:       "В очереди уже слишком много всего, попробуйте позже",
+		TooOften:        "Вы искажаете видео слишком часто, дождитесь обработки предыдущих",
+		LanguageCurrent: "Текущий язык: %s\nДоступные: %s\nИспользуйте /lang <код>, чтобы сменить его, или /lang auto, чтобы брать язык из настроек Telegram",
+		LanguageSet:     "Язык изменён на %s",
+		LanguageUnknown: "Неизвестный язык. Доступные: %s",
+		Settings:        "Настройки этого чата",
+		SetStrength:     "Сила: %d/%d",
+		SetCaptions:     "Подписи: %s",
+		SetAudio:        "Звук видео: %s",
+		SetReply:        "Ответ на оригинал: %s",
+		SetProgress:     "Сообщения о прогрессе: %s",
+		SetLanguage:     "Язык: %s",
+		ValueDistorted:  "искажаются",
+		ValueOriginal:   "оригинал",
+		ValueOn:         "вкл",
+		ValueOff:        "выкл",
+		ValueAuto:       "авто",
+		AdminsOnly:      "Менять настройки могут только админы чата",
+		SetAuto:         "Автоискажение: %s",
+		SetAutoChance:   "Шанс: %d%%",
+		Stickers:        "Стикеры",
+		GIFs:            "Гифки",
+		Photos:          "Фото",
+		Videos:          "Видео",
+		VideoNotes:      "Кружочки",
+		Voices:          "Голосовые",
+		AlbumPartFailed: "Не получилось исказить %d из %d",
+		SetSound:        "Звук: %s",
+		SetText:         "Текст: %s",
+		BadArgument:     "Не знаю, что значит %s. Попробуйте что-нибудь вроде /distort voice=robot, /distort text=zalgo, /distort 0:30-0:50 или /distort x3\nЗвуковые пресеты: %s\nРежимы текста: %s",
+		Trimmed:         "✂️ Обрезано до %s-%s",
+		BadRange:        "Это уже после конца, там всего %s",
+		NoSpace:         "У меня сейчас заканчивается место на диске, попробуйте чуть позже",
+		Again:           "🎲 Ещё раз",
+		Stronger:        "➕ Сильнее",
+		Weaker:          "➖ Слабее",
+		Delete:          "🗑 Удалить",
+		NotYours:        "Это может сделать только тот, кто просил",
+		JobExpired:      "Это было слишком давно, пришлите ещё раз",
+		StillFailing:    "Всё ещё не получается на этапе %s: %s",
+	},
+}
+
+// Languages lists the codes of all available translations, default first
+var Languages = []string{English, Russian}
+
+// Normalize turns a Telegram language code (e.g. "ru-RU") into a supported catalog language,
+// falling back to Default if there's no translation
+func Normalize(code string) string {
+	code = strings.ToLower(code)
+	if i := strings.IndexAny(code, "-_"); i != -1 {
+		code = code[:i]
+	}
+	if _, ok := catalog[code]; ok {
+		return code
+	}
+	return Default
+}
+
+// IsSupported reports whether there's a translation for exactly this language code
+func IsSupported(code string) bool {
+	_, ok := catalog[code]
+	return ok
+}
+
+// Get returns the message for the key in the given language, formatted with args if any
+func Get(lang string, key Key, args ...any) string {
+	message, ok := catalog[Normalize(lang)][key]
+	if !ok {
+		message = catalog[Default][key]
+	}
+	if len(args) > 0 {
+		return fmt.Sprintf(message, args...)
+	}
+	return message
+}
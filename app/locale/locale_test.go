@@ -0,0 +1,30 @@
+package locale
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNormalize(t *testing.T) {
+	assert.Equal(t, Russian, Normalize("ru"))
+	assert.Equal(t, Russian, Normalize("ru-RU"))
+	assert.Equal(t, English, Normalize("en_US"))
+	assert.Equal(t, Default, Normalize("de"))
+	assert.Equal(t, Default, Normalize(""))
+}
+
+func TestGet(t *testing.T) {
+	assert.Equal(t, "Failed", Get("", Failed))
+	assert.Equal(t, "Не получилось", Get("ru-RU", Failed))
+	assert.Equal(t, "Please, not so often. Try again in 5 seconds", Get(English, RateLimited, 5))
+}
+
+func TestCatalogIsComplete(t *testing.T) {
+	for _, lang := range Languages {
+		for key := range catalog[Default] {
+			_, ok := catalog[lang][key]
+			assert.Truef(t, ok, "%s is missing %s", lang, key)
+		}
+	}
+}
@@ -7,9 +7,9 @@ import (
 )
 
 func TestHonestJobQueue_InsertionOrder(t *testing.T) {
-	hjq := NewHonestJobQueue(50, []int64{})
+	hjq := NewJobQueue(NewHonestScheduler())
 	for id := int64(1); id < 4; id++ {
-		hjq.Push(id, func() {})
+		hjq.PushTier(id, 1, RegularTier, func() {})
 	}
 	assert.Equal(t, 3, hjq.Len())
 	for id := int64(1); id < 4; id++ {
@@ -20,17 +20,17 @@ func TestHonestJobQueue_InsertionOrder(t *testing.T) {
 }
 
 func TestHonestJobQueue_RepeatUsers(t *testing.T) {
-	hjq := NewHonestJobQueue(50, []int64{})
+	hjq := NewJobQueue(NewHonestScheduler())
 
 	// three jobs by user 1
 	for i := 0; i < 3; i++ {
-		hjq.Push(1, func() {})
+		hjq.PushTier(1, 1, RegularTier, func() {})
 	}
 	// one job from user 3
-	hjq.Push(3, func() {})
+	hjq.PushTier(3, 1, RegularTier, func() {})
 	// two jobs from user 2
 	for i := 0; i < 2; i++ {
-		hjq.Push(2, func() {})
+		hjq.PushTier(2, 1, RegularTier, func() {})
 	}
 
 	assert.Equal(t, 6, hjq.Len())
@@ -42,3 +42,47 @@ func TestHonestJobQueue_RepeatUsers(t *testing.T) {
 
 	assert.Equal(t, []int64{1, 3, 2, 1, 2, 1}, poppedIDs)
 }
+
+// threeJobs is a budget of three jobs of cost 1, small enough to run into in the tests
+var threeJobs = Tier{Level: TierRegular, Share: 1, Budget: 3}
+
+func TestHonestJobQueue_Weighted(t *testing.T) {
+	hjq := NewJobQueue(NewHonestScheduler())
+
+	// user 1 asks for a triple distortion, that's as much as three jobs, so the next one has to wait
+	assert.NoError(t, hjq.PushTier(1, 3, threeJobs, func() {}))
+	assert.ErrorIs(t, hjq.PushTier(1, 1, threeJobs, func() {}), ErrTooOften)
+	hjq.PushTier(2, 1, RegularTier, func() {})
+	hjq.PushTier(2, 1, RegularTier, func() {})
+	hjq.PushTier(3, 1, RegularTier, func() {})
+
+	poppedIDs := make([]int64, 0, 4)
+	for i := 0; i < 4; i++ {
+		poppedIDs = append(poppedIDs, hjq.Pop().userID)
+	}
+	assert.Equal(t, []int64{1, 2, 3, 2}, poppedIDs)
+}
+
+func TestHonestJobQueue_DrainedUserStartsOver(t *testing.T) {
+	hjq := NewJobQueue(NewHonestScheduler())
+	// user 1 had a couple of long videos, which are done by now
+	hjq.PushTier(1, 900, RegularTier, func() {})
+	hjq.PushTier(1, 900, RegularTier, func() {})
+	hjq.PushTier(1, 1, RegularTier, func() {})
+	hjq.PushTier(2, 1, RegularTier, func() {})
+	poppedIDs := make([]int64, 0, 6)
+	for i := 0; i < 4; i++ {
+		poppedIDs = append(poppedIDs, hjq.Pop().userID)
+	}
+	assert.Equal(t, []int64{1, 2, 1, 1}, poppedIDs, "the queued work counts")
+
+	hjq.PushTier(1, 1, RegularTier, func() {})
+	hjq.PushTier(2, 1, RegularTier, func() {})
+	hjq.PushTier(2, 1, RegularTier, func() {})
+	poppedIDs = poppedIDs[:0]
+	for i := 0; i < 3; i++ {
+		poppedIDs = append(poppedIDs, hjq.Pop().userID)
+	}
+	assert.Equal(t, []int64{1, 2, 2}, poppedIDs, "but not the work that's done")
+	assert.Empty(t, hjq.scheduler.(*HonestScheduler).users)
+}
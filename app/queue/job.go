@@ -1,20 +1,47 @@
 package queue
 
-import "time"
+const (
+	TierPriority = 0  // chats that skip the line, in one way or another depending on the scheduler
+	TierRegular  = 10 // everybody else. The levels in between are for whatever tiers the caller comes up with
+)
+
+// Tier is how the jobs of a chat are treated in the queue
+type Tier struct {
+	Level  int     // Lower levels go first, see the schedulers for what exactly that means
+	Share  float64 // How much of the workers' time the chat gets relative to the others under FairScheduler
+	Budget float64 // How much a chat may have queued at once, in the same units as the cost, see JobQueue.PushTier. No limit if 0
+}
+
+// The budgets are up to whoever estimates the costs, these only place the jobs in the queue
+var (
+	PriorityTier = Tier{Level: TierPriority, Share: 4}
+	RegularTier  = Tier{Level: TierRegular, Share: 1}
+)
 
 type Job struct {
-	runnable      func()    // The job itself
-	userID        int64     // ID of the user. Used to calculate priority
-	priority      int       // The priority of the item in the queue. Lesser numbers mean bigger priority. Calculated by the HonestJobQueue
-	insertionTime time.Time // Needed to maintain insertion-order for items with equal priority.
+	runnable func()  // The job itself
+	userID   int64   // ID of the user. Used to calculate priority
+	cost     float64 // How much work the job is, in frames as the bot estimates it, see distorters.JobCost. Never below 1
+	tier     int     // The level of the tier, TierPriority and TierRegular or anything in between
+	share    float64 // The share of the tier
+	seq      uint64  // Order of arrival. Maintains insertion-order for items with equal priority
+
+	priority float64 // The priority of the item in the queue. Lesser numbers mean bigger priority. Calculated by HonestScheduler
+	start    float64 // Virtual start and finish times, calculated by FairScheduler
+	finish   float64 //
 }
 
-func newJob(userID int64, priority int, runnable func()) Job {
-	return Job{
-		runnable:      runnable,
-		userID:        userID,
-		priority:      priority,
-		insertionTime: time.Now(),
+func newJob(userID int64, cost float64, tier Tier, runnable func()) *Job {
+	share := tier.Share
+	if share <= 0 {
+		share = 1
+	}
+	return &Job{
+		runnable: runnable,
+		userID:   userID,
+		cost:     max(cost, 1),
+		tier:     tier.Level,
+		share:    share,
 	}
 }
 
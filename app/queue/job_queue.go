@@ -0,0 +1,113 @@
+package queue
+
+import (
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+var (
+	ErrMaintenance = errors.New("The server is on temporary maintenance, no new videos are being processed at the moment, try again later")
+	ErrQueueFull   = errors.New("There are too many items queued already, try again later")
+	ErrTooOften    = errors.New("You're distorting videos too often, wait until the previous ones have been processed")
+)
+
+const maxQueued = 2000 // jobs in total
+
+// JobQueue Wraps a Scheduler to make it thread-safe. Takes care of the limits, the bans and the maintenance,
+// leaving the order of the jobs to the scheduler
+type JobQueue struct {
+	mu          *sync.RWMutex
+	scheduler   Scheduler
+	users       map[int64]float64 // Tracks the cost of the jobs per-user currently in the queue, checked against their Tier.Budget
+	banned      map[int64]any     // Drop jobs from these users
+	maintenance bool
+}
+
+func NewJobQueue(scheduler Scheduler) *JobQueue {
+	return &JobQueue{
+		mu:        &sync.RWMutex{},
+		scheduler: scheduler,
+		users:     make(map[int64]float64),
+		banned:    make(map[int64]any),
+	}
+}
+
+// BanUser This will "ban" the user (if they were impatient and banned the bot first)
+// causing their jobs to be dropped when they pop up. The ban is lifted as soon as they send something again
+func (q *JobQueue) BanUser(userID int64) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.banned[userID] = nil
+}
+
+func (q *JobQueue) Len() int {
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+
+	return q.scheduler.Len()
+}
+
+func (q *JobQueue) Stats() (int, int) {
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+
+	return q.scheduler.Len(), len(q.users)
+}
+
+func (q *JobQueue) Pop() *Job {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for {
+		job := q.scheduler.Pop()
+		if job == nil {
+			return nil
+		}
+		q.users[job.userID] -= job.cost
+		if q.users[job.userID] < 1 { // every job costs at least 1, anything less is a rounding error
+			delete(q.users, job.userID)
+		}
+		if _, banned := q.banned[job.userID]; !banned {
+			return job
+		}
+	}
+}
+
+func (q *JobQueue) ToggleMaintenance() bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.maintenance = !q.maintenance
+
+	return q.maintenance
+}
+
+// PushTier queues a job that takes that much work, which is what the scheduler orders the jobs by
+// and what counts towards the budget of the user's tier. A user with nothing queued can always queue one job,
+// however costly
+func (q *JobQueue) PushTier(userID int64, cost float64, tier Tier, runnable func()) error {
+	cost = max(cost, 1)
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.maintenance {
+		return ErrMaintenance
+	}
+
+	if q.scheduler.Len() > maxQueued {
+		return ErrQueueFull
+	}
+	if queued := q.users[userID]; tier.Budget > 0 && queued > 0 && queued+cost > tier.Budget {
+		return ErrTooOften
+	}
+
+	// if a user sent us a message then we're clearly unbanned
+	delete(q.banned, userID)
+
+	q.users[userID] += cost
+	q.scheduler.Push(newJob(userID, cost, tier, runnable))
+
+	return nil
+}
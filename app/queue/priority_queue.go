@@ -9,7 +9,7 @@ func (pq PriorityQueue) Len() int { return len(pq) }
 
 func (pq PriorityQueue) Less(i, j int) bool {
 	if pq[i].priority == pq[j].priority {
-		return pq[i].insertionTime.Before(pq[j].insertionTime)
+		return pq[i].seq < pq[j].seq
 	}
 	return pq[i].priority < pq[j].priority
 }
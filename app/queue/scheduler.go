@@ -0,0 +1,260 @@
+package queue
+
+import (
+	"container/heap"
+	"fmt"
+	"sort"
+)
+
+// Scheduler decides which of the queued jobs runs next. JobQueue does the locking and the limits, so they don't have to
+type Scheduler interface {
+	Push(job *Job)
+	Pop() *Job // nil if there's nothing queued
+	Len() int
+}
+
+// The schedulers, by the names they're picked with in the config
+const (
+	PolicyFIFO   = "fifo"
+	PolicyHonest = "honest"
+	PolicyFair   = "fair"
+	PolicyTiers  = "tiers"
+
+	DefaultPolicy = PolicyHonest
+)
+
+// NewScheduler creates the scheduler by its name, DefaultPolicy if the name is empty
+func NewScheduler(policy string) (Scheduler, error) {
+	if policy == "" {
+		policy = DefaultPolicy
+	}
+	switch policy {
+	case PolicyFIFO:
+		return NewFIFOScheduler(), nil
+	case PolicyHonest:
+		return NewHonestScheduler(), nil
+	case PolicyFair:
+		return NewFairScheduler(), nil
+	case PolicyTiers:
+		return NewTierScheduler(), nil
+	}
+	return nil, fmt.Errorf("unknown scheduler %q, expected one of %s, %s, %s or %s",
+		policy, PolicyFIFO, PolicyHonest, PolicyFair, PolicyTiers)
+}
+
+// FIFOScheduler runs the jobs in the order they came in, no matter who sent them
+type FIFOScheduler struct {
+	jobs []*Job
+}
+
+func NewFIFOScheduler() *FIFOScheduler {
+	return &FIFOScheduler{}
+}
+
+func (s *FIFOScheduler) Push(job *Job) {
+	s.jobs = append(s.jobs, job)
+}
+
+func (s *FIFOScheduler) Pop() *Job {
+	if len(s.jobs) == 0 {
+		return nil
+	}
+	job := s.jobs[0]
+	s.jobs[0] = nil
+	s.jobs = s.jobs[1:]
+	return job
+}
+
+func (s *FIFOScheduler) Len() int {
+	return len(s.jobs)
+}
+
+// HonestScheduler It ain't much, but it's an honest job.jpg
+// The more work the user has queued, the further back the next job goes. Every time one of their jobs gets popped
+// the rest of them move up, but also re-enter the queue behind everybody else with the same priority. Only the work
+// that's still queued counts, so whatever ran before doesn't hold the user back once their queue drains.
+// The work of the tiers with bigger shares counts for less, and TierPriority always goes first. The levels in between
+// are no different from TierRegular here, only their share sets them apart
+type HonestScheduler struct {
+	queue PriorityQueue
+	users map[int64]float64 // Tracks the cost of the jobs per-user currently in the queue. Used to calculate priority
+	seq   uint64
+}
+
+func NewHonestScheduler() *HonestScheduler {
+	return &HonestScheduler{
+		users: make(map[int64]float64),
+	}
+}
+
+func (s *HonestScheduler) Push(job *Job) {
+	job.priority = s.users[job.userID] / job.share
+	if job.tier <= TierPriority {
+		// not very honest of an honest job queue, but I don't care, I'm not waiting with everybody else
+		job.priority = -1
+	}
+	s.users[job.userID] += job.cost
+	s.seq++
+	job.seq = s.seq
+	heap.Push(&s.queue, job)
+}
+
+func (s *HonestScheduler) Pop() *Job {
+	if s.queue.Len() == 0 {
+		return nil
+	}
+	job := heap.Pop(&s.queue).(*Job)
+	if s.updatePriorities(job.userID, job.cost) {
+		s.users[job.userID] -= job.cost
+	} else {
+		// only the work that's still queued counts, once it's all gone the user starts from scratch
+		delete(s.users, job.userID)
+	}
+	return job
+}
+
+// updatePriorities moves the rest of the user's jobs up, reporting whether they have anything queued at all
+func (s *HonestScheduler) updatePriorities(userID int64, cost float64) bool {
+	var moved []*Job
+	for _, job := range s.queue {
+		if job.userID == userID {
+			moved = append(moved, job)
+		}
+	}
+	if len(moved) == 0 {
+		return false
+	}
+	// I don't want very active users to get stuck forever with lower priority, but I DO want them to "re-enter" the queue
+	sort.Slice(moved, func(i, j int) bool {
+		return moved[i].seq < moved[j].seq
+	})
+	for _, job := range moved {
+		if job.tier > TierPriority {
+			job.priority -= cost / job.share
+		}
+		s.seq++
+		job.seq = s.seq
+	}
+	heap.Init(&s.queue)
+	return true
+}
+
+func (s *HonestScheduler) Len() int {
+	return s.queue.Len()
+}
+
+// FairScheduler is weighted fair queuing over the cost of the jobs: every user gets their share of the workers' time,
+// no matter how many jobs they queue or how heavy those are, with the share set by their tier. Jobs are ordered by
+// their virtual finish time, which is how much work the user has had done up until and including this job, divided
+// by their share. A long 1080p video goes behind a dozen of somebody else's short GIFs, not just behind one.
+// The virtual clock follows the finish time of the job that was popped last, so that somebody who comes back after
+// a break doesn't get to catch up on everything they missed (self-clocked fair queuing)
+type FairScheduler struct {
+	queue      fairQueue
+	lastFinish map[int64]float64 // the virtual finish time of the last job queued by the user
+	virtual    float64
+	seq        uint64
+}
+
+func NewFairScheduler() *FairScheduler {
+	return &FairScheduler{
+		lastFinish: make(map[int64]float64),
+	}
+}
+
+func (s *FairScheduler) Push(job *Job) {
+	job.start = max(s.virtual, s.lastFinish[job.userID])
+	job.finish = job.start + job.cost/job.share
+	s.lastFinish[job.userID] = job.finish
+	s.seq++
+	job.seq = s.seq
+	heap.Push(&s.queue, job)
+}
+
+func (s *FairScheduler) Pop() *Job {
+	if s.queue.Len() == 0 {
+		return nil
+	}
+	job := heap.Pop(&s.queue).(*Job)
+	s.virtual = max(s.virtual, job.finish)
+	// whoever is all caught up starts from the virtual clock next time anyway
+	for userID, finish := range s.lastFinish {
+		if finish <= s.virtual {
+			delete(s.lastFinish, userID)
+		}
+	}
+	return job
+}
+
+func (s *FairScheduler) Len() int {
+	return s.queue.Len()
+}
+
+// fairQueue orders the jobs by their virtual finish time, keeping the insertion order for the equal ones
+type fairQueue []*Job
+
+func (q fairQueue) Len() int { return len(q) }
+
+func (q fairQueue) Less(i, j int) bool {
+	if q[i].finish == q[j].finish {
+		return q[i].seq < q[j].seq
+	}
+	return q[i].finish < q[j].finish
+}
+
+func (q fairQueue) Swap(i, j int) {
+	q[i], q[j] = q[j], q[i]
+}
+
+func (q *fairQueue) Push(x any) {
+	*q = append(*q, x.(*Job))
+}
+
+func (q *fairQueue) Pop() any {
+	old := *q
+	last := len(old) - 1
+	item := old[last]
+	old[last] = nil
+	*q = old[:last]
+	return item
+}
+
+// TierScheduler runs the jobs of the higher tiers strictly before the lower ones, in the order they came in
+// within the tier. The lower tiers wait for as long as the higher ones have anything queued
+type TierScheduler struct {
+	tiers map[int]*FIFOScheduler
+	order []int // the tiers that have ever had jobs, highest (smallest number) first
+	len   int
+}
+
+func NewTierScheduler() *TierScheduler {
+	return &TierScheduler{
+		tiers: make(map[int]*FIFOScheduler),
+	}
+}
+
+func (s *TierScheduler) Push(job *Job) {
+	tier, ok := s.tiers[job.tier]
+	if !ok {
+		tier = NewFIFOScheduler()
+		s.tiers[job.tier] = tier
+		s.order = append(s.order, job.tier)
+		sort.Ints(s.order)
+	}
+	tier.Push(job)
+	s.len++
+}
+
+func (s *TierScheduler) Pop() *Job {
+	for _, tier := range s.order {
+		if job := s.tiers[tier].Pop(); job != nil {
+			s.len--
+			return job
+		}
+	}
+	return nil
+}
+
+func (s *TierScheduler) Len() int {
+	return s.len
+}
@@ -0,0 +1,308 @@
+package queue
+
+import (
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// arrival is a job showing up in the simulation
+type arrival struct {
+	at     int // in time units, a job of cost 1 takes one of them to run
+	userID int64
+	cost   int
+	tier   int
+}
+
+// simulation is what happened when the arrivals went through the scheduler with a single worker
+type simulation struct {
+	waits map[int64][]int // how long each of the user's jobs waited to start, in the order they ran
+	order []int64         // who got the worker, one entry per job
+	ends  []int           // when each of those jobs was done
+}
+
+func simulate(s Scheduler, arrivals []arrival) simulation {
+	sort.SliceStable(arrivals, func(i, j int) bool {
+		return arrivals[i].at < arrivals[j].at
+	})
+	result := simulation{waits: make(map[int64][]int)}
+	arrivedAt := make(map[*Job]int)
+	now, next := 0, 0
+	for next < len(arrivals) || s.Len() > 0 {
+		for ; next < len(arrivals) && arrivals[next].at <= now; next++ {
+			a := arrivals[next]
+			job := newJob(a.userID, float64(a.cost), tierAt(a.tier), func() {})
+			arrivedAt[job] = a.at
+			s.Push(job)
+		}
+		job := s.Pop()
+		if job == nil {
+			now = arrivals[next].at // idle until somebody shows up
+			continue
+		}
+		result.waits[job.userID] = append(result.waits[job.userID], now-arrivedAt[job])
+		result.order = append(result.order, job.userID)
+		now += int(job.cost)
+		result.ends = append(result.ends, now)
+	}
+	return result
+}
+
+func tierAt(level int) Tier {
+	switch level {
+	case TierPriority:
+		return PriorityTier
+	case TierRegular:
+		return RegularTier
+	}
+	// something in between
+	return Tier{Level: level, Share: 2}
+}
+
+func (s simulation) maxWait(userID int64) int {
+	longest := 0
+	for _, wait := range s.waits[userID] {
+		longest = max(longest, wait)
+	}
+	return longest
+}
+
+// longestGap is the most jobs in a row that ran while the user had to wait, within the first n jobs
+func (s simulation) longestGap(userID int64, n int) int {
+	longest, gap := 0, 0
+	for _, id := range s.order[:n] {
+		if id == userID {
+			gap = 0
+			continue
+		}
+		gap++
+		longest = max(longest, gap)
+	}
+	return longest
+}
+
+// done is how many of the user's jobs were done by then
+func (s simulation) done(userID int64, by int) int {
+	count := 0
+	for i, id := range s.order {
+		if id == userID && s.ends[i] <= by {
+			count++
+		}
+	}
+	return count
+}
+
+func burst(userID int64, at, count, tier int) []arrival {
+	arrivals := make([]arrival, count)
+	for i := range arrivals {
+		arrivals[i] = arrival{at: at, userID: userID, cost: 1, tier: tier}
+	}
+	return arrivals
+}
+
+// one user dumps a lot of jobs at once, then three others come by with a job each
+func heavyUserArrivals() []arrival {
+	arrivals := burst(1, 0, 20, TierRegular)
+	for userID := int64(2); userID <= 4; userID++ {
+		arrivals = append(arrivals, arrival{at: int(userID) - 1, userID: userID, cost: 1, tier: TierRegular})
+	}
+	return arrivals
+}
+
+func TestFIFOSchedulerMakesEverybodyWait(t *testing.T) {
+	result := simulate(NewFIFOScheduler(), heavyUserArrivals())
+	require.Len(t, result.order, 23)
+	for userID := int64(2); userID <= 4; userID++ {
+		assert.GreaterOrEqual(t, result.maxWait(userID), 17, "stuck behind the whole burst")
+	}
+}
+
+func TestFairSchedulersDontLetOneUserHogTheQueue(t *testing.T) {
+	for name, scheduler := range map[string]Scheduler{
+		PolicyHonest: NewHonestScheduler(),
+		PolicyFair:   NewFairScheduler(),
+	} {
+		result := simulate(scheduler, heavyUserArrivals())
+		require.Len(t, result.order, 23, name)
+		for userID := int64(2); userID <= 4; userID++ {
+			// nobody should wait for more than one job from everybody else
+			assert.LessOrEqual(t, result.maxWait(userID), 3, "%s: user %d", name, userID)
+		}
+		assert.LessOrEqual(t, result.longestGap(1, 10), 3, "%s: the heavy user still gets their turns", name)
+	}
+}
+
+func TestHonestSchedulerPriorityGoesFirst(t *testing.T) {
+	arrivals := append(burst(1, 0, 10, TierRegular), arrival{at: 5, userID: 2, cost: 1, tier: TierPriority})
+	result := simulate(NewHonestScheduler(), arrivals)
+	assert.Equal(t, 0, result.maxWait(2))
+}
+
+func TestFairSchedulerShares(t *testing.T) {
+	arrivals := append(burst(1, 0, 50, TierPriority), burst(2, 0, 50, TierRegular)...)
+	result := simulate(NewFairScheduler(), arrivals)
+	require.Len(t, result.order, 100)
+	priority := 0
+	for _, userID := range result.order[:25] {
+		if userID == 1 {
+			priority++
+		}
+	}
+	// 4 to 1, give or take the rounding
+	assert.InDelta(t, 20, priority, 1)
+	assert.LessOrEqual(t, result.longestGap(2, 50), 4, "the regular tier is slower, but never starved")
+}
+
+func TestFairSchedulerWeights(t *testing.T) {
+	// a triple distortion takes as long as three ordinary jobs, and waits accordingly
+	arrivals := []arrival{{at: 0, userID: 1, cost: 3, tier: TierRegular}, {at: 0, userID: 1, cost: 3, tier: TierRegular}}
+	arrivals = append(arrivals, burst(2, 0, 6, TierRegular)...)
+	result := simulate(NewFairScheduler(), arrivals)
+	assert.Equal(t, []int64{2, 2, 1, 2, 2, 2, 1, 2}, result.order)
+}
+
+func TestFairSchedulerDoesntSaveUpCredit(t *testing.T) {
+	// user 2 was away while user 1 was busy, and shouldn't get to make up for all of that time once back
+	arrivals := append(burst(1, 0, 30, TierRegular), burst(2, 20, 10, TierRegular)...)
+	result := simulate(NewFairScheduler(), arrivals)
+	assert.LessOrEqual(t, result.longestGap(1, 30), 1, "taking turns once both are queued")
+}
+
+// one user queues a few long videos, the other one a bunch of short GIFs
+func heavyJobArrivals() []arrival {
+	arrivals := make([]arrival, 0, 25)
+	for i := 0; i < 5; i++ {
+		arrivals = append(arrivals, arrival{at: 0, userID: 1, cost: 20, tier: TierRegular})
+	}
+	return append(arrivals, burst(2, 0, 20, TierRegular)...)
+}
+
+func TestFairSchedulerSharesTimeNotJobs(t *testing.T) {
+	result := simulate(NewFairScheduler(), heavyJobArrivals())
+	// both get to use the worker for 20 time units out of the first 40
+	assert.Equal(t, 1, result.done(1, 40))
+	assert.GreaterOrEqual(t, result.done(2, 40), 19)
+	assert.LessOrEqual(t, result.waits[1][0], 20, "and the long videos aren't starved either")
+
+	// the honest one takes turns job by job, so the GIFs mostly wait for the videos
+	result = simulate(NewHonestScheduler(), heavyJobArrivals())
+	assert.LessOrEqual(t, result.done(2, 40), 2)
+}
+
+func TestTierSchedulerStarvesLowerTiers(t *testing.T) {
+	arrivals := []arrival{{at: 0, userID: 2, cost: 1, tier: TierRegular}}
+	// the priority chat keeps the worker busy for 20 time units
+	for at := 0; at < 20; at++ {
+		arrivals = append(arrivals, arrival{at: at, userID: 1, cost: 1, tier: TierPriority})
+	}
+	result := simulate(NewTierScheduler(), arrivals)
+	// but no longer than that
+	assert.Equal(t, 20, result.maxWait(2))
+	assert.Equal(t, 0, result.maxWait(1))
+}
+
+func TestTierSchedulerIsFIFOWithinTier(t *testing.T) {
+	result := simulate(NewTierScheduler(), heavyUserArrivals())
+	assert.Equal(t, simulate(NewFIFOScheduler(), heavyUserArrivals()).order, result.order)
+}
+
+func TestNewScheduler(t *testing.T) {
+	for _, policy := range []string{PolicyFIFO, PolicyHonest, PolicyFair, PolicyTiers, ""} {
+		scheduler, err := NewScheduler(policy)
+		assert.NoError(t, err, policy)
+		assert.NotNil(t, scheduler, policy)
+	}
+	_, err := NewScheduler("lottery")
+	assert.Error(t, err)
+}
+
+func TestDefaultSchedulerRunsPriorityFirst(t *testing.T) {
+	scheduler, err := NewScheduler("")
+	assert.NoError(t, err)
+	q := NewJobQueue(scheduler)
+	assert.NoError(t, q.PushTier(1, 1, RegularTier, func() {}))
+	assert.NoError(t, q.PushTier(7, 1, PriorityTier, func() {}))
+	assert.Equal(t, int64(7), q.Pop().userID, "the priority chat shouldn't wait behind the regular one")
+	assert.Equal(t, int64(1), q.Pop().userID)
+}
+
+func TestJobQueue_Banned(t *testing.T) {
+	q := NewJobQueue(NewFIFOScheduler())
+	q.PushTier(1, 1, RegularTier, func() {})
+	q.PushTier(2, 1, RegularTier, func() {})
+	q.PushTier(1, 1, RegularTier, func() {})
+	q.BanUser(1)
+	assert.Equal(t, int64(2), q.Pop().userID)
+	assert.Nil(t, q.Pop(), "banned jobs get dropped")
+	jobs, users := q.Stats()
+	assert.Equal(t, 0, jobs)
+	assert.Equal(t, 0, users)
+
+	assert.NoError(t, q.PushTier(1, 1, RegularTier, func() {}), "sending something again lifts the ban")
+	assert.Equal(t, int64(1), q.Pop().userID)
+}
+
+func TestJobQueue_Maintenance(t *testing.T) {
+	q := NewJobQueue(NewFIFOScheduler())
+	assert.True(t, q.ToggleMaintenance())
+	assert.ErrorIs(t, q.PushTier(1, 1, RegularTier, func() {}), ErrMaintenance)
+	assert.ErrorIs(t, q.PushTier(7, 1, PriorityTier, func() {}), ErrMaintenance, "no matter the tier")
+	assert.False(t, q.ToggleMaintenance())
+	assert.NoError(t, q.PushTier(1, 1, RegularTier, func() {}))
+}
+
+func TestJobQueue_Budget(t *testing.T) {
+	q := NewJobQueue(NewFairScheduler())
+	regular := Tier{Level: TierRegular, Share: 1, Budget: 100}
+	assert.NoError(t, q.PushTier(1, 60, regular, func() {}))
+	assert.NoError(t, q.PushTier(1, 40, regular, func() {}))
+	assert.ErrorIs(t, q.PushTier(1, 1, regular, func() {}), ErrTooOften)
+	assert.NoError(t, q.PushTier(2, 250, regular, func() {}), "one job always fits, no matter how big")
+	assert.ErrorIs(t, q.PushTier(2, 1, regular, func() {}), ErrTooOften)
+	for i := 0; i < 3; i++ {
+		assert.NoError(t, q.PushTier(7, 100, PriorityTier, func() {}), "priority chats have no budget")
+	}
+
+	for q.Len() > 0 {
+		if job := q.Pop(); job.userID == 1 {
+			break
+		}
+	}
+	assert.NoError(t, q.PushTier(1, 60, regular, func() {}), "there's room once a job is out of the queue")
+}
+
+func TestSchedulersInBetweenTiers(t *testing.T) {
+	// a regular user hogs the queue, then somebody in a tier in between and somebody with priority show up
+	arrivals := append(burst(1, 0, 40, TierRegular), burst(2, 1, 40, TierRegular-1)...)
+	arrivals = append(arrivals, burst(3, 1, 40, TierPriority)...)
+
+	result := simulate(NewTierScheduler(), arrivals)
+	assert.Equal(t, 40, result.done(3, 41), "priority first")
+	assert.Equal(t, 40, result.done(2, 81), "then the one in between")
+
+	result = simulate(NewHonestScheduler(), arrivals)
+	assert.Equal(t, 40, result.done(3, 41), "priority first")
+	assert.InDelta(t, result.done(1, 81), result.done(2, 81), 1, "the level in between waits with everybody else")
+
+	// shares 4, 2 and 1: of the 28 jobs after everybody shows up, that's 16, 8 and 4
+	result = simulate(NewFairScheduler(), arrivals)
+	assert.InDelta(t, 16, result.done(3, 29), 1)
+	assert.InDelta(t, 8, result.done(2, 29), 1)
+	assert.InDelta(t, 1+4, result.done(1, 29), 1)
+}
+
+func TestJobQueue_PushTier(t *testing.T) {
+	q := NewJobQueue(NewTierScheduler())
+	assert.NoError(t, q.PushTier(1, 1, Tier{Level: 5, Share: 2, Budget: 2}, func() {}))
+	assert.NoError(t, q.PushTier(1, 1, Tier{Level: 5, Share: 2, Budget: 2}, func() {}))
+	assert.ErrorIs(t, q.PushTier(1, 1, Tier{Level: 5, Share: 2, Budget: 2}, func() {}), ErrTooOften)
+	assert.NoError(t, q.PushTier(2, 1, RegularTier, func() {}))
+	assert.NoError(t, q.PushTier(3, 1, PriorityTier, func() {}))
+	var popped []int64
+	for q.Len() > 0 {
+		popped = append(popped, q.Pop().userID)
+	}
+	assert.Equal(t, []int64{3, 1, 1, 2}, popped)
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"errors"
+	"math/rand"
+	"strconv"
+	"time"
+
+	tb "gopkg.in/telebot.v3"
+
+	"github.com/graynk/distortioner/distorters"
+	"github.com/graynk/distortioner/locale"
+	"github.com/graynk/distortioner/stats"
+)
+
+const (
+	optionsKey   = "options"
+	resultUnique = "result"
+	jobTTL       = 7 * 24 * time.Hour // the buttons under the results stop working after that
+)
+
+// what each button under the result does, sent as the first part of the callback data. The second one is the job ID
+const (
+	resultAgain    = "again"
+	resultStronger = "stronger"
+	resultWeaker   = "weaker"
+	resultDelete   = "delete"
+)
+
+// withOptions makes the handlers use exactly these options, instead of the chat settings and the arguments
+func withOptions(c tb.Context, options distorters.Options) tb.Context {
+	c.Set(optionsKey, options)
+	return c
+}
+
+// strippedMessage keeps only what's needed to distort the media in the message again
+func strippedMessage(m *tb.Message) *tb.Message {
+	stripped := &tb.Message{
+		ID:              m.ID,
+		Chat:            &tb.Chat{ID: m.Chat.ID, Type: m.Chat.Type},
+		Caption:         m.Caption,
+		CaptionEntities: m.CaptionEntities,
+		Photo:           m.Photo,
+		Animation:       m.Animation,
+		Sticker:         m.Sticker,
+		Video:           m.Video,
+		VideoNote:       m.VideoNote,
+		Voice:           m.Voice,
+		Audio:           m.Audio,
+		Document:        m.Document,
+	}
+	if m.Sender != nil {
+		stripped.Sender = &tb.User{ID: m.Sender.ID, LanguageCode: m.Sender.LanguageCode}
+	}
+	return stripped
+}
+
+func resultMarkup(lang string, id int64, options distorters.Options) *tb.ReplyMarkup {
+	markup := &tb.ReplyMarkup{}
+	jobID := strconv.FormatInt(id, 10)
+	button := func(key locale.Key, action string) tb.Btn {
+		return markup.Data(locale.Get(lang, key), resultUnique, action, jobID)
+	}
+	strength := options.Strength
+	if strength < distorters.MinStrength || strength > distorters.MaxStrength {
+		strength = distorters.DefaultStrength
+	}
+	var buttons []tb.Btn
+	if strength > distorters.MinStrength {
+		buttons = append(buttons, button(locale.Weaker, resultWeaker))
+	}
+	buttons = append(buttons, button(locale.Again, resultAgain))
+	if strength < distorters.MaxStrength {
+		buttons = append(buttons, button(locale.Stronger, resultStronger))
+	}
+	markup.Inline(markup.Row(buttons...), markup.Row(button(locale.Delete, resultDelete)))
+	return markup
+}
+
+// resultButtons adds the buttons for distorting the same media again to the options of the message with the result
+func (d DistorterBot) resultButtons(c tb.Context, opts ...interface{}) []interface{} {
+	m := c.Message()
+	if isRetry(c) || m.Media() == nil {
+		return opts
+	}
+	job := stats.JobDescriptor{
+		Message: strippedMessage(m),
+		Options: d.options(c),
+	}
+	// anonymous admins and channels all look the same, so it's up to the admins then
+	if m.Sender != nil && m.SenderChat == nil {
+		job.UserID = m.Sender.ID
+	}
+	id, err := d.db.SaveJob(job)
+	if err != nil {
+		d.logger.Error(err)
+		return opts
+	}
+	return append(opts, resultMarkup(d.language(c), id, job.Options))
+}
+
+// canPress is true for the one who asked for the result, or for the admins if we don't know who that was
+func (d DistorterBot) canPress(c tb.Context, job stats.JobDescriptor) bool {
+	sender := c.Callback().Sender
+	if job.UserID != 0 {
+		return sender != nil && sender.ID == job.UserID
+	}
+	return d.canChangeSettings(c.Bot(), c.Callback().Message.Chat, sender, nil)
+}
+
+func (d DistorterBot) handleResultCallback(c tb.Context) error {
+	callback := c.Callback()
+	args := c.Args()
+	if callback.Message == nil || len(args) < 2 {
+		return c.Respond()
+	}
+	lang := d.language(c)
+	id, err := strconv.ParseInt(args[1], 10, 64)
+	if err != nil {
+		return c.Respond()
+	}
+	job, err := d.db.GetJob(id)
+	if errors.Is(err, stats.ErrNoSuchJob) {
+		return c.Respond(&tb.CallbackResponse{Text: locale.Get(lang, locale.JobExpired)})
+	} else if err != nil {
+		d.logger.Error(err)
+		return c.Respond(&tb.CallbackResponse{Text: locale.Get(lang, locale.Failed)})
+	}
+	if !d.canPress(c, job) {
+		return c.Respond(&tb.CallbackResponse{Text: locale.Get(lang, locale.NotYours)})
+	}
+
+	options := job.Options
+	switch args[0] {
+	case resultDelete:
+		err = c.Bot().Delete(callback.Message)
+		if err != nil {
+			d.logger.Error(err)
+		}
+		return c.Respond()
+	case resultAgain:
+		options.Seed = rand.Int63()
+	case resultStronger:
+		options.Strength = min(options.Strength+1, distorters.MaxStrength)
+	case resultWeaker:
+		options.Strength = max(options.Strength-1, distorters.MinStrength)
+	default:
+		return c.Respond()
+	}
+	// videos take a while, so the button shouldn't keep spinning until they're done
+	err = c.Respond()
+	if err != nil {
+		d.logger.Error(err)
+	}
+	return d.distortMessage(d.withSettingsOf(withOptions(c.Bot().NewContext(tb.Update{Message: job.Message}), options), c))
+}
@@ -0,0 +1,276 @@
+package main
+
+import (
+	"slices"
+	"strconv"
+
+	tb "gopkg.in/telebot.v3"
+
+	"github.com/graynk/distortioner/distorters"
+	"github.com/graynk/distortioner/locale"
+	"github.com/graynk/distortioner/stats"
+)
+
+const (
+	settingsUnique = "settings"
+	settingsKey    = "settings"
+)
+
+// what each settings button does, sent as the first part of the callback data
+const (
+	settingStrength = "strength"
+	settingCaption  = "caption"
+	settingAudio    = "audio"
+	settingReply    = "reply"
+	settingProgress = "progress"
+	settingLanguage = "language"
+	settingAuto     = "auto"
+	settingChance   = "chance"
+	settingMedia    = "media"
+	settingSound    = "sound"
+	settingText     = "text"
+)
+
+var mediaTypeNames = map[stats.MediaType]locale.Key{
+	stats.MediaSticker:   locale.Stickers,
+	stats.MediaAnimation: locale.GIFs,
+	stats.MediaPhoto:     locale.Photos,
+	stats.MediaVideo:     locale.Videos,
+	stats.MediaVideoNote: locale.VideoNotes,
+	stats.MediaVoice:     locale.Voices,
+}
+
+func (d DistorterBot) chatSettings(chatID int64) stats.ChatSettings {
+	settings, err := d.db.GetSettings(chatID)
+	if err != nil {
+		d.logger.Error(err)
+		return stats.DefaultSettings()
+	}
+	return settings
+}
+
+// ApplySettingsMiddleware loads the settings and the tier of the chat once for the whole update, see settings and tier
+func (d DistorterBot) ApplySettingsMiddleware(h tb.HandlerFunc) tb.HandlerFunc {
+	return func(c tb.Context) error {
+		d.settings(c)
+		d.tier(c)
+		return h(c)
+	}
+}
+
+// settings returns the settings of the chat the update came from, only going to the database the first time.
+// The contexts made up outside of the handlers, like the ones for the album items, load them on their own
+func (d DistorterBot) settings(c tb.Context) stats.ChatSettings {
+	if settings, ok := c.Get(settingsKey).(stats.ChatSettings); ok {
+		return settings
+	}
+	settings := stats.DefaultSettings()
+	if chat := c.Chat(); chat != nil {
+		settings = d.chatSettings(chat.ID)
+	}
+	c.Set(settingsKey, settings)
+	return settings
+}
+
+// withSettingsOf carries the settings and the tier over to another update from the same chat
+func (d DistorterBot) withSettingsOf(c, from tb.Context) tb.Context {
+	c.Set(settingsKey, d.settings(from))
+	c.Set(tierKey, d.tier(from))
+	return c
+}
+
+// saveSettings stores the settings of the chat the update came from, so that the rest of the update sees them as well
+func (d DistorterBot) saveSettings(c tb.Context, settings stats.ChatSettings) error {
+	err := d.db.SaveSettings(c.Chat().ID, settings)
+	if err != nil {
+		return err
+	}
+	c.Set(settingsKey, settings)
+	return nil
+}
+
+func (d DistorterBot) options(c tb.Context) distorters.Options {
+	options, ok := c.Get(optionsKey).(distorters.Options)
+	if !ok {
+		settings := d.settings(c)
+		tier := d.tier(c)
+		options = arguments(c).apply(distorters.Options{
+			Strength:    settings.Strength,
+			SoundPreset: settings.SoundPreset,
+			TextMode:    settings.TextMode,
+			FrameBudget: tier.FrameBudget,
+			MaxDuration: tier.MaxVideoDuration,
+		})
+	}
+	if clip, ok := clipOf(c); ok {
+		options.Start, options.Length = clip.Start, clip.End-clip.Start
+	}
+	return options
+}
+
+// canChangeSettings is true for everyone in private chats and only for admins in groups
+func (d DistorterBot) canChangeSettings(b *tb.Bot, chat *tb.Chat, user *tb.User, senderChat *tb.Chat) bool {
+	if chat.Type == tb.ChatPrivate {
+		return true
+	}
+	// anonymous admins write on behalf of the group itself
+	if senderChat != nil && senderChat.ID == chat.ID {
+		return true
+	}
+	if user == nil {
+		return false
+	}
+	member, err := b.ChatMemberOf(chat, user)
+	if err != nil {
+		d.logger.Error(err)
+		return false
+	}
+	return member.Role == tb.Administrator || member.Role == tb.Creator
+}
+
+func onOff(lang string, value bool, on, off locale.Key) string {
+	if value {
+		return locale.Get(lang, on)
+	}
+	return locale.Get(lang, off)
+}
+
+func settingsMarkup(lang string, settings stats.ChatSettings, group bool) *tb.ReplyMarkup {
+	markup := &tb.ReplyMarkup{}
+	button := func(text string, data ...string) tb.Btn {
+		return markup.Data(text, settingsUnique, data...)
+	}
+	language := settings.Language
+	if language == "" {
+		language = locale.Get(lang, locale.ValueAuto)
+	}
+	rows := []tb.Row{
+		markup.Row(
+			button("➖", settingStrength, "-"),
+			button(locale.Get(lang, locale.SetStrength, settings.Strength, distorters.MaxStrength), settingStrength),
+			button("➕", settingStrength, "+"),
+		),
+		markup.Row(button(locale.Get(lang, locale.SetCaptions,
+			onOff(lang, settings.DistortCaption, locale.ValueDistorted, locale.ValueOriginal)), settingCaption)),
+		markup.Row(button(locale.Get(lang, locale.SetAudio,
+			onOff(lang, settings.DistortAudio, locale.ValueDistorted, locale.ValueOriginal)), settingAudio)),
+		markup.Row(button(locale.Get(lang, locale.SetSound, settings.SoundPreset), settingSound)),
+		markup.Row(button(locale.Get(lang, locale.SetText, settings.TextMode), settingText)),
+		markup.Row(button(locale.Get(lang, locale.SetReply,
+			onOff(lang, settings.Reply, locale.ValueOn, locale.ValueOff)), settingReply)),
+		markup.Row(button(locale.Get(lang, locale.SetProgress,
+			onOff(lang, settings.ShowProgress, locale.ValueOn, locale.ValueOff)), settingProgress)),
+		markup.Row(button(locale.Get(lang, locale.SetLanguage, language), settingLanguage)),
+	}
+	if group {
+		// everything gets distorted in private chats anyway
+		rows = append(rows, markup.Row(
+			button(locale.Get(lang, locale.SetAuto, onOff(lang, settings.AutoDistort, locale.ValueOn, locale.ValueOff)), settingAuto),
+			button(locale.Get(lang, locale.SetAutoChance, settings.AutoChance), settingChance),
+		))
+		mediaButtons := make([]tb.Btn, 0, len(stats.MediaTypes))
+		for _, mediaType := range stats.MediaTypes {
+			mark := "❌ "
+			if settings.AutoMedia&mediaType != 0 {
+				mark = "✅ "
+			}
+			mediaButtons = append(mediaButtons,
+				button(mark+locale.Get(lang, mediaTypeNames[mediaType]), settingMedia, strconv.Itoa(int(mediaType))))
+		}
+		rows = append(rows, markup.Split(3, mediaButtons)...)
+	}
+	markup.Inline(rows...)
+	return markup
+}
+
+// applySetting changes the settings according to the pressed button
+func applySetting(settings stats.ChatSettings, args []string) stats.ChatSettings {
+	if len(args) == 0 {
+		return settings
+	}
+	switch args[0] {
+	case settingStrength:
+		if len(args) < 2 {
+			break
+		}
+		if args[1] == "+" && settings.Strength < distorters.MaxStrength {
+			settings.Strength++
+		} else if args[1] == "-" && settings.Strength > distorters.MinStrength {
+			settings.Strength--
+		}
+	case settingCaption:
+		settings.DistortCaption = !settings.DistortCaption
+	case settingAudio:
+		settings.DistortAudio = !settings.DistortAudio
+	case settingSound:
+		next := slices.Index(distorters.SoundPresets, settings.SoundPreset) + 1
+		settings.SoundPreset = distorters.SoundPresets[next%len(distorters.SoundPresets)]
+	case settingText:
+		next := slices.Index(distorters.TextModes, settings.TextMode) + 1
+		settings.TextMode = distorters.TextModes[next%len(distorters.TextModes)]
+	case settingReply:
+		settings.Reply = !settings.Reply
+	case settingProgress:
+		settings.ShowProgress = !settings.ShowProgress
+	case settingLanguage:
+		// cycles auto -> en -> ru -> ... -> auto
+		next := slices.Index(locale.Languages, settings.Language) + 1
+		if settings.Language == "" {
+			next = 0
+		}
+		if next >= len(locale.Languages) {
+			settings.Language = ""
+		} else {
+			settings.Language = locale.Languages[next]
+		}
+	case settingAuto:
+		settings.AutoDistort = !settings.AutoDistort
+	case settingChance:
+		next := slices.Index(stats.AutoChances, settings.AutoChance) + 1
+		settings.AutoChance = stats.AutoChances[next%len(stats.AutoChances)]
+	case settingMedia:
+		if len(args) < 2 {
+			break
+		}
+		mediaType, err := strconv.Atoi(args[1])
+		if err != nil {
+			break
+		}
+		settings.AutoMedia ^= stats.MediaType(mediaType)
+	}
+	return settings
+}
+
+func (d DistorterBot) handleSettings(c tb.Context) error {
+	m := c.Message()
+	settings := d.settings(c)
+	lang := d.language(c)
+	return c.Reply(locale.Get(lang, locale.Settings), settingsMarkup(lang, settings, m.FromGroup()))
+}
+
+func (d DistorterBot) handleSettingsCallback(c tb.Context) error {
+	callback := c.Callback()
+	if callback.Message == nil {
+		return c.Respond()
+	}
+	chat := callback.Message.Chat
+	if !d.canChangeSettings(c.Bot(), chat, callback.Sender, nil) {
+		return c.Respond(&tb.CallbackResponse{Text: locale.Get(d.language(c), locale.AdminsOnly)})
+	}
+	previous := d.settings(c)
+	settings := applySetting(previous, c.Args())
+	if settings == previous {
+		return c.Respond()
+	}
+	err := d.saveSettings(c, settings)
+	if err != nil {
+		d.logger.Error(err)
+		return c.Respond(&tb.CallbackResponse{Text: locale.Get(d.language(c), locale.Failed)})
+	}
+	lang := d.language(c)
+	_, err = c.Bot().Edit(callback.Message, locale.Get(lang, locale.Settings), settingsMarkup(lang, settings, callback.Message.FromGroup()))
+	if err != nil {
+		d.logger.Error(err)
+	}
+	return c.Respond()
+}
@@ -0,0 +1,95 @@
+package stats
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/graynk/distortioner/distorters"
+)
+
+// CachedResult is the distorted media that was sent already, so it can be sent again by its file ID
+type CachedResult struct {
+	Kind    string // what it was sent as, see MessageType
+	FileID  string
+	Trimmed bool // the rest is the part of the video that got distorted, if it was trimmed
+	Start   float64
+	End     float64
+}
+
+func migrateCache(db *sql.DB) error {
+	_, err := db.Exec(`create table if not exists result_cache(
+		key text not null primary key,
+		version integer not null,
+		kind text not null,
+		file_id text not null,
+		trimmed integer not null default 0,
+		start real not null default 0,
+		end real not null default 0,
+		created integer not null,
+		used integer not null);`)
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec(`create index if not exists usedidx on result_cache(used asc);`)
+	if err != nil {
+		return err
+	}
+	// whatever the older pipeline produced is not what we'd produce now
+	_, err = db.Exec(`delete from result_cache where version != ?;`, distorters.PipelineVersion)
+	return err
+}
+
+// GetCached returns the result cached for the key, if there is one
+func (d *DistortionerDB) GetCached(key string) (CachedResult, bool, error) {
+	var result CachedResult
+	err := d.db.QueryRow(`select kind, file_id, trimmed, start, end from result_cache where key = ? and version = ?;`,
+		key, distorters.PipelineVersion).
+		Scan(&result.Kind, &result.FileID, &result.Trimmed, &result.Start, &result.End)
+	if errors.Is(err, sql.ErrNoRows) {
+		return result, false, nil
+	} else if err != nil {
+		return result, false, err
+	}
+	_, err = d.db.Exec(`update result_cache set used = ? where key = ?;`, time.Now().Unix(), key)
+	return result, true, err
+}
+
+func (d *DistortionerDB) SaveCached(key string, result CachedResult) error {
+	now := time.Now().Unix()
+	_, err := d.db.Exec(`insert into result_cache(key, version, kind, file_id, trimmed, start, end, created, used)
+		values(?, ?, ?, ?, ?, ?, ?, ?, ?)
+		on conflict(key) do update set
+			version = excluded.version,
+			kind = excluded.kind,
+			file_id = excluded.file_id,
+			trimmed = excluded.trimmed,
+			start = excluded.start,
+			end = excluded.end,
+			created = excluded.created,
+			used = excluded.used;`,
+		key, distorters.PipelineVersion, result.Kind, result.FileID, result.Trimmed, result.Start, result.End, now, now)
+	return err
+}
+
+func (d *DistortionerDB) DeleteCached(key string) error {
+	_, err := d.db.Exec(`delete from result_cache where key = ?;`, key)
+	return err
+}
+
+// PruneCache removes the results older than the TTL, then the least recently used ones until at most size are left.
+// Returns how many were removed
+func (d *DistortionerDB) PruneCache(ttl time.Duration, size int) (int64, error) {
+	expired, err := d.db.Exec(`delete from result_cache where created < ?;`, time.Now().Add(-ttl).Unix())
+	if err != nil {
+		return 0, err
+	}
+	removed, _ := expired.RowsAffected()
+	evicted, err := d.db.Exec(`delete from result_cache where key in (
+		select key from result_cache order by used desc limit -1 offset ?);`, size)
+	if err != nil {
+		return removed, err
+	}
+	evictedCount, _ := evicted.RowsAffected()
+	return removed + evictedCount, nil
+}
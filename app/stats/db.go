@@ -3,6 +3,7 @@ package stats
 import (
 	"database/sql"
 	"os"
+	"strings"
 	"time"
 
 	_ "github.com/mattn/go-sqlite3"
@@ -25,7 +26,9 @@ type Stat struct {
 	Video        int
 	VideoNote    int
 	Voice        int
+	Audio        int
 	Photo        int
+	Document     int
 	Text         int
 }
 
@@ -47,7 +50,9 @@ const statQuery = `
 		   count(case when type = 'video' then type end) as video,
 		   count(case when type = 'videonote' then type end) as videonote,
 		   count(case when type = 'voice' then type end) as voice,
+		   count(case when type = 'audio' then type end) as audio,
 		   count(case when type = 'photo' then type end) as photo,
+		   count(case when type = 'document' then type end) as document,
 		   count(case when type = 'text' then type end) as text
 	from stats
 	where date >= datetime('now', ?, 'localtime') and datetime('now','localtime');
@@ -76,6 +81,22 @@ func InitDB(logger *zap.SugaredLogger) *DistortionerDB {
 	if err != nil {
 		logger.Fatal(err)
 	}
+	err = migrateSettings(db)
+	if err != nil {
+		logger.Fatal(err)
+	}
+	err = migrateCache(db)
+	if err != nil {
+		logger.Fatal(err)
+	}
+	err = migrateJobs(db)
+	if err != nil {
+		logger.Fatal(err)
+	}
+	err = migratePriority(db)
+	if err != nil {
+		logger.Fatal(err)
+	}
 	insertStat, err := db.Prepare(`insert into stats(user_id, is_group_chat, date, type) values(?, ?, ?, ?);`)
 	if err != nil {
 		logger.Fatal(err)
@@ -92,32 +113,40 @@ func (d *DistortionerDB) SaveStat(message *tb.Message, isCommand bool) {
 		d.SaveStat(message.ReplyTo, false)
 		return
 	}
-	messageType := "text"
+	_, err := d.insert.Exec(message.Chat.ID, message.FromGroup(), time.Now(), MessageType(message))
+	if err != nil {
+		d.logger.Error(err)
+	}
+}
+
+// MessageType is what the message was sent as, the way it's counted in the stats
+func MessageType(message *tb.Message) string {
 	switch {
 	case message.Animation != nil:
-		messageType = "animation"
+		return "animation"
 	case message.Video != nil:
-		messageType = "video"
+		return "video"
 	case message.VideoNote != nil:
-		messageType = "videonote"
+		return "videonote"
 	case message.Voice != nil:
-		messageType = "voice"
+		return "voice"
+	case message.Audio != nil, message.Document != nil && strings.HasPrefix(message.Document.MIME, "audio/"):
+		return "audio"
 	case message.Sticker != nil:
-		messageType = "sticker"
+		return "sticker"
 	case message.Photo != nil:
-		messageType = "photo"
-	}
-	_, err := d.insert.Exec(message.Chat.ID, message.FromGroup(), time.Now(), messageType)
-	if err != nil {
-		d.logger.Error(err)
+		return "photo"
+	case message.Document != nil:
+		return "document"
 	}
+	return "text"
 }
 
 func (d *DistortionerDB) GetStat(period Period) (Stat, error) {
 	row := d.db.QueryRow(statQuery, period)
 	var stat Stat
 	err := row.Scan(&stat.Interactions, &stat.Chats, &stat.Groups, &stat.Sticker, &stat.Animation, &stat.Video,
-		&stat.VideoNote, &stat.Voice, &stat.Photo, &stat.Text)
+		&stat.VideoNote, &stat.Voice, &stat.Audio, &stat.Photo, &stat.Document, &stat.Text)
 	return stat, err
 }
 
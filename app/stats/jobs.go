@@ -0,0 +1,77 @@
+package stats
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"time"
+
+	tb "gopkg.in/telebot.v3"
+
+	"github.com/graynk/distortioner/distorters"
+)
+
+var ErrNoSuchJob = errors.New("no such job")
+
+// JobDescriptor remembers what was distorted and how, so that it can be done again from the buttons under the result
+type JobDescriptor struct {
+	ID      int64
+	UserID  int64       // who asked for it, 0 if they're anonymous
+	Message *tb.Message // the original media, stripped down to what's needed to distort it again
+	Options distorters.Options
+}
+
+func migrateJobs(db *sql.DB) error {
+	_, err := db.Exec(`create table if not exists jobs(
+		id integer not null primary key,
+		chat_id integer not null,
+		user_id integer not null,
+		message text not null,
+		options text not null,
+		created integer not null);`)
+	return err
+}
+
+// SaveJob stores the descriptor, returning its ID
+func (d *DistortionerDB) SaveJob(job JobDescriptor) (int64, error) {
+	message, err := json.Marshal(job.Message)
+	if err != nil {
+		return 0, err
+	}
+	options, err := json.Marshal(job.Options)
+	if err != nil {
+		return 0, err
+	}
+	result, err := d.db.Exec(`insert into jobs(chat_id, user_id, message, options, created) values(?, ?, ?, ?, ?);`,
+		job.Message.Chat.ID, job.UserID, message, options, time.Now().Unix())
+	if err != nil {
+		return 0, err
+	}
+	return result.LastInsertId()
+}
+
+func (d *DistortionerDB) GetJob(id int64) (JobDescriptor, error) {
+	job := JobDescriptor{ID: id}
+	var message, options []byte
+	err := d.db.QueryRow(`select user_id, message, options from jobs where id = ?;`, id).Scan(&job.UserID, &message, &options)
+	if errors.Is(err, sql.ErrNoRows) {
+		return job, ErrNoSuchJob
+	} else if err != nil {
+		return job, err
+	}
+	err = json.Unmarshal(message, &job.Message)
+	if err != nil {
+		return job, err
+	}
+	err = json.Unmarshal(options, &job.Options)
+	return job, err
+}
+
+// PruneJobs forgets the jobs older than the TTL, the buttons under their results stop working after that
+func (d *DistortionerDB) PruneJobs(ttl time.Duration) (int64, error) {
+	result, err := d.db.Exec(`delete from jobs where created < ?;`, time.Now().Add(-ttl).Unix())
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
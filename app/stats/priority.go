@@ -0,0 +1,70 @@
+package stats
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// ChatTier is the tier a chat was put into with /priority
+type ChatTier struct {
+	ChatID int64
+	Tier   string
+	Added  time.Time
+}
+
+func migratePriority(db *sql.DB) error {
+	_, err := db.Exec(`create table if not exists priority_chats(
+		chat_id integer not null primary key,
+		tier text not null,
+		added integer not null);`)
+	return err
+}
+
+// SetChatTier puts the chat into the tier, taking it out of whatever tier it was in before
+func (d *DistortionerDB) SetChatTier(chatID int64, tier string) error {
+	_, err := d.db.Exec(`insert into priority_chats(chat_id, tier, added) values(?, ?, ?)
+		on conflict(chat_id) do update set tier = excluded.tier, added = excluded.added;`,
+		chatID, tier, time.Now().Unix())
+	return err
+}
+
+// GetChatTier returns the tier of the chat, or false if it was never put into one
+func (d *DistortionerDB) GetChatTier(chatID int64) (string, bool, error) {
+	var tier string
+	err := d.db.QueryRow(`select tier from priority_chats where chat_id = ?;`, chatID).Scan(&tier)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", false, nil
+	}
+	return tier, err == nil, err
+}
+
+// RemoveChatTier takes the chat out of its tier, returning false if it wasn't in one
+func (d *DistortionerDB) RemoveChatTier(chatID int64) (bool, error) {
+	result, err := d.db.Exec(`delete from priority_chats where chat_id = ?;`, chatID)
+	if err != nil {
+		return false, err
+	}
+	removed, err := result.RowsAffected()
+	return removed > 0, err
+}
+
+// ListChatTiers returns all the chats that were put into tiers, the most recent first
+func (d *DistortionerDB) ListChatTiers() ([]ChatTier, error) {
+	rows, err := d.db.Query(`select chat_id, tier, added from priority_chats order by added desc, chat_id;`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var chats []ChatTier
+	for rows.Next() {
+		var chat ChatTier
+		var added int64
+		if err = rows.Scan(&chat.ChatID, &chat.Tier, &added); err != nil {
+			return nil, err
+		}
+		chat.Added = time.Unix(added, 0)
+		chats = append(chats, chat)
+	}
+	return chats, rows.Err()
+}
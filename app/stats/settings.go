@@ -0,0 +1,163 @@
+package stats
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+
+	tb "gopkg.in/telebot.v3"
+
+	"github.com/graynk/distortioner/distorters"
+)
+
+// MediaType is a bit flag for the kinds of media that get distorted automatically in groups
+type MediaType int
+
+const (
+	MediaSticker MediaType = 1 << iota
+	MediaAnimation
+	MediaPhoto
+	MediaVideo
+	MediaVideoNote
+	MediaVoice
+)
+
+// MediaTypes lists all the flags in the order they are shown in /settings
+var MediaTypes = []MediaType{MediaSticker, MediaAnimation, MediaPhoto, MediaVideo, MediaVideoNote, MediaVoice}
+
+// MediaTypeOf returns the flag for the media in the message, or 0 if there is nothing we can distort automatically
+func MediaTypeOf(m *tb.Message) MediaType {
+	switch {
+	case m.Sticker != nil:
+		return MediaSticker
+	case m.Animation != nil:
+		return MediaAnimation
+	case m.Photo != nil:
+		return MediaPhoto
+	case m.Video != nil:
+		return MediaVideo
+	case m.VideoNote != nil:
+		return MediaVideoNote
+	case m.Voice != nil:
+		return MediaVoice
+	}
+	return 0
+}
+
+// AutoChances are the percentages one can pick for the auto-distortion
+var AutoChances = []int{1, 5, 10, 25, 50, 100}
+
+// ChatSettings are the per-chat preferences changed with /settings
+type ChatSettings struct {
+	Strength       int                 // How hard the media gets distorted, see distorters.Options
+	DistortCaption bool                // Distort captions of photos and GIFs, or keep them as-is
+	DistortAudio   bool                // Distort the sound of videos, or keep the original soundtrack
+	Reply          bool                // Reply to the original message, or just send the result to the chat
+	ShowProgress   bool                // Show progress messages for videos and GIFs
+	Language       string              // Language override, empty string means "use the one from Telegram"
+	AutoDistort    bool                // Distort random media in groups without waiting for a command
+	AutoChance     int                 // Percentage of media that gets distorted automatically
+	AutoMedia      MediaType           // Which media gets distorted automatically
+	SoundPreset    string              // Which of distorters.SoundPresets is applied to voice messages, music and videos
+	TextMode       distorters.TextMode // Which of distorters.TextModes is applied to texts and captions
+}
+
+func DefaultSettings() ChatSettings {
+	return ChatSettings{
+		Strength:       distorters.DefaultStrength,
+		DistortCaption: true,
+		DistortAudio:   true,
+		Reply:          true,
+		ShowProgress:   true,
+		AutoChance:     5,
+		AutoMedia:      MediaSticker | MediaAnimation,
+		SoundPreset:    distorters.DefaultSoundPreset,
+		TextMode:       distorters.DefaultTextMode,
+	}
+}
+
+// settingsColumns lists everything that was added to the settings table after it was created.
+// New columns go to the end, so that the existing databases get migrated on startup
+var settingsColumns = []struct {
+	name       string
+	definition string
+}{
+	{"strength", fmt.Sprintf("integer not null default %d", distorters.DefaultStrength)},
+	{"distort_caption", "integer not null default 1"},
+	{"distort_audio", "integer not null default 1"},
+	{"reply", "integer not null default 1"},
+	{"show_progress", "integer not null default 1"},
+	{"auto_distort", "integer not null default 0"},
+	{"auto_chance", "integer not null default 5"},
+	{"auto_media", fmt.Sprintf("integer not null default %d", MediaSticker|MediaAnimation)},
+	{"sound_preset", fmt.Sprintf("text not null default '%s'", distorters.DefaultSoundPreset)},
+	{"text_mode", fmt.Sprintf("text not null default '%s'", distorters.DefaultTextMode)},
+}
+
+func migrateSettings(db *sql.DB) error {
+	_, err := db.Exec(`create table if not exists settings(chat_id integer not null primary key, language text not null default '');`)
+	if err != nil {
+		return err
+	}
+	rows, err := db.Query(`select name from pragma_table_info('settings');`)
+	if err != nil {
+		return err
+	}
+	existing := make(map[string]any)
+	for rows.Next() {
+		var name string
+		if err = rows.Scan(&name); err != nil {
+			rows.Close()
+			return err
+		}
+		existing[name] = nil
+	}
+	rows.Close()
+	for _, column := range settingsColumns {
+		if _, ok := existing[column.name]; ok {
+			continue
+		}
+		_, err = db.Exec(fmt.Sprintf(`alter table settings add column %s %s;`, column.name, column.definition))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetSettings returns the settings for the chat, or the defaults if the chat never changed them
+func (d *DistortionerDB) GetSettings(chatID int64) (ChatSettings, error) {
+	settings := DefaultSettings()
+	err := d.db.QueryRow(`select strength, distort_caption, distort_audio, reply, show_progress, language,
+		auto_distort, auto_chance, auto_media, sound_preset, text_mode
+		from settings where chat_id = ?;`, chatID).
+		Scan(&settings.Strength, &settings.DistortCaption, &settings.DistortAudio, &settings.Reply,
+			&settings.ShowProgress, &settings.Language, &settings.AutoDistort, &settings.AutoChance, &settings.AutoMedia,
+			&settings.SoundPreset, &settings.TextMode)
+	if errors.Is(err, sql.ErrNoRows) {
+		return DefaultSettings(), nil
+	}
+	return settings, err
+}
+
+func (d *DistortionerDB) SaveSettings(chatID int64, settings ChatSettings) error {
+	_, err := d.db.Exec(`insert into settings(chat_id, strength, distort_caption, distort_audio, reply, show_progress, language,
+		auto_distort, auto_chance, auto_media, sound_preset, text_mode)
+		values(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		on conflict(chat_id) do update set
+			strength = excluded.strength,
+			distort_caption = excluded.distort_caption,
+			distort_audio = excluded.distort_audio,
+			reply = excluded.reply,
+			show_progress = excluded.show_progress,
+			language = excluded.language,
+			auto_distort = excluded.auto_distort,
+			auto_chance = excluded.auto_chance,
+			auto_media = excluded.auto_media,
+			sound_preset = excluded.sound_preset,
+			text_mode = excluded.text_mode;`,
+		chatID, settings.Strength, settings.DistortCaption, settings.DistortAudio, settings.Reply,
+		settings.ShowProgress, settings.Language, settings.AutoDistort, settings.AutoChance, settings.AutoMedia,
+		settings.SoundPreset, settings.TextMode)
+	return err
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+
+	tb "gopkg.in/telebot.v3"
+
+	"github.com/graynk/distortioner/distorters"
+	"github.com/graynk/distortioner/queue"
+	"github.com/graynk/distortioner/tools"
+)
+
+const (
+	regularTier  = "regular"
+	priorityTier = "priority"
+
+	tierKey = "tier"
+)
+
+// Tier is what a chat gets from the bot: its place in the queue and its limits
+type Tier struct {
+	Name             string
+	Queue            queue.Tier
+	FrameBudget      int     // see distorters.Cost
+	MaxVideoDuration float64 // seconds, anything longer gets trimmed
+	MaxAudioDuration int     // seconds, anything longer is refused
+	MaxFileSize      int64   // bytes, 0 for whatever the Bot API allows
+	AllowedOverTime  int     // requests per tools.TimePeriodSeconds
+}
+
+// tiers are what chats can be put into with /priority, from the least to the most privileged. Everybody else is regular
+var tiers = []Tier{
+	{
+		Name:             regularTier,
+		Queue:            queue.Tier{Level: queue.TierRegular, Share: 1, Budget: distorters.UserCostBudget},
+		FrameBudget:      distorters.DefaultFrameBudget,
+		MaxVideoDuration: distorters.MaxVideoDuration,
+		MaxAudioDuration: MaxAudioDuration,
+		MaxFileSize:      100_000_000,
+		AllowedOverTime:  tools.AllowedOverTime,
+	},
+	{
+		Name:             "supporter",
+		Queue:            queue.Tier{Level: queue.TierRegular, Share: 2, Budget: 2 * distorters.UserCostBudget},
+		FrameBudget:      distorters.PriorityFrameBudget,
+		MaxVideoDuration: 2 * distorters.MaxVideoDuration,
+		MaxAudioDuration: 2 * MaxAudioDuration,
+		MaxFileSize:      500_000_000,
+		AllowedOverTime:  2 * tools.AllowedOverTime,
+	},
+	{
+		Name:             priorityTier,
+		Queue:            queue.PriorityTier,
+		FrameBudget:      distorters.PriorityFrameBudget,
+		MaxVideoDuration: 3 * distorters.MaxVideoDuration,
+		MaxAudioDuration: 3 * MaxAudioDuration,
+		AllowedOverTime:  5 * tools.AllowedOverTime,
+	},
+}
+
+func findTier(name string) (Tier, bool) {
+	for _, tier := range tiers {
+		if tier.Name == name {
+			return tier, true
+		}
+	}
+	return tiers[0], false
+}
+
+func tierNames() []string {
+	names := make([]string, len(tiers))
+	for i, tier := range tiers {
+		names[i] = tier.Name
+	}
+	return names
+}
+
+func (t Tier) String() string {
+	fileSize := "whatever the Bot API allows"
+	if t.MaxFileSize > 0 {
+		fileSize = fmt.Sprintf("%dMB", t.MaxFileSize/1_000_000)
+	}
+	budget := "unlimited"
+	if t.Queue.Budget > 0 {
+		budget = fmt.Sprintf("%g", t.Queue.Budget)
+	}
+	return fmt.Sprintf("%s: queue level %d, share %g, queued work %s, frame budget %d, videos up to %gs, music up to %ds, files up to %s, %d requests per %ds",
+		t.Name, t.Queue.Level, t.Queue.Share, budget, t.FrameBudget, t.MaxVideoDuration, t.MaxAudioDuration, fileSize,
+		t.AllowedOverTime, tools.TimePeriodSeconds)
+}
+
+// tierOf returns the tier the chat was put into with /priority. The chats from DISTORTIONER_PRIORITY_CHATS
+// that weren't put anywhere are priority, everybody else is regular
+func (d DistorterBot) tierOf(chatID int64) Tier {
+	name, ok, err := d.db.GetChatTier(chatID)
+	if err != nil {
+		d.logger.Error(err)
+	}
+	if _, priority := d.priorityChats[chatID]; !ok && priority {
+		name = priorityTier
+	}
+	// a tier that's gone since the chat was put into it is as good as none
+	tier, _ := findTier(name)
+	return tier
+}
+
+// tier returns the tier of the chat the update came from, only going to the database the first time, see settings
+func (d DistorterBot) tier(c tb.Context) Tier {
+	if tier, ok := c.Get(tierKey).(Tier); ok {
+		return tier
+	}
+	tier := tiers[0]
+	if chat := c.Chat(); chat != nil {
+		tier = d.tierOf(chat.ID)
+	}
+	c.Set(tierKey, tier)
+	return tier
+}
+
+// maxFileSize is the biggest file the chat may send, within what the Bot API allows
+func (d DistorterBot) maxFileSize(c tb.Context) int64 {
+	limit := d.botAPI.MaxDownloadSize()
+	if tier := d.tier(c); tier.MaxFileSize > 0 {
+		limit = min(limit, tier.MaxFileSize)
+	}
+	return limit
+}
+
+// handlePriority lets the admin put chats into tiers: /priority add <chat> <tier>, /priority remove <chat>
+// and /priority list. The changes apply to the next request from the chat
+func (d DistorterBot) handlePriority(c tb.Context) error {
+	if c.Message().Sender.ID != d.adminID {
+		return nil
+	}
+	args := c.Args()
+	usage := fmt.Sprintf("/priority add <chat> <tier>, /priority remove <chat> or /priority list. Tiers: %s",
+		strings.Join(tierNames(), ", "))
+	if len(args) == 0 || args[0] == "list" {
+		return d.listPriority(c)
+	}
+	if len(args) < 2 {
+		return c.Reply(usage)
+	}
+	chatID, err := strconv.ParseInt(args[1], 10, 64)
+	if err != nil {
+		return c.Reply(fmt.Sprintf("%q is not a chat ID. %s", args[1], usage))
+	}
+	switch {
+	case args[0] == "add" && len(args) == 3:
+		tier, ok := findTier(args[2])
+		if !ok {
+			return c.Reply(fmt.Sprintf("There's no %q tier. %s", args[2], usage))
+		}
+		err = d.db.SetChatTier(chatID, tier.Name)
+		if err != nil {
+			d.logger.Error(err)
+			return c.Reply(err.Error())
+		}
+		return c.Reply(fmt.Sprintf("%d is %s now", chatID, tier.Name))
+	case args[0] == "remove" && len(args) == 2:
+		removed, err := d.db.RemoveChatTier(chatID)
+		if err != nil {
+			d.logger.Error(err)
+			return c.Reply(err.Error())
+		}
+		if _, priority := d.priorityChats[chatID]; priority {
+			return c.Reply(fmt.Sprintf("%d is in DISTORTIONER_PRIORITY_CHATS, so it's %s anyway. /priority add %d %s to take it down",
+				chatID, priorityTier, chatID, regularTier))
+		} else if !removed {
+			return c.Reply(fmt.Sprintf("%d wasn't in any tier", chatID))
+		}
+		return c.Reply(fmt.Sprintf("%d is %s now", chatID, regularTier))
+	}
+	return c.Reply(usage)
+}
+
+func (d DistorterBot) listPriority(c tb.Context) error {
+	chats, err := d.db.ListChatTiers()
+	if err != nil {
+		d.logger.Error(err)
+		return c.Reply(err.Error())
+	}
+	lines := make([]string, 0, len(tiers)+len(chats)+len(d.priorityChats)+2)
+	for _, tier := range tiers {
+		lines = append(lines, tier.String())
+	}
+	lines = append(lines, "")
+	listed := make(map[int64]any)
+	for _, chat := range chats {
+		listed[chat.ChatID] = nil
+		lines = append(lines, fmt.Sprintf("%d: %s since %s", chat.ChatID, chat.Tier, chat.Added.Format("2006-01-02")))
+	}
+	fromEnv := make([]int64, 0, len(d.priorityChats))
+	for chatID := range d.priorityChats {
+		if _, ok := listed[chatID]; !ok {
+			fromEnv = append(fromEnv, chatID)
+		}
+	}
+	slices.Sort(fromEnv)
+	for _, chatID := range fromEnv {
+		lines = append(lines, fmt.Sprintf("%d: %s from DISTORTIONER_PRIORITY_CHATS", chatID, priorityTier))
+	}
+	if len(lines) == len(tiers)+1 {
+		lines = append(lines, "Nobody is in any tier yet")
+	}
+	return c.Reply(strings.Join(lines, "\n"))
+}
@@ -0,0 +1,109 @@
+package tools
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	tb "gopkg.in/telebot.v3"
+)
+
+// AlbumCollector keeps track of recent media groups. Telegram sends every item of an album as a separate update,
+// so the items are gathered for a short window before the album gets handled as a whole
+type AlbumCollector struct {
+	mu     sync.Mutex
+	window time.Duration // how long to wait for the rest of the album after the last item arrived
+	ttl    time.Duration // how long to remember the album, so that replies to one item can find the rest
+	albums map[string]*album
+}
+
+type album struct {
+	messages  []*tb.Message
+	updated   time.Time
+	timer     *time.Timer
+	collected bool // the album was already handled, late items won't trigger it again
+}
+
+func NewAlbumCollector(window, ttl time.Duration) *AlbumCollector {
+	return &AlbumCollector{
+		window: window,
+		ttl:    ttl,
+		albums: make(map[string]*album),
+	}
+}
+
+// remember must be called with the lock held
+func (ac *AlbumCollector) remember(m *tb.Message) *album {
+	now := time.Now()
+	for id, a := range ac.albums {
+		if a.timer == nil && now.Sub(a.updated) > ac.ttl {
+			delete(ac.albums, id)
+		}
+	}
+	a, ok := ac.albums[m.AlbumID]
+	if !ok {
+		a = &album{}
+		ac.albums[m.AlbumID] = a
+	}
+	a.updated = now
+	for _, existing := range a.messages {
+		if existing.ID == m.ID {
+			return a
+		}
+	}
+	a.messages = append(a.messages, m)
+	return a
+}
+
+// Remember stores the album item without waiting for the album to be complete
+func (ac *AlbumCollector) Remember(m *tb.Message) {
+	if m == nil || m.AlbumID == "" {
+		return
+	}
+	ac.mu.Lock()
+	defer ac.mu.Unlock()
+
+	ac.remember(m)
+}
+
+// Collect stores the album item and calls done with all the items of the album once no new items
+// arrived for the duration of the window. done is called only once per album, for the item this returns true for
+func (ac *AlbumCollector) Collect(m *tb.Message, done func([]*tb.Message)) bool {
+	ac.mu.Lock()
+	defer ac.mu.Unlock()
+
+	a := ac.remember(m)
+	if a.collected {
+		return false
+	}
+	if a.timer != nil {
+		a.timer.Reset(ac.window)
+		return false
+	}
+	albumID := m.AlbumID
+	a.timer = time.AfterFunc(ac.window, func() {
+		ac.mu.Lock()
+		a.collected = true
+		a.timer = nil
+		ac.mu.Unlock()
+		done(ac.Album(albumID))
+	})
+	return true
+}
+
+// Album returns all the known items of the album in the order they were sent
+func (ac *AlbumCollector) Album(albumID string) []*tb.Message {
+	ac.mu.Lock()
+	defer ac.mu.Unlock()
+
+	a, ok := ac.albums[albumID]
+	if !ok {
+		return nil
+	}
+	messages := make([]*tb.Message, len(a.messages))
+	copy(messages, a.messages)
+	sort.Slice(messages, func(i, j int) bool {
+		return messages[i].ID < messages[j].ID
+	})
+	return messages
+}
@@ -0,0 +1,58 @@
+package tools
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	tb "gopkg.in/telebot.v3"
+)
+
+func TestAlbumCollector_Collect(t *testing.T) {
+	ac := NewAlbumCollector(50*time.Millisecond, time.Minute)
+	collected := make(chan []*tb.Message, 2)
+	done := func(messages []*tb.Message) {
+		collected <- messages
+	}
+	// items can arrive out of order
+	for i, id := range []int{3, 1, 2, 2} {
+		assert.Equal(t, i == 0, ac.Collect(&tb.Message{ID: id, AlbumID: "a"}, done), "only the first item starts the album")
+	}
+	assert.True(t, ac.Collect(&tb.Message{ID: 10, AlbumID: "b"}, done))
+
+	albums := map[string][]int{}
+	for i := 0; i < 2; i++ {
+		messages := <-collected
+		ids := make([]int, 0, len(messages))
+		for _, m := range messages {
+			ids = append(ids, m.ID)
+		}
+		albums[messages[0].AlbumID] = ids
+	}
+	assert.Equal(t, []int{1, 2, 3}, albums["a"])
+	assert.Equal(t, []int{10}, albums["b"])
+
+	// late items are remembered, but don't trigger the album again
+	assert.False(t, ac.Collect(&tb.Message{ID: 4, AlbumID: "a"}, done))
+	select {
+	case <-collected:
+		t.Fatal("album was collected twice")
+	case <-time.After(100 * time.Millisecond):
+	}
+	assert.Len(t, ac.Album("a"), 4)
+}
+
+func TestAlbumCollector_Remember(t *testing.T) {
+	ac := NewAlbumCollector(time.Second, 50*time.Millisecond)
+	ac.Remember(&tb.Message{ID: 2, AlbumID: "a"})
+	ac.Remember(&tb.Message{ID: 1, AlbumID: "a"})
+	ac.Remember(&tb.Message{ID: 1})
+	album := ac.Album("a")
+	assert.Len(t, album, 2)
+	assert.Equal(t, 1, album[0].ID)
+	assert.Nil(t, ac.Album(""))
+
+	time.Sleep(100 * time.Millisecond)
+	ac.Remember(&tb.Message{ID: 3, AlbumID: "b"}) // sweeps the expired ones
+	assert.Nil(t, ac.Album("a"))
+}
@@ -0,0 +1,97 @@
+package tools
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	tb "gopkg.in/telebot.v3"
+)
+
+const (
+	PublicDownloadLimit = 20_000_000    // the public Bot API doesn't give out anything bigger
+	PublicUploadLimit   = 50_000_000    // and doesn't take anything bigger either
+	LocalFileLimit      = 2_000_000_000 // a local Bot API server does both up to 2GB
+
+	publicTimeout = time.Minute
+	localTimeout  = 15 * time.Minute // that's a lot of bytes to push through, even locally
+)
+
+// BotAPI is where the Bot API lives and what it allows
+type BotAPI struct {
+	URL   string // tb.DefaultApiURL if not set
+	Local bool   // the server runs with --local: files come as absolute paths on the filesystem it shares with us
+}
+
+// BotAPIFromEnv reads DISTORTIONER_BOT_API_URL and DISTORTIONER_BOT_API_LOCAL
+func BotAPIFromEnv() (BotAPI, error) {
+	api := BotAPI{URL: os.Getenv("DISTORTIONER_BOT_API_URL")}
+	if value := os.Getenv("DISTORTIONER_BOT_API_LOCAL"); value != "" {
+		local, err := strconv.ParseBool(value)
+		if err != nil {
+			return api, err
+		}
+		api.Local = local
+	}
+	return api, nil
+}
+
+// Settings fills in where the bot should talk to, and how long it should wait for the files to go through
+func (a BotAPI) Settings(settings tb.Settings) tb.Settings {
+	settings.URL = a.URL
+	timeout := publicTimeout
+	if a.Local {
+		timeout = localTimeout
+	}
+	settings.Client = &http.Client{Timeout: timeout}
+	return settings
+}
+
+// MaxDownloadSize is the biggest file that can be taken from the Bot API, in bytes
+func (a BotAPI) MaxDownloadSize() int64 {
+	if a.Local {
+		return LocalFileLimit
+	}
+	return PublicDownloadLimit
+}
+
+// MaxUploadSize is the biggest file that can be sent through the Bot API, in bytes
+func (a BotAPI) MaxUploadSize() int64 {
+	if a.Local {
+		return LocalFileLimit
+	}
+	return PublicUploadLimit
+}
+
+// Download saves the file into filename. A local server hands out absolute paths instead of download links,
+// so in local mode the file is copied straight from there
+func (a BotAPI) Download(b *tb.Bot, file *tb.File, filename string) error {
+	if !a.Local {
+		return b.Download(file, filename)
+	}
+	remote, err := b.FileByID(file.FileID)
+	if err != nil {
+		return err
+	}
+	if !filepath.IsAbs(remote.FilePath) {
+		// not so local after all, e.g. started without --local
+		return b.Download(file, filename)
+	}
+	return CopyFile(remote.FilePath, filename)
+}
+
+// TooBigToUpload reports whether the media about to be sent from the disk is over the upload limit
+func (a BotAPI) TooBigToUpload(toSend interface{}) bool {
+	media, ok := toSend.(tb.Media)
+	if !ok {
+		return false
+	}
+	file := media.MediaFile()
+	if file.FileLocal == "" {
+		return false
+	}
+	stat, err := os.Stat(file.FileLocal)
+	return err == nil && stat.Size() > a.MaxUploadSize()
+}
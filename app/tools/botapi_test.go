@@ -0,0 +1,107 @@
+package tools
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	tb "gopkg.in/telebot.v3"
+)
+
+const testToken = "123:token"
+
+// standInServer pretends to be the Bot API, answering getFile with the path and serving the files over HTTP
+func standInServer(t *testing.T, filePath string, content []byte) *httptest.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/bot"+testToken+"/getFile", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `{"ok":true,"result":{"file_id":"id","file_path":%q}}`, filePath)
+	})
+	mux.HandleFunc("/file/bot"+testToken+"/", func(w http.ResponseWriter, r *http.Request) {
+		w.Write(content)
+	})
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	return server
+}
+
+func newTestBot(t *testing.T, api BotAPI) *tb.Bot {
+	b, err := tb.NewBot(api.Settings(tb.Settings{Token: testToken, Offline: true}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func TestBotAPIDownload(t *testing.T) {
+	server := standInServer(t, "photos/file_1.jpg", []byte("over http"))
+	api := BotAPI{URL: server.URL}
+	output := filepath.Join(t.TempDir(), "downloaded")
+	err := api.Download(newTestBot(t, api), &tb.File{FileID: "id"}, output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	content, err := os.ReadFile(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(content) != "over http" {
+		t.Fatalf("unexpected content %q", content)
+	}
+}
+
+func TestBotAPIDownloadLocal(t *testing.T) {
+	shared := filepath.Join(t.TempDir(), "file_1.jpg")
+	err := os.WriteFile(shared, []byte("from the disk"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := standInServer(t, shared, []byte("over http"))
+	api := BotAPI{URL: server.URL, Local: true}
+	output := filepath.Join(t.TempDir(), "downloaded")
+	err = api.Download(newTestBot(t, api), &tb.File{FileID: "id"}, output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	content, err := os.ReadFile(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(content) != "from the disk" {
+		t.Fatalf("expected the file to be copied from the shared path, got %q", content)
+	}
+}
+
+func TestBotAPILimits(t *testing.T) {
+	public, local := BotAPI{}, BotAPI{Local: true}
+	if public.MaxDownloadSize() != PublicDownloadLimit || public.MaxUploadSize() != PublicUploadLimit {
+		t.Fatal("public limits are off")
+	}
+	if local.MaxDownloadSize() != LocalFileLimit || local.MaxUploadSize() != LocalFileLimit {
+		t.Fatal("local limits are off")
+	}
+
+	filename := filepath.Join(t.TempDir(), "result.mp4")
+	err := os.WriteFile(filename, []byte("small"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if public.TooBigToUpload(&tb.Video{File: tb.FromDisk(filename)}) {
+		t.Fatal("small files should go through")
+	}
+	err = os.Truncate(filename, PublicUploadLimit+1) // sparse, doesn't actually take up the space
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !public.TooBigToUpload(&tb.Video{File: tb.FromDisk(filename)}) {
+		t.Fatal("public Bot API shouldn't take that")
+	}
+	if local.TooBigToUpload(&tb.Video{File: tb.FromDisk(filename)}) {
+		t.Fatal("local Bot API should take that")
+	}
+	if public.TooBigToUpload("just text") {
+		t.Fatal("text is not a file")
+	}
+}
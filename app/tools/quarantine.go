@@ -0,0 +1,195 @@
+package tools
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	failureFile = "failure.json"
+	mediaFile   = "media"
+)
+
+var (
+	ErrNoSuchFailure = errors.New("no such failure")
+	failureID        = regexp.MustCompile(`^[0-9a-f]{8}$`)
+)
+
+// Failure describes media that failed to get distorted. It deliberately knows nothing about who sent it
+type Failure struct {
+	ID      string          `json:"id"`
+	Time    time.Time       `json:"time"`
+	Kind    string          `json:"kind"`            // what it was sent as: photo, video, sticker, etc.
+	MIME    string          `json:"mime,omitempty"`  // what the documents and the music said they were
+	Name    string          `json:"name,omitempty"`  // and the name they came under, so that they go back the same way
+	Stage   string          `json:"stage,omitempty"` // where exactly it failed, if anybody knows
+	Error   string          `json:"error"`
+	Stderr  string          `json:"stderr,omitempty"` // what ffmpeg or magick had to say about it
+	Probe   json.RawMessage `json:"probe,omitempty"`  // what ffprobe knows about the media
+	Options json.RawMessage `json:"options,omitempty"`
+}
+
+// Quarantine keeps failed media around for a while, each in its own directory next to a JSON sidecar
+type Quarantine struct {
+	root string
+	ttl  time.Duration // 0 means forever
+	mu   *sync.Mutex
+}
+
+func NewQuarantine(root string, ttl time.Duration) (*Quarantine, error) {
+	err := os.MkdirAll(root, 0755)
+	if err != nil {
+		return nil, err
+	}
+	return &Quarantine{root: root, ttl: ttl, mu: &sync.Mutex{}}, nil
+}
+
+// Keep moves the file into the quarantine along with the description of the failure.
+// Returns the failure with its ID and time filled in
+func (q *Quarantine) Keep(filename string, failure Failure) (Failure, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	failure.ID = uuid.New().String()[:8]
+	if failure.Time.IsZero() {
+		failure.Time = time.Now()
+	}
+	dir := filepath.Join(q.root, failure.ID)
+	err := os.Mkdir(dir, 0755)
+	if err != nil {
+		return failure, err
+	}
+	err = moveFile(filename, filepath.Join(dir, mediaFile))
+	if err != nil {
+		os.RemoveAll(dir)
+		return failure, err
+	}
+	sidecar, err := json.MarshalIndent(failure, "", "  ")
+	if err != nil {
+		os.RemoveAll(dir)
+		return failure, err
+	}
+	err = os.WriteFile(filepath.Join(dir, failureFile), sidecar, 0644)
+	if err != nil {
+		os.RemoveAll(dir)
+	}
+	return failure, err
+}
+
+// List returns all the failures, latest first
+func (q *Quarantine) List() ([]Failure, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	entries, err := os.ReadDir(q.root)
+	if err != nil {
+		return nil, err
+	}
+	failures := make([]Failure, 0, len(entries))
+	for _, entry := range entries {
+		if !entry.IsDir() || !failureID.MatchString(entry.Name()) {
+			continue
+		}
+		failure, err := q.read(entry.Name())
+		if err != nil {
+			continue // half-written or tampered with, not much to show anyway
+		}
+		failures = append(failures, failure)
+	}
+	sort.Slice(failures, func(i, j int) bool {
+		return failures[i].Time.After(failures[j].Time)
+	})
+	return failures, nil
+}
+
+// Get returns the failure along with the paths to the media and the sidecar
+func (q *Quarantine) Get(id string) (Failure, string, string, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if !failureID.MatchString(id) {
+		return Failure{}, "", "", ErrNoSuchFailure
+	}
+	failure, err := q.read(id)
+	if err != nil {
+		return Failure{}, "", "", err
+	}
+	dir := filepath.Join(q.root, id)
+	return failure, filepath.Join(dir, mediaFile), filepath.Join(dir, failureFile), nil
+}
+
+func (q *Quarantine) read(id string) (Failure, error) {
+	sidecar, err := os.ReadFile(filepath.Join(q.root, id, failureFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return Failure{}, ErrNoSuchFailure
+	} else if err != nil {
+		return Failure{}, err
+	}
+	var failure Failure
+	err = json.Unmarshal(sidecar, &failure)
+	return failure, err
+}
+
+// Expire removes the failures older than the TTL. Returns how many of them were removed
+func (q *Quarantine) Expire(now time.Time) (int, error) {
+	if q.ttl == 0 {
+		return 0, nil
+	}
+	failures, err := q.List()
+	if err != nil {
+		return 0, err
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	removed := 0
+	for _, failure := range failures {
+		if now.Sub(failure.Time) < q.ttl {
+			continue
+		}
+		err = os.RemoveAll(filepath.Join(q.root, failure.ID))
+		if err != nil {
+			return removed, err
+		}
+		removed++
+	}
+	return removed, nil
+}
+
+// moveFile renames the file, falling back to copying when the scratch directory lives on another device (e.g. tmpfs)
+func moveFile(from, to string) error {
+	err := os.Rename(from, to)
+	if err == nil {
+		return nil
+	}
+	err = CopyFile(from, to)
+	if err != nil {
+		return err
+	}
+	return os.Remove(from)
+}
+
+func CopyFile(from, to string) error {
+	source, err := os.Open(from)
+	if err != nil {
+		return err
+	}
+	defer source.Close()
+	destination, err := os.Create(to)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(destination, source)
+	if closeErr := destination.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(to)
+	}
+	return err
+}
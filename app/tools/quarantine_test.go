@@ -0,0 +1,95 @@
+package tools
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestQuarantineKeep(t *testing.T) {
+	quarantine, err := NewQuarantine(filepath.Join(t.TempDir(), "failed"), time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	media := filepath.Join(t.TempDir(), "media")
+	err = os.WriteFile(media, []byte("not really a video"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	kept, err := quarantine.Keep(media, Failure{Kind: "video", Stage: "extract", Error: "exit status 1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = os.Stat(media); !errors.Is(err, os.ErrNotExist) {
+		t.Fatal("media should have been moved")
+	}
+	failure, mediaPath, sidecarPath, err := quarantine.Get(kept.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if failure.Kind != "video" || failure.Stage != "extract" || failure.Time.IsZero() {
+		t.Fatalf("unexpected failure %+v", failure)
+	}
+	content, err := os.ReadFile(mediaPath)
+	if err != nil || string(content) != "not really a video" {
+		t.Fatalf("media got lost: %q %v", content, err)
+	}
+	if _, err = os.Stat(sidecarPath); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestQuarantineGetUnknown(t *testing.T) {
+	quarantine, err := NewQuarantine(t.TempDir(), time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []string{"deadbeef", "../../etc", ""} {
+		_, _, _, err = quarantine.Get(id)
+		if !errors.Is(err, ErrNoSuchFailure) {
+			t.Fatalf("expected no such failure for %q, got %v", id, err)
+		}
+	}
+}
+
+func TestQuarantineListAndExpire(t *testing.T) {
+	quarantine, err := NewQuarantine(t.TempDir(), time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	for _, age := range []time.Duration{2 * time.Hour, time.Minute, 30 * time.Minute} {
+		media := filepath.Join(t.TempDir(), "media")
+		err = os.WriteFile(media, []byte("media"), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = quarantine.Keep(media, Failure{Kind: "photo", Time: now.Add(-age)})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	failures, err := quarantine.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(failures) != 3 || !failures[0].Time.After(failures[1].Time) || !failures[1].Time.After(failures[2].Time) {
+		t.Fatalf("expected 3 failures, latest first, got %+v", failures)
+	}
+	removed, err := quarantine.Expire(now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if removed != 1 {
+		t.Fatalf("expected 1 expired failure, got %d", removed)
+	}
+	failures, err = quarantine.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(failures) != 2 {
+		t.Fatalf("expected 2 failures left, got %d", len(failures))
+	}
+}
@@ -25,15 +25,21 @@ func NewRateLimiter() *RateLimiter {
 }
 
 func (r *RateLimiter) GetRateOverPeriod(userId int64, utc int64) (int, int64) {
+	return r.GetWeightedRateOverPeriod(userId, utc, 1)
+}
+
+// GetWeightedRateOverPeriod counts the request as several ordinary ones, e.g. /distort x3 is three of them
+func (r *RateLimiter) GetWeightedRateOverPeriod(userId int64, utc int64, weight int) (int, int64) {
+	weight = max(weight, 1)
 	r.mu.Lock()
 	defer r.mu.Unlock()
 	messageRange, ok := r.usersRate[userId]
 	if !ok {
 		r.usersRate[userId] = MessageRange{
 			StartUtc: utc,
-			Count:    1,
+			Count:    weight,
 		}
-		return 1, 0
+		return weight, 0
 	}
 	var updatedStamp MessageRange
 	// crude, but will do
@@ -41,12 +47,12 @@ func (r *RateLimiter) GetRateOverPeriod(userId int64, utc int64) (int, int64) {
 	if diff < TimePeriodSeconds {
 		updatedStamp = MessageRange{
 			StartUtc: messageRange.StartUtc, // keep the original range
-			Count:    messageRange.Count + 1,
+			Count:    messageRange.Count + weight,
 		}
 	} else {
 		updatedStamp = MessageRange{
 			StartUtc: utc, // set up a new range
-			Count:    1,
+			Count:    weight,
 		}
 	}
 	r.usersRate[userId] = updatedStamp
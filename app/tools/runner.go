@@ -0,0 +1,154 @@
+package tools
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os/exec"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const stderrTail = 2048 // ffmpeg is chatty, the interesting part is at the end
+
+// Runner runs external programs, like ffmpeg and magick
+type Runner interface {
+	// Run runs the command and returns its stdout. The whole process group gets killed once ctx is done
+	Run(ctx context.Context, name string, args ...string) ([]byte, error)
+}
+
+// Limits are applied to every command separately
+type Limits struct {
+	Timeout       time.Duration // 0 for none
+	Nice          int           // 0 to leave the priority alone
+	MaxMemoryMb   int           // virtual memory, 0 for unlimited
+	MaxCPUSeconds int           // 0 for unlimited
+}
+
+type ExecRunner struct {
+	limits Limits
+}
+
+func NewExecRunner(limits Limits) *ExecRunner {
+	return &ExecRunner{limits: limits}
+}
+
+// RunError is a failed command along with whatever it had to say about it
+type RunError struct {
+	Command string
+	Err     error
+	Stderr  string
+}
+
+func (e *RunError) Error() string {
+	if e.Stderr == "" {
+		return fmt.Sprintf("%s: %v", e.Command, e.Err)
+	}
+	return fmt.Sprintf("%s: %v: %s", e.Command, e.Err, e.Stderr)
+}
+
+func (e *RunError) Unwrap() error {
+	return e.Err
+}
+
+func (r *ExecRunner) Run(ctx context.Context, name string, args ...string) ([]byte, error) {
+	if r.limits.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, r.limits.Timeout)
+		defer cancel()
+	}
+	command, commandArgs := r.limits.wrap(name, args)
+	cmd := exec.CommandContext(ctx, command, commandArgs...)
+	// ffmpeg and magick spawn helpers of their own, those have to go too
+	cmd.SysProcAttr = &syscall.SysProcAttr{
+		Setpgid: true,
+	}
+	cmd.Cancel = func() error {
+		return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+	}
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	err := cmd.Run()
+	if err == nil {
+		return stdout.Bytes(), nil
+	}
+	if ctx.Err() != nil {
+		err = ctx.Err()
+	}
+	return stdout.Bytes(), &RunError{
+		Command: name,
+		Err:     err,
+		Stderr:  tail(strings.TrimSpace(stderr.String()), stderrTail),
+	}
+}
+
+// wrap runs the command through the shell when there are limits to apply, there's no other way to set rlimits
+// for a child process in Go
+func (l Limits) wrap(name string, args []string) (string, []string) {
+	var script []string
+	if l.MaxMemoryMb > 0 {
+		script = append(script, fmt.Sprintf("ulimit -v %d", l.MaxMemoryMb*1024))
+	}
+	if l.MaxCPUSeconds > 0 {
+		script = append(script, fmt.Sprintf("ulimit -t %d", l.MaxCPUSeconds))
+	}
+	run := `exec "$@"`
+	if l.Nice != 0 {
+		run = fmt.Sprintf(`exec nice -n %d "$@"`, l.Nice)
+	}
+	if len(script) == 0 && l.Nice == 0 {
+		return name, args
+	}
+	script = append(script, run)
+	// $0 is the first argument after the script, so the command goes in as "$@" untouched by the shell
+	return "sh", append([]string{"-c", strings.Join(script, " && "), "sh", name}, args...)
+}
+
+func tail(s string, length int) string {
+	if len(s) <= length {
+		return s
+	}
+	return "..." + s[len(s)-length:]
+}
+
+// FakeRunner pretends to run commands, so that the code calling ffmpeg can be tested without ffmpeg around
+type FakeRunner struct {
+	mu     *sync.Mutex
+	calls  [][]string
+	handle func(name string, args []string) ([]byte, error)
+}
+
+// NewFakeRunner creates a runner that answers with handle. A nil handle succeeds with no output
+func NewFakeRunner(handle func(name string, args []string) ([]byte, error)) *FakeRunner {
+	return &FakeRunner{
+		mu:     &sync.Mutex{},
+		handle: handle,
+	}
+}
+
+func (f *FakeRunner) Run(ctx context.Context, name string, args ...string) ([]byte, error) {
+	f.mu.Lock()
+	f.calls = append(f.calls, append([]string{name}, args...))
+	f.mu.Unlock()
+
+	if err := ctx.Err(); err != nil {
+		return nil, &RunError{Command: name, Err: err}
+	}
+	if f.handle == nil {
+		return nil, nil
+	}
+	return f.handle(name, args)
+}
+
+// Calls returns every command that was run, the name followed by the arguments
+func (f *FakeRunner) Calls() [][]string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	calls := make([][]string, len(f.calls))
+	copy(calls, f.calls)
+	return calls
+}
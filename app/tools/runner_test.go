@@ -0,0 +1,99 @@
+package tools
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestExecRunnerOutput(t *testing.T) {
+	runner := NewExecRunner(Limits{})
+	output, err := runner.Run(context.Background(), "sh", "-c", "echo out; echo err >&2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(output) != "out\n" {
+		t.Fatalf("unexpected output %q", output)
+	}
+}
+
+func TestExecRunnerStderrInError(t *testing.T) {
+	runner := NewExecRunner(Limits{})
+	_, err := runner.Run(context.Background(), "sh", "-c", "echo 'Invalid data found when processing input' >&2; exit 1")
+	var runError *RunError
+	if !errors.As(err, &runError) {
+		t.Fatalf("expected RunError, got %v", err)
+	}
+	if !strings.Contains(err.Error(), "Invalid data found") {
+		t.Fatalf("stderr is missing from %q", err.Error())
+	}
+}
+
+func TestExecRunnerTimeoutKillsProcessGroup(t *testing.T) {
+	runner := NewExecRunner(Limits{Timeout: 100 * time.Millisecond})
+	start := time.Now()
+	// the background sleep keeps stdout open, so Run would hang if only sh got killed
+	_, err := runner.Run(context.Background(), "sh", "-c", "sleep 10 & sleep 10")
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected deadline exceeded, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Fatalf("took %s to give up", elapsed)
+	}
+}
+
+func TestExecRunnerCancel(t *testing.T) {
+	runner := NewExecRunner(Limits{})
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(100*time.Millisecond, cancel)
+	_, err := runner.Run(ctx, "sleep", "10")
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected cancellation, got %v", err)
+	}
+}
+
+func TestExecRunnerLimits(t *testing.T) {
+	runner := NewExecRunner(Limits{Nice: 5, MaxCPUSeconds: 60, MaxMemoryMb: 1024})
+	output, err := runner.Run(context.Background(), "sh", "-c", "ulimit -t; ulimit -v; nice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(output) != "60\n1048576\n5\n" {
+		t.Fatalf("limits were not applied: %q", output)
+	}
+}
+
+func TestLimitsWrap(t *testing.T) {
+	name, args := Limits{}.wrap("ffmpeg", []string{"-i", "in put"})
+	if name != "ffmpeg" || strings.Join(args, "|") != "-i|in put" {
+		t.Fatalf("no limits should mean no wrapping, got %s %v", name, args)
+	}
+	name, args = Limits{Nice: 10}.wrap("ffmpeg", []string{"-i", "in put"})
+	expected := `-c|exec nice -n 10 "$@"|sh|ffmpeg|-i|in put`
+	if name != "sh" || strings.Join(args, "|") != expected {
+		t.Fatalf("expected sh %s, got %s %s", expected, name, strings.Join(args, "|"))
+	}
+}
+
+func TestFakeRunner(t *testing.T) {
+	runner := NewFakeRunner(func(name string, args []string) ([]byte, error) {
+		if name == "ffprobe" {
+			return []byte("{}"), nil
+		}
+		return nil, errors.New("nope")
+	})
+	output, err := runner.Run(context.Background(), "ffprobe", "file")
+	if err != nil || string(output) != "{}" {
+		t.Fatalf("unexpected %q %v", output, err)
+	}
+	_, err = runner.Run(context.Background(), "ffmpeg", "-i", "file")
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+	calls := runner.Calls()
+	if len(calls) != 2 || strings.Join(calls[1], " ") != "ffmpeg -i file" {
+		t.Fatalf("unexpected calls %v", calls)
+	}
+}
@@ -7,21 +7,25 @@ import (
 
 	"github.com/google/uuid"
 	tb "gopkg.in/telebot.v3"
+
+	"github.com/graynk/distortioner/locale"
 )
 
-const progress = "Processing frames...\n<code>[----------] %d%%</code>"
+const progressBar = "\n<code>[----------] %d%%</code>"
 
-func GenerateProgressMessage(done, total int) string {
+func GenerateProgressMessage(lang string, done, total int) string {
 	fraction := float64(done) / float64(total)
-	message := fmt.Sprintf(progress, int(fraction*100))
-	return strings.Replace(message, "-", "=", int(fraction*10))
+	bar := strings.Replace(fmt.Sprintf(progressBar, int(fraction*100)), "-", "=", int(fraction*10))
+	return locale.Get(lang, locale.Processing) + bar
 }
 
 func IsMedia(m *tb.Message) bool {
 	if m == nil {
 		return false
 	}
-	return m.Photo != nil || m.Video != nil || m.VideoNote != nil || m.Voice != nil
+	// animations come with the document field set as well
+	isDocument := m.Document != nil && m.Animation == nil
+	return m.Photo != nil || m.Video != nil || m.VideoNote != nil || m.Voice != nil || m.Audio != nil || isDocument
 }
 
 func IsNonMediaMedia(m *tb.Message) bool {
@@ -31,12 +35,13 @@ func IsNonMediaMedia(m *tb.Message) bool {
 	return m.Animation != nil || m.Sticker != nil
 }
 
-func JustGetTheFile(b *tb.Bot, m *tb.Message) (string, error) {
-	filename := uuid.New().String()
+// JustGetTheFile downloads the media of the message into the job directory
+func JustGetTheFile(b *tb.Bot, api BotAPI, m *tb.Message, lang string, scratch *Scratch) (string, error) {
+	filename := scratch.Path(uuid.New().String())
 	file := m.Media().MediaFile()
-	err := b.Download(file, filename)
+	err := api.Download(b, file, filename)
 	if err != nil {
-		b.Reply(m, "Failed to download media")
+		b.Reply(m, locale.Get(lang, locale.DownloadFailed))
 	}
 
 	return filename, err
@@ -60,6 +65,6 @@ func ExtractPossibleTimeout(err error) (int, error) {
 	return strconv.Atoi(errorString[retryAfterStringEnd+len(after) : timeoutEnd])
 }
 
-func FormatRateLimitResponse(diff int64) string {
-	return fmt.Sprintf("Please, not so often. Try again in %d seconds", diff)
+func FormatRateLimitResponse(lang string, diff int64) string {
+	return locale.Get(lang, locale.RateLimited, diff)
 }
@@ -1,51 +1,106 @@
 package tools
 
 import (
+	"sync"
+
 	"github.com/graynk/distortioner/queue"
 )
 
 type VideoWorker struct {
-	queue       *queue.HonestJobQueue // the queue itself. separate from the channel, since we can't sort stuff in channels
-	messenger   chan interface{}      // if there's something in the channel - there's something in the queue.
-	workerCount int
+	queue       *queue.JobQueue  // the queue itself. separate from the channel, since we can't sort stuff in channels
+	messenger   chan interface{} // if there's something in the channel - there's something in the queue.
+	mu          *sync.Mutex
+	workerCount int // how many jobs may run at once
+	running     int // and how many goroutines there are to run them
+	closed      bool
 }
 
-func NewVideoWorker(workerCount int, priorityChats []int64) *VideoWorker {
+func NewVideoWorker(workerCount int, scheduler queue.Scheduler) *VideoWorker {
 	capacity := 300
 	worker := VideoWorker{
-		queue:       queue.NewHonestJobQueue(capacity, priorityChats),
-		messenger:   make(chan interface{}, capacity),
-		workerCount: workerCount,
-	}
-	for i := 0; i < workerCount; i++ {
-		go worker.run()
+		queue:     queue.NewJobQueue(scheduler),
+		messenger: make(chan interface{}, capacity),
+		mu:        &sync.Mutex{},
 	}
+	worker.Resize(workerCount)
 	return &worker
 }
 
+// Resize changes how many jobs may run at once. The jobs that are already running are left alone,
+// the goroutines over the limit quit as soon as they're done with them
+func (vw *VideoWorker) Resize(workerCount int) {
+	vw.mu.Lock()
+	defer vw.mu.Unlock()
+
+	vw.workerCount = workerCount
+	for ; vw.running < workerCount && !vw.closed; vw.running++ {
+		go vw.run()
+	}
+}
+
 func (vw *VideoWorker) BanUser(userID int64) {
 	vw.queue.BanUser(userID)
 }
 
+// run takes the jobs until the queue is empty, then waits for the next message
 func (vw *VideoWorker) run() {
 	for range vw.messenger {
-		job := vw.queue.Pop()
-		if job != nil {
+		for {
+			if vw.retire() {
+				vw.wake() // whatever is left in the queue is somebody else's now
+				return
+			}
+			job := vw.queue.Pop()
+			if job == nil {
+				break
+			}
 			job.Run()
 		}
 	}
 }
 
-func (vw *VideoWorker) Submit(userID int64, runnable func()) error {
-	err := vw.queue.Push(userID, runnable)
+// retire tells the goroutine to quit if there are more of them than needed
+func (vw *VideoWorker) retire() bool {
+	vw.mu.Lock()
+	defer vw.mu.Unlock()
+
+	if vw.running > vw.workerCount {
+		vw.running--
+		return true
+	}
+	return false
+}
+
+// wake lets goroutines know that there's something in the queue. If the channel is full, there are enough
+// messages on the way already: every goroutine that gets one takes the jobs until the queue is empty
+func (vw *VideoWorker) wake() {
+	vw.mu.Lock()
+	defer vw.mu.Unlock()
+
+	if vw.closed {
+		return
+	}
+	select {
+	case vw.messenger <- nil:
+	default:
+	}
+}
+
+// SubmitTier queues a job that takes that much work, treating it according to the tier of the chat, see JobQueue.PushTier
+func (vw *VideoWorker) SubmitTier(userID int64, cost float64, tier queue.Tier, runnable func()) error {
+	err := vw.queue.PushTier(userID, cost, tier, runnable)
 	if err != nil {
 		return err
 	}
-	vw.messenger <- nil // let goroutines know that there's something in the queue
+	vw.wake()
 	return nil
 }
 
 func (vw *VideoWorker) Shutdown() {
+	vw.mu.Lock()
+	defer vw.mu.Unlock()
+
+	vw.closed = true
 	close(vw.messenger)
 }
 
@@ -53,8 +108,14 @@ func (vw *VideoWorker) QueueStats() (int, int) {
 	return vw.queue.Stats()
 }
 
+// IsBusy reports whether there are more jobs queued than can run at once. With no workers at all, which happens
+// while the cluster workers come and go, the first job waiting for them is not much of a queue yet
 func (vw *VideoWorker) IsBusy() bool {
-	return vw.queue.Len() > vw.workerCount
+	vw.mu.Lock()
+	workerCount := vw.workerCount
+	vw.mu.Unlock()
+
+	return vw.queue.Len() > max(workerCount, 1)
 }
 
 func (vw *VideoWorker) ToggleMaintenance() bool {
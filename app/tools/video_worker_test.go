@@ -0,0 +1,112 @@
+package tools
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/graynk/distortioner/queue"
+)
+
+// runningJobs submits jobs that block until released, counting how many of them run at once
+type runningJobs struct {
+	mu      sync.Mutex
+	running int
+	release chan struct{}
+	done    sync.WaitGroup
+}
+
+func (r *runningJobs) submit(t *testing.T, vw *VideoWorker, count int) {
+	for i := 0; i < count; i++ {
+		r.done.Add(1)
+		err := vw.SubmitTier(int64(i), 1, queue.PriorityTier, func() {
+			defer r.done.Done()
+			r.mu.Lock()
+			r.running++
+			r.mu.Unlock()
+			<-r.release
+			r.mu.Lock()
+			r.running--
+			r.mu.Unlock()
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// waitRunning waits for that many jobs to be running and makes sure it stays that way
+func (r *runningJobs) waitRunning(t *testing.T, expected int) {
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		r.mu.Lock()
+		running := r.running
+		r.mu.Unlock()
+		if running == expected {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%d jobs are running, expected %d", running, expected)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	time.Sleep(50 * time.Millisecond)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.running != expected {
+		t.Fatalf("%d jobs are running, expected %d", r.running, expected)
+	}
+}
+
+func TestVideoWorkerResize(t *testing.T) {
+	vw := NewVideoWorker(0, queue.NewFIFOScheduler())
+	defer vw.Shutdown()
+	jobs := &runningJobs{release: make(chan struct{})}
+	jobs.submit(t, vw, 6)
+	jobs.waitRunning(t, 0)
+
+	vw.Resize(2)
+	jobs.waitRunning(t, 2)
+	vw.Resize(4)
+	jobs.waitRunning(t, 4)
+
+	vw.Resize(1)
+	jobs.release <- struct{}{}
+	jobs.release <- struct{}{}
+	jobs.release <- struct{}{}
+	jobs.waitRunning(t, 1) // the goroutines over the limit quit instead of taking the last two jobs
+	jobs.release <- struct{}{}
+	jobs.waitRunning(t, 1)
+	jobs.release <- struct{}{}
+	jobs.waitRunning(t, 1)
+	jobs.release <- struct{}{}
+	jobs.done.Wait()
+}
+
+func TestVideoWorkerIsBusy(t *testing.T) {
+	vw := NewVideoWorker(0, queue.NewFIFOScheduler())
+	defer vw.Shutdown()
+	jobs := &runningJobs{release: make(chan struct{})}
+	jobs.submit(t, vw, 1)
+	if vw.IsBusy() {
+		t.Fatal("a single job waiting for the workers to show up is not busy")
+	}
+	jobs.submit(t, vw, 1)
+	if !vw.IsBusy() {
+		t.Fatal("two jobs with nobody to run them are")
+	}
+
+	vw.Resize(2)
+	jobs.waitRunning(t, 2)
+	if vw.IsBusy() {
+		t.Fatal("both jobs are running")
+	}
+	jobs.submit(t, vw, 3)
+	if !vw.IsBusy() {
+		t.Fatal("three jobs are waiting for two workers")
+	}
+	for i := 0; i < 5; i++ {
+		jobs.release <- struct{}{}
+	}
+	jobs.done.Wait()
+}
@@ -0,0 +1,125 @@
+package tools
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+const jobDirPattern = "job"
+
+var ErrQuotaExceeded = errors.New("scratch space quota exceeded")
+
+// Workspace keeps all the files of the jobs under a single root, so that nothing ends up in the working
+// directory and whatever got left behind after a crash can be swept on the next start.
+// The root can just as well be a tmpfs mount
+type Workspace struct {
+	root  string
+	quota int64 // bytes, 0 means unlimited
+	mu    *sync.Mutex
+}
+
+// NewWorkspace creates the root if needed. quotaMb limits how much the jobs can take in total, 0 for no limit
+func NewWorkspace(root string, quotaMb int64) (*Workspace, error) {
+	err := os.MkdirAll(root, 0755)
+	if err != nil {
+		return nil, err
+	}
+	return &Workspace{
+		root:  root,
+		quota: quotaMb * 1024 * 1024,
+		mu:    &sync.Mutex{},
+	}, nil
+}
+
+func (w *Workspace) Root() string {
+	return w.root
+}
+
+// Sweep removes the job directories left under the root. Only meant to be called on startup, before any jobs are running.
+// Anything else in the root is left alone, in case it got pointed somewhere it shouldn't have been.
+// Returns the amount of orphans removed
+func (w *Workspace) Sweep() (int, error) {
+	entries, err := os.ReadDir(w.root)
+	if err != nil {
+		return 0, err
+	}
+	removed := 0
+	for _, entry := range entries {
+		if !entry.IsDir() || !strings.HasPrefix(entry.Name(), jobDirPattern) {
+			continue
+		}
+		err = os.RemoveAll(filepath.Join(w.root, entry.Name()))
+		if err != nil {
+			return removed, err
+		}
+		removed++
+	}
+	return removed, nil
+}
+
+// Usage is the total size of the files under the root, in bytes
+func (w *Workspace) Usage() (int64, error) {
+	var usage int64
+	err := filepath.WalkDir(w.root, func(path string, entry fs.DirEntry, err error) error {
+		if errors.Is(err, fs.ErrNotExist) {
+			// somebody finished their job while we were walking
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if entry.IsDir() {
+			return nil
+		}
+		info, err := entry.Info()
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		usage += info.Size()
+		return nil
+	})
+	return usage, err
+}
+
+// NewJob creates a fresh directory for a single job, or returns ErrQuotaExceeded if the scratch space is full
+func (w *Workspace) NewJob() (*Scratch, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.quota > 0 {
+		usage, err := w.Usage()
+		if err != nil {
+			return nil, err
+		}
+		if usage >= w.quota {
+			return nil, ErrQuotaExceeded
+		}
+	}
+	dir, err := os.MkdirTemp(w.root, jobDirPattern)
+	if err != nil {
+		return nil, err
+	}
+	return &Scratch{dir: dir}, nil
+}
+
+// Scratch is the directory of a single job. Everything in it is gone once the job calls Close
+type Scratch struct {
+	dir string
+}
+
+func (s *Scratch) Dir() string {
+	return s.dir
+}
+
+// Path returns the path to a file inside the job directory
+func (s *Scratch) Path(name string) string {
+	return filepath.Join(s.dir, name)
+}
+
+func (s *Scratch) Close() error {
+	return os.RemoveAll(s.dir)
+}
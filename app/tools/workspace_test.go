@@ -0,0 +1,96 @@
+package tools
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWorkspaceJob(t *testing.T) {
+	workspace, err := NewWorkspace(filepath.Join(t.TempDir(), "scratch"), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	scratch, err := workspace.NewJob()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if filepath.Dir(scratch.Dir()) != workspace.Root() {
+		t.Fatalf("job directory %s is outside of %s", scratch.Dir(), workspace.Root())
+	}
+	err = os.Mkdir(scratch.Path("Frames"), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.WriteFile(filepath.Join(scratch.Path("Frames"), "0001.png"), []byte("frame"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = scratch.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = os.Stat(scratch.Dir()); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("job directory is still there: %v", err)
+	}
+}
+
+func TestWorkspaceSweep(t *testing.T) {
+	root := t.TempDir()
+	err := os.WriteFile(filepath.Join(root, "distortioner.db"), []byte("not ours"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.MkdirAll(filepath.Join(root, "job123", "orphanFrames"), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.MkdirAll(filepath.Join(root, "job456"), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	workspace, err := NewWorkspace(root, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	removed, err := workspace.Sweep()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if removed != 2 {
+		t.Fatalf("expected 2 orphans removed, got %d", removed)
+	}
+	entries, err := os.ReadDir(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Name() != "distortioner.db" {
+		t.Fatalf("unexpected leftovers after the sweep: %v", entries)
+	}
+}
+
+func TestWorkspaceQuota(t *testing.T) {
+	workspace, err := NewWorkspace(t.TempDir(), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	scratch, err := workspace.NewJob()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.WriteFile(scratch.Path("video.mp4"), make([]byte, 1024*1024), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = workspace.NewJob()
+	if !errors.Is(err, ErrQuotaExceeded) {
+		t.Fatalf("expected quota error, got %v", err)
+	}
+	scratch.Close()
+	another, err := workspace.NewJob()
+	if err != nil {
+		t.Fatalf("space should be free again: %v", err)
+	}
+	another.Close()
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	tb "gopkg.in/telebot.v3"
+
+	"github.com/graynk/distortioner/distorters"
+	"github.com/graynk/distortioner/locale"
+)
+
+const clippedKey = "clipped"
+
+// clipped is the part of the video that actually gets distorted. Kept apart from the arguments,
+// so that whatever the user asked for stays as it was for the rest of the handling
+type clipped struct {
+	Start   float64
+	End     float64
+	Trimmed bool // whether it's shorter than the whole video
+}
+
+// clip fits the requested range into the video and the limit, remembering the result for the rest of the handling
+func (d DistorterBot) clip(c tb.Context, info distorters.MediaInfo, limit float64) error {
+	options, trimmed, err := d.options(c).Clip(info.Duration, limit)
+	if err != nil {
+		return err
+	}
+	c.Set(clippedKey, clipped{Start: options.Start, End: options.Start + options.Length, Trimmed: trimmed})
+	return nil
+}
+
+// clipOf returns the range clip settled on, if it was called
+func clipOf(c tb.Context) (clipped, bool) {
+	clip, ok := c.Get(clippedKey).(clipped)
+	return clip, ok
+}
+
+// badRange lets the user know the range starts past the end of the video, in place of the progress message if there is one.
+// Returns the progress message if it's still left for the caller to deal with
+func (d DistorterBot) badRange(c tb.Context, progressMessage *tb.Message, info distorters.MediaInfo) *tb.Message {
+	text := locale.Get(d.language(c), locale.BadRange, distorters.FormatTimestamp(info.Duration))
+	if progressMessage == nil {
+		d.notify(c, text)
+		return nil
+	}
+	_, err := c.Bot().Edit(progressMessage, text)
+	if err != nil {
+		d.logger.Error(err)
+		return progressMessage
+	}
+	return nil
+}
+
+// trimNote says which part of the video was distorted, if it wasn't the whole thing
+func (d DistorterBot) trimNote(c tb.Context) string {
+	clip, _ := clipOf(c)
+	if !clip.Trimmed {
+		return ""
+	}
+	return locale.Get(d.language(c), locale.Trimmed, distorters.FormatTimestamp(clip.Start), distorters.FormatTimestamp(clip.End))
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/graynk/distortioner/cluster"
+	"github.com/graynk/distortioner/distorters"
+	"github.com/graynk/distortioner/tools"
+)
+
+// videoProcessor runs the video jobs, either in the bot itself or on the workers. Closes the channel when done
+type videoProcessor interface {
+	Process(ctx context.Context, job distorters.VideoJob, filename, output string, progressChan chan string) error
+}
+
+// localProcessor runs the jobs right here, which is how it goes unless DISTORTIONER_WORKER_LISTEN is set
+type localProcessor struct {
+	encoders *distorters.Encoders
+}
+
+func (p localProcessor) Process(ctx context.Context, job distorters.VideoJob, filename, output string, progressChan chan string) error {
+	return job.Run(ctx, p.encoders, filename, output, progressChan)
+}
+
+// remoteProcessor hands the jobs over to the `distortioner worker` processes
+type remoteProcessor struct {
+	coordinator *cluster.Coordinator
+}
+
+func (p remoteProcessor) Process(ctx context.Context, job distorters.VideoJob, filename, output string, progressChan chan string) error {
+	err := p.coordinator.Process(ctx, job, filename, output, progressChan)
+	var taskError *cluster.TaskError
+	if errors.As(err, &taskError) && taskError.Code != "" {
+		// the worker said at which stage it failed, the quarantine wants to know that as well
+		return &distorters.StageError{Stage: distorters.Stage(taskError.Code), Err: err}
+	}
+	return err
+}
+
+// leaseTimeout reads DISTORTIONER_WORKER_LEASE, which is how long a worker may stay silent before its job goes to another one
+func leaseTimeout() (time.Duration, error) {
+	value := os.Getenv("DISTORTIONER_WORKER_LEASE")
+	if value == "" {
+		return cluster.DefaultLeaseTimeout, nil
+	}
+	timeout, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("DISTORTIONER_WORKER_LEASE: %w", err)
+	}
+	if timeout <= 0 {
+		return 0, fmt.Errorf("DISTORTIONER_WORKER_LEASE: %s is not a positive duration", value)
+	}
+	return timeout, nil
+}
+
+// startCoordinator listens for the workers on addr until the context is cancelled. The video worker runs as many jobs
+// at once as the workers have slots to take them, so that nothing gets downloaded long before anybody can distort it
+func startCoordinator(ctx context.Context, addr string, videoWorker *tools.VideoWorker, logger *zap.SugaredLogger) (videoProcessor, error) {
+	token := os.Getenv("DISTORTIONER_WORKER_TOKEN")
+	if token == "" {
+		logger.Warn("DISTORTIONER_WORKER_TOKEN is not set, anybody who can reach " + addr + " can take the jobs")
+	}
+	timeout, err := leaseTimeout()
+	if err != nil {
+		return nil, err
+	}
+	coordinator := cluster.NewCoordinator(token, timeout)
+	coordinator.OnSlots(func(slots int) {
+		logger.Infow("worker slots changed", "slots", slots)
+		videoWorker.Resize(slots)
+	})
+	coordinator.StartExpiring(ctx)
+	server := &http.Server{Addr: addr, Handler: coordinator}
+	go func() {
+		err := server.ListenAndServe()
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logger.Fatal(err)
+		}
+	}()
+	go func() {
+		<-ctx.Done()
+		server.Close()
+	}()
+	logger.Infow("waiting for the workers", "address", addr)
+	return remoteProcessor{coordinator: coordinator}, nil
+}
+
+// videoJobHandler runs the jobs from the bot, telling it the stage they failed at
+func videoJobHandler(encoders *distorters.Encoders) cluster.Handler {
+	return func(ctx context.Context, spec json.RawMessage, input, output string, progressChan chan string) error {
+		var job distorters.VideoJob
+		if err := json.Unmarshal(spec, &job); err != nil {
+			close(progressChan)
+			return err
+		}
+		err := job.Run(ctx, encoders, input, output, progressChan)
+		if stage := distorters.StageOf(err); stage != "" {
+			return &cluster.TaskError{Code: string(stage), Message: err.Error()}
+		}
+		return err
+	}
+}
+
+// runWorker is `distortioner worker`: distorts the videos for the bot at DISTORTIONER_WORKER_URL until it's stopped.
+// DISTORTIONER_WORKER_SLOTS is how many at once, 3 by default
+func runWorker(logger *zap.SugaredLogger) {
+	url := os.Getenv("DISTORTIONER_WORKER_URL")
+	if url == "" {
+		logger.Fatal("DISTORTIONER_WORKER_URL variable is not set")
+	}
+	slots := 3
+	if value := os.Getenv("DISTORTIONER_WORKER_SLOTS"); value != "" {
+		var err error
+		slots, err = strconv.Atoi(value)
+		if err != nil || slots < 1 {
+			logger.Fatalf("DISTORTIONER_WORKER_SLOTS: %q is not a positive number", value)
+		}
+	}
+	limits, err := runnerLimits()
+	if err != nil {
+		logger.Fatal(err)
+	}
+	distorters.SetRunner(tools.NewExecRunner(limits))
+	workspace, err := newWorkspace(logger, "distortioner-worker")
+	if err != nil {
+		logger.Fatal(err)
+	}
+	// whatever is running when we're stopped goes to the other workers once the leases expire
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+
+	encoders, err := newEncoders(ctx, logger)
+	if err != nil {
+		logger.Fatal(err)
+	}
+
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "worker"
+	}
+	name := fmt.Sprintf("%s-%d", hostname, os.Getpid())
+	worker := cluster.NewWorker(url, os.Getenv("DISTORTIONER_WORKER_TOKEN"), name, slots, workspace, videoJobHandler(encoders), logger)
+	logger.Infow("working for the bot", "url", url, "name", name, "slots", slots)
+	worker.Run(ctx)
+	logger.Info("shutdown")
+}